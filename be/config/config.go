@@ -10,6 +10,11 @@ type Config struct {
 	Database DatabaseConfig `mapstructure:"database"`
 	Storage  StorageConfig  `mapstructure:"storage"`
 	RTSP     RTSPConfig     `mapstructure:"rtsp"`
+	WebRTC   WebRTCConfig   `mapstructure:"webrtc"`
+	Restream RestreamConfig `mapstructure:"restream"`
+	HLS      HLSConfig      `mapstructure:"hls"`
+	MJPEG    MJPEGConfig    `mapstructure:"mjpeg"`
+	Tags     TagsConfig     `mapstructure:"tags"`
 	JWT      JWTConfig      `mapstructure:"jwt"`
 	Log      LogConfig      `mapstructure:"log"`
 }
@@ -39,13 +44,54 @@ type StorageConfig struct {
 	TempPath  string `mapstructure:"temp_path"`
 
 	// S3配置
-	S3 struct {
-		Endpoint        string `mapstructure:"endpoint"`
-		AccessKeyID     string `mapstructure:"access_key_id"`
-		SecretAccessKey string `mapstructure:"secret_access_key"`
-		BucketName      string `mapstructure:"bucket_name"`
-		Region          string `mapstructure:"region"`
-	} `mapstructure:"s3"`
+	S3 S3StorageConfig `mapstructure:"s3"`
+
+	LifecycleInterval time.Duration `mapstructure:"lifecycle_interval"` // 执行存储生命周期规则的间隔
+	TrashDays         int           `mapstructure:"trash_days"`         // 删除的录像在回收站保留的天数，0 表示直接删除
+	ReconcileInterval time.Duration `mapstructure:"reconcile_interval"` // 核对存储文件与录像记录的间隔，0 表示只手动执行
+	ImportPaths       []string      `mapstructure:"import_paths"`       // 允许通过接口导入录像的目录，命令行导入不受限制
+
+	Retention RetentionConfig `mapstructure:"retention"`
+	Upload    UploadConfig    `mapstructure:"upload"`
+	Thumbnail ThumbnailConfig `mapstructure:"thumbnail"`
+	Clip      ClipConfig      `mapstructure:"clip"`
+}
+
+// 录像片段导出
+type ClipConfig struct {
+	MaxDuration time.Duration `mapstructure:"max_duration"` // 单次导出的最长时间段
+	Expire      time.Duration `mapstructure:"expire"`       // 导出的文件保留时间，到期后删除
+}
+
+// 录像封面图和拖动预览图
+type ThumbnailConfig struct {
+	Enabled        bool    `mapstructure:"enabled"`
+	SpriteInterval float64 `mapstructure:"sprite_interval"` // 预览图间隔（秒），录像较长时自动加大
+}
+
+// 录像上传配置
+type UploadConfig struct {
+	MaxSizeGB float64       `mapstructure:"max_size_gb"` // 单个文件的大小上限
+	Expire    time.Duration `mapstructure:"expire"`      // 分片上传超过该时间没有新分片时清除
+}
+
+// 录像保留策略，车间可以单独设置保留天数和容量上限
+type RetentionConfig struct {
+	Schedule      string  `mapstructure:"schedule"`       // 执行清理的 cron 表达式
+	DefaultDays   int     `mapstructure:"default_days"`   // 车间未设置时的保留天数，0 表示不按时间清理
+	HighWatermark float64 `mapstructure:"high_watermark"` // 本地磁盘使用率（百分比）超过该值时紧急清理
+	LowWatermark  float64 `mapstructure:"low_watermark"`  // 紧急清理到使用率低于该值为止
+}
+
+// S3 兼容对象存储配置，MinIO 等私有部署需开启 force_path_style
+type S3StorageConfig struct {
+	Endpoint        string `mapstructure:"endpoint"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	BucketName      string `mapstructure:"bucket_name"`
+	Region          string `mapstructure:"region"`
+	ForcePathStyle  bool   `mapstructure:"force_path_style"`
+	StorageClass    string `mapstructure:"storage_class"` // 如 STANDARD_IA，需可直接读取，不支持 GLACIER 等需要解冻的类型
 }
 
 type RTSPConfig struct {
@@ -53,6 +99,57 @@ type RTSPConfig struct {
 	FFprobePath   string        `mapstructure:"ffprobe_path"`
 	Timeout       time.Duration `mapstructure:"timeout"`
 	SegmentLength int           `mapstructure:"segment_length"` // 视频分段长度(秒)
+
+	Transcode TranscodeConfig `mapstructure:"transcode"`
+}
+
+// 实时预览转码配置，用于浏览器无法解码的摄像头编码（如 H265）
+type TranscodeConfig struct {
+	MaxWidth   int    `mapstructure:"max_width"`
+	MaxHeight  int    `mapstructure:"max_height"`
+	MaxBitrate int    `mapstructure:"max_bitrate"` // kbps
+	Preset     string `mapstructure:"preset"`      // x264 preset
+}
+
+// 实时预览码流自适应配置
+type WebRTCConfig struct {
+	DefaultQuality string  `mapstructure:"default_quality"` // main, sub, auto
+	DowngradeLoss  float64 `mapstructure:"downgrade_loss"`  // 丢包率高于该值时切换到子码流
+	UpgradeLoss    float64 `mapstructure:"upgrade_loss"`    // 丢包率持续低于该值时切回主码流
+	MinBitrate     int     `mapstructure:"min_bitrate"`     // kbps，带宽估计低于该值时切换到子码流
+
+	TagInterval time.Duration `mapstructure:"tag_interval"` // 数据通道推送工业数据的间隔
+}
+
+// RTSP 转发服务配置，向 MES、分析盒子等下游系统提供摄像头画面，
+// 所有下游共用平台的拉流，不再直接连接摄像头
+type RestreamConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Address string `mapstructure:"address"` // 监听地址，如 :8554
+}
+
+// 实时 HLS 配置，有观看者时按需启动，空闲后停止并清理切片
+type HLSConfig struct {
+	Dir             string        `mapstructure:"dir"`              // 切片临时目录
+	SegmentDuration time.Duration `mapstructure:"segment_duration"` // 切片目标时长
+	PartDuration    time.Duration `mapstructure:"part_duration"`    // LL-HLS 分片时长
+	ListSize        int           `mapstructure:"list_size"`        // 播放列表保留的切片数
+	LowLatency      bool          `mapstructure:"low_latency"`      // 是否输出 LL-HLS 分片
+	IdleTimeout     time.Duration `mapstructure:"idle_timeout"`     // 无请求多久后停止
+}
+
+// MJPEG 画面配置，供不支持 WebRTC/HLS 的简单客户端使用
+type MJPEGConfig struct {
+	FPS      int `mapstructure:"fps"`
+	Quality  int `mapstructure:"quality"`   // JPEG 质量，2-31，越小越好
+	MaxWidth int `mapstructure:"max_width"` // 超过该宽度时等比缩小
+
+	SnapshotCache time.Duration `mapstructure:"snapshot_cache"` // 截图缓存时长，避免看板频繁刷新时重复解码
+}
+
+// 工业数据采集配置
+type TagsConfig struct {
+	IngestToken string `mapstructure:"ingest_token"` // 采集端上报读数时携带的设备令牌，未配置时拒绝上报
 }
 
 type JWTConfig struct {
@@ -3,7 +3,9 @@ package config
 const (
 	// 视频状态
 	VideoStatusNormal  = 1
-	VideoStatusDeleted = 2
+	VideoStatusDeleted = 2 // 在回收站中
+	VideoStatusPurging = 3 // 正在彻底删除，文件删除后删除记录
+	VideoStatusMissing = 4 // 存储中找不到录像文件
 
 	// 车间状态
 	WorkshopStatusOffline = 0
@@ -13,11 +15,29 @@ const (
 	RecordingStatusStopped = 0
 	RecordingStatusRunning = 1
 
+	// 实时预览码流
+	StreamQualityMain = "main"
+	StreamQualitySub  = "sub"
+	StreamQualityAuto = "auto"
+
+	// 用户角色
+	RoleAdmin = "admin"
+
 	// 存储类型
 	StorageTypeLocal = "local"
 	StorageTypeS3    = "s3"
 	StorageTypeOSS   = "oss"
 
+	// 存储目标状态
+	StorageStatusEnabled  = 1
+	StorageStatusDisabled = 2
+
+	// 片段导出状态
+	ClipStatusWaiting   = "waiting"
+	ClipStatusRunning   = "running"
+	ClipStatusCompleted = "completed"
+	ClipStatusFailed    = "failed"
+
 	// 文件类型
 	FileTypeVideo = "video"
 	FileTypeImage = "image"
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/services"
+	"videodb/be/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ClipHandler struct {
+	clipService     *services.ClipService
+	workshopService *services.WorkshopService
+}
+
+func NewClipHandler(cs *services.ClipService, ws *services.WorkshopService) *ClipHandler {
+	return &ClipHandler{
+		clipService:     cs,
+		workshopService: ws,
+	}
+}
+
+// @Summary 导出录像片段
+// @Description 截取车间在时间段内的录像并拼接为一个 MP4 文件，在后台依次导出，通过任务查看进度，完成后返回下载地址
+// @Tags 片段导出
+// @Accept json
+// @Produce json
+// @Param body body models.ClipRequest true "车间和时间段"
+// @Success 200 {object} utils.Response
+// @Router /api/clips [post]
+func (h *ClipHandler) Create(c *gin.Context) {
+	var req models.ClipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), req.WorkshopID); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	clip, err := h.clipService.Create(&req, c.GetUint("userId"))
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, clip)
+}
+
+// @Summary 获取导出任务列表
+// @Description 当前用户的导出任务，管理员可以查看所有任务
+// @Tags 片段导出
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response
+// @Router /api/clips [get]
+func (h *ClipHandler) List(c *gin.Context) {
+	userID := c.GetUint("userId")
+	if c.GetString("role") == config.RoleAdmin {
+		userID = 0
+	}
+
+	clips, err := h.clipService.List(userID)
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, clips)
+}
+
+// @Summary 获取导出任务
+// @Description 导出状态和进度（百分比），完成后 downloadUrl 为下载地址
+// @Tags 片段导出
+// @Accept json
+// @Produce json
+// @Param id path int true "任务ID"
+// @Success 200 {object} utils.Response
+// @Router /api/clips/{id} [get]
+func (h *ClipHandler) Get(c *gin.Context) {
+	clip, ok := h.ownClip(c)
+	if !ok {
+		return
+	}
+
+	utils.Success(c, clip)
+}
+
+// @Summary 下载导出的片段
+// @Description 下载导出完成的 MP4 文件，浏览器直接下载时可以通过 token 参数传递登录凭证
+// @Tags 片段导出
+// @Produce video/mp4
+// @Param id path int true "任务ID"
+// @Success 200 {file} binary
+// @Router /api/clips/{id}/download [get]
+func (h *ClipHandler) Download(c *gin.Context) {
+	clip, ok := h.ownClip(c)
+	if !ok {
+		return
+	}
+	if clip.Status != config.ClipStatusCompleted {
+		utils.Error(c, utils.ErrInvalidStatus)
+		return
+	}
+
+	c.FileAttachment(h.clipService.File(clip), clip.FileName)
+}
+
+// @Summary 删除导出任务
+// @Description 删除导出任务和导出的文件，正在导出的任务不能删除
+// @Tags 片段导出
+// @Accept json
+// @Produce json
+// @Param id path int true "任务ID"
+// @Success 200 {object} utils.Response
+// @Router /api/clips/{id} [delete]
+func (h *ClipHandler) Delete(c *gin.Context) {
+	clip, ok := h.ownClip(c)
+	if !ok {
+		return
+	}
+
+	if err := h.clipService.Delete(clip); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
+// ownClip 获取当前用户的导出任务，管理员可以操作所有任务
+func (h *ClipHandler) ownClip(c *gin.Context) (*models.Clip, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return nil, false
+	}
+
+	clip, err := h.clipService.Get(uint(id))
+	if err != nil {
+		utils.Error(c, err)
+		return nil, false
+	}
+	if clip.UserID != c.GetUint("userId") && c.GetString("role") != config.RoleAdmin {
+		utils.Error(c, utils.ErrForbidden)
+		return nil, false
+	}
+	return clip, true
+}
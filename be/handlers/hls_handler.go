@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"videodb/be/services"
+	"videodb/be/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HLSHandler struct {
+	hlsService      *services.HLSService
+	workshopService *services.WorkshopService
+}
+
+func NewHLSHandler(hs *services.HLSService, ws *services.WorkshopService) *HLSHandler {
+	return &HLSHandler{
+		hlsService:      hs,
+		workshopService: ws,
+	}
+}
+
+// @Summary 实时 HLS
+// @Description 获取车间实时画面的 HLS 播放列表（index.m3u8）、初始化段及切片，首次请求时启动切片，
+// @Description 播放器无法设置请求头时可通过 token 参数传递登录凭证
+// @Tags 车间管理
+// @Produce application/vnd.apple.mpegurl
+// @Param id path int true "车间ID"
+// @Param file path string true "文件名"
+// @Param token query string false "登录凭证"
+// @Param _HLS_msn query int false "LL-HLS 阻塞刷新的切片序号"
+// @Param _HLS_part query int false "LL-HLS 阻塞刷新的分片序号"
+// @Success 200 {file} file
+// @Router /live/{id}/{file} [get]
+func (h *HLSHandler) Serve(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid id format")
+		return
+	}
+
+	// 检查当前用户是否有权限查看该车间
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), uint(id)); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		c.String(status, err.Error())
+		return
+	}
+
+	file := c.Param("file")
+	if file == "index.m3u8" {
+		h.playlist(c, uint(id))
+		return
+	}
+
+	data, err := h.hlsService.File(uint(id), file)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+
+	contentType := "video/iso.segment"
+	if strings.HasSuffix(file, ".mp4") {
+		contentType = "video/mp4"
+	}
+	c.Header("Cache-Control", "max-age=60")
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func (h *HLSHandler) playlist(c *gin.Context, workshopID uint) {
+	msn, part := -1, -1
+	if v := c.Query("_HLS_msn"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.String(http.StatusBadRequest, "invalid _HLS_msn")
+			return
+		}
+		msn = n
+	}
+	if v := c.Query("_HLS_part"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || msn < 0 {
+			c.String(http.StatusBadRequest, "invalid _HLS_part")
+			return
+		}
+		part = n
+	}
+
+	data, err := h.hlsService.Playlist(c.Request.Context(), workshopID, msn, part, c.Query("token"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrStreamNotReady) {
+			status = http.StatusServiceUnavailable
+		}
+		c.String(status, err.Error())
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", data)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"videodb/be/services"
+	"videodb/be/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MJPEGHandler struct {
+	mjpegService    *services.MJPEGService
+	workshopService *services.WorkshopService
+}
+
+func NewMJPEGHandler(ms *services.MJPEGService, ws *services.WorkshopService) *MJPEGHandler {
+	return &MJPEGHandler{
+		mjpegService:    ms,
+		workshopService: ws,
+	}
+}
+
+// @Summary MJPEG 实时画面
+// @Description 以 multipart/x-mixed-replace 推送摄像头（车间）实时画面，可直接用于 img 标签，
+// @Description 无法设置请求头时可通过 token 参数传递登录凭证
+// @Tags 车间管理
+// @Produce multipart/x-mixed-replace
+// @Param id path int true "车间ID"
+// @Param fps query int false "帧率，不超过配置的 mjpeg.fps"
+// @Param token query string false "登录凭证"
+// @Success 200 {file} file
+// @Router /api/cameras/{id}/mjpeg [get]
+func (h *MJPEGHandler) Stream(c *gin.Context) {
+	id, ok := h.workshopID(c)
+	if !ok {
+		return
+	}
+
+	// 客户端指定更低的帧率时跳过多余的帧
+	var interval time.Duration
+	if v := c.Query("fps"); v != "" {
+		fps, err := strconv.Atoi(v)
+		if err != nil || fps <= 0 {
+			c.String(http.StatusBadRequest, "invalid fps")
+			return
+		}
+		if fps < h.mjpegService.MaxFPS() {
+			interval = time.Second / time.Duration(fps)
+		}
+	}
+
+	sub, err := h.mjpegService.Subscribe(id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer sub.Close()
+
+	c.Header("Content-Type", "multipart/x-mixed-replace; boundary=frame")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	var lastSent time.Time
+	for {
+		select {
+		case frame := <-sub.Frames():
+			if interval > 0 && time.Since(lastSent) < interval {
+				continue
+			}
+			lastSent = time.Now()
+			if _, err := fmt.Fprintf(w, "--frame\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", len(frame)); err != nil {
+				return
+			}
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			if _, err := w.Write([]byte("\r\n")); err != nil {
+				return
+			}
+			w.Flush()
+		case <-sub.Done():
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// @Summary 实时截图
+// @Description 获取摄像头（车间）当前画面的一帧 JPEG，短时间内的重复请求返回缓存的图像
+// @Tags 车间管理
+// @Produce image/jpeg
+// @Param id path int true "车间ID"
+// @Param token query string false "登录凭证"
+// @Success 200 {file} file
+// @Router /api/cameras/{id}/snapshot.jpg [get]
+func (h *MJPEGHandler) Snapshot(c *gin.Context) {
+	id, ok := h.workshopID(c)
+	if !ok {
+		return
+	}
+
+	frame, err := h.mjpegService.Snapshot(c.Request.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrStreamNotReady) {
+			status = http.StatusServiceUnavailable
+		}
+		c.String(status, err.Error())
+		return
+	}
+
+	c.Header("Cache-Control", "max-age=1")
+	c.Data(http.StatusOK, "image/jpeg", frame)
+}
+
+// workshopID 解析车间ID并检查当前用户是否有权限查看该车间
+func (h *MJPEGHandler) workshopID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid id format")
+		return 0, false
+	}
+
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), uint(id)); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		c.String(status, err.Error())
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+
+	"videodb/be/services"
+	"videodb/be/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RetentionHandler struct {
+	retentionService *services.RetentionService
+}
+
+func NewRetentionHandler(rs *services.RetentionService) *RetentionHandler {
+	return &RetentionHandler{retentionService: rs}
+}
+
+// @Summary 预览录像清理
+// @Description 按当前保留策略和磁盘水位列出将被删除的录像及原因，不执行删除
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response
+// @Router /api/retention/preview [get]
+func (h *RetentionHandler) Preview(c *gin.Context) {
+	plan, err := h.retentionService.Preview(c.Request.Context())
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, plan)
+}
+
+// @Summary 立即执行录像清理
+// @Description 按保留策略和磁盘水位立即删除录像，返回实际删除的录像
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response
+// @Router /api/retention/run [post]
+func (h *RetentionHandler) Run(c *gin.Context) {
+	plan, started, err := h.retentionService.Run(c.Request.Context())
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+	if !started {
+		utils.Error(c, fmt.Errorf("retention is already running"))
+		return
+	}
+
+	utils.Success(c, plan)
+}
@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"videodb/be/models"
+	"videodb/be/services"
+	"videodb/be/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StorageHandler struct {
+	storageService   *services.StorageService
+	lifecycleService *services.StorageLifecycleService
+	reconcileService *services.ReconcileService
+}
+
+func NewStorageHandler(ss *services.StorageService, ls *services.StorageLifecycleService, rs *services.ReconcileService) *StorageHandler {
+	return &StorageHandler{
+		storageService:   ss,
+		lifecycleService: ls,
+		reconcileService: rs,
+	}
+}
+
+// @Summary 获取存储目标列表
+// @Description 获取所有录像存储目标，配置中不包含密钥
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response
+// @Router /api/storages [get]
+func (h *StorageHandler) List(c *gin.Context) {
+	storages, err := h.storageService.List()
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, storages)
+}
+
+// @Summary 创建存储目标
+// @Description 创建录像存储目标，config 为对应类型的 JSON 配置（models.LocalConfig/S3Config/OSSConfig）
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Param body body models.Storage true "存储目标"
+// @Success 200 {object} utils.Response
+// @Router /api/storages [post]
+func (h *StorageHandler) Create(c *gin.Context) {
+	var storage models.Storage
+	if err := c.ShouldBindJSON(&storage); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	if err := h.storageService.Create(&storage); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, storage)
+}
+
+// @Summary 更新存储目标
+// @Description 更新存储目标的名称、配置和状态，配置中未填写密钥时沿用原密钥，存储类型不能修改
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Param id path int true "存储目标ID"
+// @Param body body models.Storage true "存储目标"
+// @Success 200 {object} utils.Response
+// @Router /api/storages/{id} [put]
+func (h *StorageHandler) Update(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	var storage models.Storage
+	if err := c.ShouldBindJSON(&storage); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	if err := h.storageService.Update(uint(id), &storage); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, storage)
+}
+
+// @Summary 删除存储目标
+// @Description 删除存储目标，仍有录像或车间使用时不能删除
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Param id path int true "存储目标ID"
+// @Success 200 {object} utils.Response
+// @Router /api/storages/{id} [delete]
+func (h *StorageHandler) Delete(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	if err := h.storageService.Delete(uint(id)); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
+// @Summary 设置默认存储目标
+// @Description 未指定存储的车间，新录像写入默认存储目标
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Param id path int true "存储目标ID"
+// @Success 200 {object} utils.Response
+// @Router /api/storages/{id}/default [post]
+func (h *StorageHandler) SetDefault(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	if err := h.storageService.SetDefault(uint(id)); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
+// @Summary 测试存储连接
+// @Description 使用提交的配置写入、读取并删除一个测试文件，用于保存前检查配置
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Param body body models.Storage true "存储目标"
+// @Success 200 {object} utils.Response
+// @Router /api/storages/test [post]
+func (h *StorageHandler) Test(c *gin.Context) {
+	var storage models.Storage
+	if err := c.ShouldBindJSON(&storage); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	if err := h.storageService.Test(c.Request.Context(), 0, &storage); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
+// @Summary 测试已保存的存储连接
+// @Description 测试已保存的存储目标是否可以正常读写
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Param id path int true "存储目标ID"
+// @Success 200 {object} utils.Response
+// @Router /api/storages/{id}/test [post]
+func (h *StorageHandler) TestSaved(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	if err := h.storageService.Test(c.Request.Context(), uint(id), nil); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
+// @Summary 指定车间录像存储
+// @Description 为车间指定新录像的存储目标，storageId 为 0 时使用默认存储，已有录像不会迁移
+// @Tags 车间管理
+// @Accept json
+// @Produce json
+// @Param id path int true "车间ID"
+// @Param body body models.WorkshopStorageRequest true "存储目标"
+// @Success 200 {object} utils.Response
+// @Router /api/workshops/{id}/storage [put]
+func (h *StorageHandler) AssignWorkshop(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	var req models.WorkshopStorageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	if err := h.storageService.AssignWorkshop(uint(id), req.StorageID); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
+// @Summary 获取生命周期规则列表
+// @Description 获取把旧录像迁移到归档存储的生命周期规则
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response
+// @Router /api/storages/rules [get]
+func (h *StorageHandler) ListRules(c *gin.Context) {
+	rules, err := h.lifecycleService.ListRules()
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, rules)
+}
+
+// @Summary 创建生命周期规则
+// @Description 录像开始时间超过 afterDays 天后，从源存储迁移到目标存储，sourceStorageId 为 0 表示配置文件中的存储
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Param body body models.StorageRule true "生命周期规则"
+// @Success 200 {object} utils.Response
+// @Router /api/storages/rules [post]
+func (h *StorageHandler) CreateRule(c *gin.Context) {
+	var rule models.StorageRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	if err := h.lifecycleService.CreateRule(&rule); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, rule)
+}
+
+// @Summary 更新生命周期规则
+// @Description 更新指定的生命周期规则
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Param id path int true "规则ID"
+// @Param body body models.StorageRule true "生命周期规则"
+// @Success 200 {object} utils.Response
+// @Router /api/storages/rules/{id} [put]
+func (h *StorageHandler) UpdateRule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	var rule models.StorageRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	if err := h.lifecycleService.UpdateRule(uint(id), &rule); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, rule)
+}
+
+// @Summary 删除生命周期规则
+// @Description 删除指定的生命周期规则，已迁移的录像不受影响
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Param id path int true "规则ID"
+// @Success 200 {object} utils.Response
+// @Router /api/storages/rules/{id} [delete]
+func (h *StorageHandler) DeleteRule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	if err := h.lifecycleService.DeleteRule(uint(id)); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
+// @Summary 立即执行生命周期规则
+// @Description 在后台立即执行一次所有启用的生命周期规则，不必等待定时执行
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response
+// @Router /api/storages/rules/run [post]
+func (h *StorageHandler) RunRules(c *gin.Context) {
+	if !h.lifecycleService.Trigger() {
+		utils.Error(c, fmt.Errorf("storage rules are already running"))
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
+// @Summary 获取存储核对报告
+// @Description 获取最近一次核对的结果：没有录像记录的孤立文件和找不到文件的录像
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response
+// @Router /api/storages/reconcile [get]
+func (h *StorageHandler) ReconcileReport(c *gin.Context) {
+	utils.Success(c, h.reconcileService.Report())
+}
+
+// @Summary 立即核对存储
+// @Description 在后台遍历所有存储并与录像记录比对，完成后通过核对报告查看结果
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response
+// @Router /api/storages/reconcile [post]
+func (h *StorageHandler) Reconcile(c *gin.Context) {
+	if !h.reconcileService.Trigger() {
+		utils.Error(c, fmt.Errorf("reconcile is already running"))
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
+// @Summary 导入孤立文件
+// @Description 把存储中没有录像记录的文件导入为录像，返回每个文件的导入结果
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Param body body models.ReconcileImportRequest true "存储和文件列表"
+// @Success 200 {object} utils.Response
+// @Router /api/storages/reconcile/import [post]
+func (h *StorageHandler) ImportOrphans(c *gin.Context) {
+	var req models.ReconcileImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	results, err := h.reconcileService.Import(c.Request.Context(), &req)
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, results)
+}
+
+// @Summary 标记文件丢失的录像
+// @Description 把找不到文件的录像标记为文件丢失，文件仍存在的录像不标记，返回标记的录像数
+// @Tags 存储管理
+// @Accept json
+// @Produce json
+// @Param body body models.ReconcileMissingRequest true "录像ID列表"
+// @Success 200 {object} utils.Response
+// @Router /api/storages/reconcile/missing [post]
+func (h *StorageHandler) MarkMissing(c *gin.Context) {
+	var req models.ReconcileMissingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	count, err := h.reconcileService.MarkMissing(c.Request.Context(), req.IDs)
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, gin.H{"count": count})
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"videodb/be/models"
+	"videodb/be/services"
+	"videodb/be/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TagHandler struct {
+	tagService      *services.TagService
+	workshopService *services.WorkshopService
+}
+
+func NewTagHandler(ts *services.TagService, ws *services.WorkshopService) *TagHandler {
+	return &TagHandler{
+		tagService:      ts,
+		workshopService: ws,
+	}
+}
+
+// @Summary 上报工业数据
+// @Description 采集端批量上报数据点的最新读数，需在 X-Device-Token 请求头中携带设备令牌
+// @Tags 工业数据
+// @Accept json
+// @Produce json
+// @Param body body []models.TagReading true "读数列表"
+// @Success 200 {object} utils.Response
+// @Router /api/tags/readings [post]
+func (h *TagHandler) PushReadings(c *gin.Context) {
+	var readings []models.TagReading
+	if err := c.ShouldBindJSON(&readings); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	h.tagService.Push(readings)
+	utils.Success(c, nil)
+}
+
+// @Summary 获取车间数据点
+// @Description 获取车间绑定的数据点及其最新读数
+// @Tags 工业数据
+// @Accept json
+// @Produce json
+// @Param id path int true "车间ID"
+// @Success 200 {object} utils.Response
+// @Router /api/workshops/{id}/tags [get]
+func (h *TagHandler) List(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	// 检查当前用户是否有权限查看该车间
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), uint(id)); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	tags, err := h.tagService.ListByWorkshop(uint(id))
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	readings, err := h.tagService.Latest(uint(id))
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"tags":     tags,
+		"readings": readings,
+	})
+}
+
+// @Summary 绑定数据点
+// @Description 为车间绑定一个工业数据点
+// @Tags 工业数据
+// @Accept json
+// @Produce json
+// @Param id path int true "车间ID"
+// @Param body body models.WorkshopTagCreateRequest true "数据点信息"
+// @Success 200 {object} utils.Response
+// @Router /api/workshops/{id}/tags [post]
+func (h *TagHandler) Bind(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	var req models.WorkshopTagCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	tag := models.WorkshopTag{
+		WorkshopID: uint(id),
+		Tag:        req.Tag,
+		Name:       req.Name,
+		Unit:       req.Unit,
+	}
+	if err := h.tagService.Bind(&tag); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, tag)
+}
+
+// @Summary 解除数据点绑定
+// @Description 解除车间与数据点的绑定
+// @Tags 工业数据
+// @Accept json
+// @Produce json
+// @Param id path int true "车间ID"
+// @Param tagId path int true "绑定ID"
+// @Success 200 {object} utils.Response
+// @Router /api/workshops/{id}/tags/{tagId} [delete]
+func (h *TagHandler) Unbind(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+	tagID, err := strconv.ParseUint(c.Param("tagId"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid tag id format"))
+		return
+	}
+
+	if err := h.tagService.Unbind(uint(id), uint(tagID)); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, nil)
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"videodb/be/models"
+	"videodb/be/services"
+	"videodb/be/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TimelineHandler struct {
+	timelineService *services.TimelineService
+	workshopService *services.WorkshopService
+}
+
+func NewTimelineHandler(ts *services.TimelineService, ws *services.WorkshopService) *TimelineHandler {
+	return &TimelineHandler{
+		timelineService: ts,
+		workshopService: ws,
+	}
+}
+
+// @Summary 获取车间录像时间轴
+// @Description 车间在时间段内有录像和没有录像的时间段，以及各录像的起止时间
+// @Tags 车间管理
+// @Accept json
+// @Produce json
+// @Param id path int true "车间ID"
+// @Param from query string true "开始时间，如 2024-01-05 08:00:00"
+// @Param to query string true "结束时间"
+// @Success 200 {object} utils.Response
+// @Router /api/workshops/{id}/timeline [get]
+func (h *TimelineHandler) Timeline(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), uint(id)); err != nil {
+		utils.Error(c, err)
+		return
+	}
+	var query models.TimelineQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	timeline, err := h.timelineService.Timeline(uint(id), query.From, query.To)
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, timeline)
+}
+
+// @Summary 连续回放
+// @Description 把车间在时间段内的录像组成一个 HLS 点播播放列表，没有录像的时间段被跳过，
+// @Description 播放器无法设置请求头时可通过 token 参数传递登录凭证
+// @Tags 车间管理
+// @Produce application/vnd.apple.mpegurl
+// @Param id path int true "车间ID"
+// @Param from query string true "开始时间，如 2024-01-05 08:00:00"
+// @Param to query string true "结束时间"
+// @Param token query string false "登录凭证"
+// @Success 200 {file} file
+// @Router /api/workshops/{id}/playback.m3u8 [get]
+func (h *TimelineHandler) Playlist(c *gin.Context) {
+	id, ok := h.checkAccess(c)
+	if !ok {
+		return
+	}
+	var query models.TimelineQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, err := h.timelineService.Playlist(c.Request.Context(), id, query.From, query.To, c.Query("token"))
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", data)
+}
+
+// @Summary 连续回放切片
+// @Description 连续回放播放列表中的 MPEG-TS 切片
+// @Tags 车间管理
+// @Produce video/mp2t
+// @Param id path int true "车间ID"
+// @Param videoId path int true "视频ID"
+// @Param segment path string true "切片文件名"
+// @Param token query string false "登录凭证"
+// @Success 200 {file} file
+// @Router /api/workshops/{id}/playback/{videoId}/{segment} [get]
+func (h *TimelineHandler) Segment(c *gin.Context) {
+	id, ok := h.checkAccess(c)
+	if !ok {
+		return
+	}
+	videoID, err := strconv.ParseUint(c.Param("videoId"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid video id format")
+		return
+	}
+
+	c.Header("Content-Type", "video/mp2t")
+	c.Header("Cache-Control", "max-age=3600")
+	err = h.timelineService.Segment(c.Request.Context(), id, uint(videoID), c.Param("segment"), c.Writer)
+	if err == nil {
+		return
+	}
+	// 已开始输出时只能中断
+	if c.Writer.Written() {
+		fmt.Printf("Failed to stream playback segment: %v\n", err)
+		return
+	}
+	status := http.StatusInternalServerError
+	if errors.Is(err, utils.ErrInvalidParameter) {
+		status = http.StatusBadRequest
+	} else if errors.Is(err, utils.ErrFileNotFound) {
+		status = http.StatusNotFound
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.String(status, err.Error())
+}
+
+// checkAccess 检查当前用户是否有权限查看车间，播放器请求时以 HTTP 状态码返回错误
+func (h *TimelineHandler) checkAccess(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid id format")
+		return 0, false
+	}
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), uint(id)); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		c.String(status, err.Error())
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"videodb/be/config"
+	"videodb/be/models"
 	"videodb/be/services"
 	"videodb/be/utils"
 
@@ -133,7 +137,7 @@ func (h *VideoHandler) Delete(c *gin.Context) {
 		return
 	}
 
-	if err := h.videoService.Delete(uint(id)); err != nil {
+	if err := h.videoService.Delete(uint(id), h.videoAccess(c)); err != nil {
 		utils.Error(c, err)
 		return
 	}
@@ -141,6 +145,120 @@ func (h *VideoHandler) Delete(c *gin.Context) {
 	utils.Success(c, nil)
 }
 
+// @Summary 保全录像
+// @Description 保全期间录像不能被删除，也不会被保留策略自动清理；protected 为 false 时解除保全。
+// @Description 有车间权限的用户可以保全录像，修改或解除保全期内的录像仅管理员可用
+// @Tags 视频管理
+// @Accept json
+// @Produce json
+// @Param id path int true "视频ID"
+// @Param body body models.VideoHoldRequest true "保全信息"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/{id}/hold [put]
+func (h *VideoHandler) Hold(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	var req models.VideoHoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	video, err := h.videoService.GetByID(uint(id))
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), video.WorkshopID); err != nil {
+		utils.Error(c, err)
+		return
+	}
+	if c.GetString("role") != config.RoleAdmin && (!req.Protected || services.IsVideoHeld(video, time.Now())) {
+		utils.Error(c, utils.ErrForbidden)
+		return
+	}
+
+	if err := h.videoService.SetHold(uint(id), &req, c.GetString("username")); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
+// @Summary 按时间段保全录像
+// @Description 保全或解除保全车间在指定时间段内的所有录像，返回变更的录像数。
+// @Description 普通用户只能保全，且不修改已在保全期的录像
+// @Tags 视频管理
+// @Accept json
+// @Produce json
+// @Param body body models.VideoHoldRangeRequest true "车间、时间段和保全信息"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/hold [post]
+func (h *VideoHandler) HoldRange(c *gin.Context) {
+	var req models.VideoHoldRangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), req.WorkshopID); err != nil {
+		utils.Error(c, err)
+		return
+	}
+	admin := c.GetString("role") == config.RoleAdmin
+	if !admin && !req.Protected {
+		utils.Error(c, utils.ErrForbidden)
+		return
+	}
+
+	count, err := h.videoService.HoldRange(&req, c.GetString("username"), admin)
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, gin.H{"count": count})
+}
+
+// @Summary 获取录像保全记录
+// @Description 获取录像的保全和解除保全记录，按时间倒序
+// @Tags 视频管理
+// @Accept json
+// @Produce json
+// @Param id path int true "视频ID"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/{id}/holds [get]
+func (h *VideoHandler) HoldLogs(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	video, err := h.videoService.GetByID(uint(id))
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), video.WorkshopID); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	logs, err := h.videoService.HoldLogs(uint(id))
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, logs)
+}
+
 // @Summary 开始录制视频
 // @Description 开始录制指定车间的视频
 // @Tags 视频管理
@@ -166,11 +284,14 @@ func (h *VideoHandler) StartRecording(c *gin.Context) {
 		return
 	}
 
-	// 生成输出文件路径
-	outputPath := h.videoService.GenerateVideoPath(req.WorkshopID)
+	rtspURL, err := h.workshopService.StreamURL(workshop)
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
 
-	// 开始录制
-	if err := h.rtspService.StartRecording(c, workshop.RTSPUrl, outputPath, req.WorkshopID); err != nil {
+	// 开始录制，停止后存入车间的录像存储
+	if err := h.rtspService.StartRecording(c, rtspURL, req.WorkshopID, workshop.RecordAudio); err != nil {
 		utils.Error(c, err)
 		return
 	}
@@ -202,55 +323,126 @@ func (h *VideoHandler) StopRecording(c *gin.Context) {
 }
 
 // @Summary 下载视频
-// @Description 下载指定ID的视频文件
+// @Description 下载指定ID的视频文件，浏览器直接下载时可以通过 token 参数传递登录凭证
 // @Tags 视频管理
-// @Accept json
-// @Produce json
+// @Produce octet-stream
 // @Param id path int true "视频ID"
+// @Param token query string false "登录凭证"
 // @Success 200 {file} binary
 // @Router /api/videos/{id}/download [get]
 func (h *VideoHandler) Download(c *gin.Context) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		utils.Error(c, fmt.Errorf("invalid id format"))
+	video, ok := h.mediaVideo(c)
+	if !ok {
 		return
 	}
 
-	video, err := h.videoService.GetByID(uint(id))
-	if err != nil {
+	h.serveVideo(c, video, "attachment")
+}
+
+// 添加批量删除的请求结构
+type BatchDeleteRequest struct {
+	IDs []uint `json:"ids" binding:"required,min=1"`
+}
+
+// @Summary 批量删除视频
+// @Description 批量删除多个视频，返回每个视频的删除结果
+// @Tags 视频管理
+// @Accept json
+// @Produce json
+// @Param body body BatchDeleteRequest true "视频ID列表"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/batch [delete]
+func (h *VideoHandler) BatchDelete(c *gin.Context) {
+	var req BatchDeleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.Error(c, err)
 		return
 	}
 
-	if !utils.FileExists(video.FilePath) {
-		utils.Error(c, fmt.Errorf("video file not found"))
+	results, err := h.videoService.BatchDelete(req.IDs, h.videoAccess(c))
+	if err != nil {
+		utils.Error(c, err)
 		return
 	}
 
-	c.FileAttachment(video.FilePath, video.FileName)
+	utils.Success(c, results)
 }
 
-// 添加批量删除的请求结构
-type BatchDeleteRequest struct {
-	IDs []uint `json:"ids" binding:"required,min=1"`
+// @Summary 获取回收站录像列表
+// @Description 分页获取回收站中的录像，按删除时间倒序
+// @Tags 视频管理
+// @Accept json
+// @Produce json
+// @Param page query int true "页码"
+// @Param pageSize query int true "每页数量"
+// @Param workshopId query int false "车间ID"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/trash [get]
+func (h *VideoHandler) ListTrash(c *gin.Context) {
+	var query struct {
+		Page       int  `form:"page" binding:"required,min=1"`
+		PageSize   int  `form:"pageSize" binding:"required,min=1,max=100"`
+		WorkshopID uint `form:"workshopId"`
+	}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	// 普通用户只能查看有权限的车间
+	var workshops []uint
+	if c.GetString("role") != config.RoleAdmin {
+		if query.WorkshopID > 0 {
+			if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), query.WorkshopID); err != nil {
+				utils.Error(c, err)
+				return
+			}
+		} else {
+			var err error
+			if workshops, err = h.workshopService.PermittedWorkshops(c.GetUint("userId")); err != nil {
+				utils.Error(c, err)
+				return
+			}
+		}
+	}
+
+	videos, total, err := h.videoService.List(services.VideoQuery{
+		WorkshopID: query.WorkshopID,
+		Page:       query.Page,
+		PageSize:   query.PageSize,
+		Preload:    []string{"Workshop"},
+		Deleted:    true,
+		Workshops:  workshops,
+	})
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"list":  videos,
+		"total": total,
+		"page":  query.Page,
+		"size":  query.PageSize,
+	})
 }
 
-// @Summary 批量删除视频
-// @Description 批量删除多个视频
+// @Summary 恢复回收站录像
+// @Description 把回收站中的录像恢复到原位置
 // @Tags 视频管理
 // @Accept json
 // @Produce json
 // @Param body body BatchDeleteRequest true "视频ID列表"
 // @Success 200 {object} utils.Response
-// @Router /api/videos/batch [delete]
-func (h *VideoHandler) BatchDelete(c *gin.Context) {
+// @Router /api/videos/trash/restore [post]
+func (h *VideoHandler) Restore(c *gin.Context) {
 	var req BatchDeleteRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.Error(c, err)
 		return
 	}
 
-	if err := h.videoService.BatchDelete(req.IDs); err != nil {
+	if err := h.videoService.Restore(req.IDs, h.videoAccess(c)); err != nil {
 		utils.Error(c, err)
 		return
 	}
@@ -258,26 +450,141 @@ func (h *VideoHandler) BatchDelete(c *gin.Context) {
 	utils.Success(c, nil)
 }
 
-func (h *VideoHandler) StreamVideo(c *gin.Context) {
-	filePath := c.Query("path")
+// @Summary 彻底删除回收站录像
+// @Description 永久删除回收站中的录像文件和记录，返回每个录像的删除结果，仅管理员可用
+// @Tags 视频管理
+// @Accept json
+// @Produce json
+// @Param body body BatchDeleteRequest true "视频ID列表"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/trash [delete]
+func (h *VideoHandler) Purge(c *gin.Context) {
+	var req BatchDeleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
 
-	// 安全检查：确保路径在允许的目录内
-	absPath, err := filepath.Abs(filePath)
+	results, err := h.videoService.Purge(req.IDs)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, results)
+}
+
+// @Summary 在线播放视频
+// @Description 按视频ID播放录像文件，支持 Range 请求拖动进度和条件请求（ETag、If-Modified-Since）。
+// @Description fragmented=true 时实时转封装为分片 MP4 输出（不支持 Range，可用 start 指定起点），
+// @Description moov 在文件末尾或非 MP4 容器的录像在不带 Range 的请求中自动转封装；
+// @Description 播放器无法设置请求头时可通过 token 参数传递登录凭证
+// @Tags 视频管理
+// @Produce video/mp4
+// @Param id path int true "视频ID"
+// @Param fragmented query bool false "实时转封装为分片 MP4"
+// @Param start query number false "分片 MP4 的起点（秒）"
+// @Param token query string false "登录凭证"
+// @Success 200 {file} binary
+// @Router /api/videos/{id}/stream [get]
+func (h *VideoHandler) StreamVideo(c *gin.Context) {
+	video, ok := h.mediaVideo(c)
+	if !ok {
 		return
 	}
 
-	// 检查文件是否存在
-	if _, err := os.Stat(absPath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+	// 不支持 Range 的客户端无法先读取文件末尾的 moov，改为输出分片 MP4
+	fragmented := c.Query("fragmented") == "true"
+	if !fragmented && c.GetHeader("Range") == "" {
+		if progressive, err := h.videoService.Progressive(c.Request.Context(), video); err == nil && !progressive {
+			fragmented = true
+		}
+	}
+	if fragmented {
+		h.streamFragmented(c, video)
+		return
+	}
+
+	h.serveVideo(c, video, "inline")
+}
+
+// streamFragmented 实时转封装为分片 MP4 输出，长度未知，不支持 Range
+func (h *VideoHandler) streamFragmented(c *gin.Context, video *models.Video) {
+	start, err := strconv.ParseFloat(c.DefaultQuery("start", "0"), 64)
+	if err != nil || start < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start"})
 		return
 	}
 
-	// 设置响应头
 	c.Header("Content-Type", "video/mp4")
-	c.Header("Content-Disposition", "inline")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Accept-Ranges", "none")
+	err = h.videoService.StreamFragmented(c.Request.Context(), video, start, c.Writer)
+	if err == nil {
+		return
+	}
+	// 已开始输出时只能中断
+	if c.Writer.Written() {
+		fmt.Printf("Failed to stream video %d: %v\n", video.ID, err)
+		return
+	}
+	c.Header("Accept-Ranges", "")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// serveVideo 从录像存储中读取文件返回，Range 请求和条件请求由 http.ServeContent 处理
+func (h *VideoHandler) serveVideo(c *gin.Context, video *models.Video, disposition string) {
+	reader, info, err := h.videoService.Open(c.Request.Context(), video)
+	if err != nil {
+		if errors.Is(err, utils.ErrFileNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer reader.Close()
+
+	// 按扩展名确定类型，系统 MIME 表中没有或不是视频类型（如 .ts）时按 MP4 处理
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(video.FileName)))
+	if !strings.HasPrefix(contentType, "video/") {
+		contentType = "video/mp4"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": video.FileName}))
+	// 录像文件写入后不会修改，按大小和修改时间生成 ETag，If-None-Match、If-Range 由 http.ServeContent 处理
+	c.Header("ETag", fmt.Sprintf(`"%d-%x-%x"`, video.ID, info.Size, info.ModTime.UnixNano()))
+	http.ServeContent(c.Writer, c.Request, video.FileName, info.ModTime, reader)
+}
 
-	// 流式传输视频
-	c.File(absPath)
+// mediaVideo 获取路径中的录像并检查当前用户是否有所属车间的权限，播放器请求时以 HTTP 状态码返回错误
+func (h *VideoHandler) mediaVideo(c *gin.Context) (*models.Video, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return nil, false
+	}
+
+	video, err := h.videoService.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), video.WorkshopID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return video, true
+}
+
+// videoAccess 检查当前用户是否有录像所属车间的权限，管理员可以操作所有录像
+func (h *VideoHandler) videoAccess(c *gin.Context) services.VideoAccessCheck {
+	userID, role := c.GetUint("userId"), c.GetString("role")
+	return func(video *models.Video) error {
+		return h.workshopService.CheckAccess(userID, role, video.WorkshopID)
+	}
 }
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"videodb/be/models"
+	"videodb/be/services"
+	"videodb/be/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VideoImportHandler struct {
+	importService *services.VideoImportService
+}
+
+func NewVideoImportHandler(is *services.VideoImportService) *VideoImportHandler {
+	return &VideoImportHandler{importService: is}
+}
+
+// @Summary 导入已有录像
+// @Description 在后台导入服务器目录中的录像文件，目录需在 storage.import_paths 之内，完成后通过导入进度查看结果
+// @Tags 视频管理
+// @Accept json
+// @Produce json
+// @Param body body models.VideoImportRequest true "导入目录和车间"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/import [post]
+func (h *VideoImportHandler) Start(c *gin.Context) {
+	var req models.VideoImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	if err := h.importService.Start(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, h.importService.Job())
+}
+
+// @Summary 获取导入进度
+// @Description 最近一次通过接口导入的进度，包含导入、跳过和失败的文件数
+// @Tags 视频管理
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response
+// @Router /api/videos/import [get]
+func (h *VideoImportHandler) Job(c *gin.Context) {
+	utils.Success(c, h.importService.Job())
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"videodb/be/models"
+	"videodb/be/services"
+	"videodb/be/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ThumbnailHandler struct {
+	thumbnailService *services.ThumbnailService
+	videoService     *services.VideoService
+	workshopService  *services.WorkshopService
+}
+
+func NewThumbnailHandler(ts *services.ThumbnailService, vs *services.VideoService, ws *services.WorkshopService) *ThumbnailHandler {
+	return &ThumbnailHandler{
+		thumbnailService: ts,
+		videoService:     vs,
+		workshopService:  ws,
+	}
+}
+
+// @Summary 获取录像封面图
+// @Description 录像的封面图（JPEG），新录像保存后约一分钟内生成，尚未生成时返回 404。
+// @Description <img> 标签无法设置请求头时可通过 token 参数传递登录凭证
+// @Tags 视频管理
+// @Produce image/jpeg
+// @Param id path int true "视频ID"
+// @Param token query string false "登录凭证"
+// @Success 200 {file} binary
+// @Router /api/videos/{id}/thumbnail [get]
+func (h *ThumbnailHandler) Thumbnail(c *gin.Context) {
+	video, ok := h.getVideo(c)
+	if !ok {
+		return
+	}
+	h.serveImage(c, video, video.ThumbnailPath)
+}
+
+// @Summary 获取拖动预览图拼图
+// @Description 录像的预览图拼图（JPEG），每张预览图在拼图中的位置见 sprites.vtt
+// @Tags 视频管理
+// @Produce image/jpeg
+// @Param id path int true "视频ID"
+// @Param token query string false "登录凭证"
+// @Success 200 {file} binary
+// @Router /api/videos/{id}/sprite.jpg [get]
+func (h *ThumbnailHandler) Sprite(c *gin.Context) {
+	video, ok := h.getVideo(c)
+	if !ok {
+		return
+	}
+	h.serveImage(c, video, video.SpritePath)
+}
+
+// @Summary 获取拖动预览图轨道
+// @Description WebVTT 格式的预览图轨道，每个时间段对应拼图中的一块（sprite.jpg#xywh=x,y,w,h），播放器拖动进度条时显示。
+// @Description 通过 token 参数传递登录凭证时，拼图地址同样附加该参数
+// @Tags 视频管理
+// @Produce text/vtt
+// @Param id path int true "视频ID"
+// @Param token query string false "登录凭证"
+// @Success 200 {string} string
+// @Router /api/videos/{id}/sprites.vtt [get]
+func (h *ThumbnailHandler) SpriteVTT(c *gin.Context) {
+	video, ok := h.getVideo(c)
+	if !ok {
+		return
+	}
+	if video.SpritePath == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sprite not found"})
+		return
+	}
+
+	// 拼图地址相对于 VTT 文件
+	spriteURL := "sprite.jpg"
+	if token := c.Query("token"); token != "" {
+		spriteURL += "?token=" + url.QueryEscape(token)
+	}
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Data(http.StatusOK, "text/vtt; charset=utf-8", []byte(services.SpriteVTT(video, spriteURL)))
+}
+
+func (h *ThumbnailHandler) getVideo(c *gin.Context) (*models.Video, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return nil, false
+	}
+
+	video, err := h.videoService.GetByID(uint(id))
+	if err != nil {
+		utils.Error(c, err)
+		return nil, false
+	}
+	// 检查当前用户是否有权限查看录像所属车间
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), video.WorkshopID); err != nil {
+		utils.Error(c, err)
+		return nil, false
+	}
+	return video, true
+}
+
+func (h *ThumbnailHandler) serveImage(c *gin.Context, video *models.Video, key string) {
+	reader, info, err := h.thumbnailService.Open(c.Request.Context(), video, key)
+	if err != nil {
+		if errors.Is(err, utils.ErrFileNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Thumbnail not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer reader.Close()
+
+	c.Header("Content-Type", "image/jpeg")
+	c.Header("Cache-Control", "private, max-age=3600")
+	http.ServeContent(c.Writer, c.Request, "", info.ModTime, reader)
+}
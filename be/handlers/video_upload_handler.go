@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/services"
+	"videodb/be/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VideoUploadHandler struct {
+	uploadService   *services.VideoUploadService
+	workshopService *services.WorkshopService
+}
+
+func NewVideoUploadHandler(us *services.VideoUploadService, ws *services.WorkshopService) *VideoUploadHandler {
+	return &VideoUploadHandler{
+		uploadService:   us,
+		workshopService: ws,
+	}
+}
+
+// @Summary 上传录像
+// @Description 一次上传整个录像文件（multipart 表单，字段 file），校验后登记为车间的录像，大文件请使用分片上传
+// @Tags 视频管理
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "录像文件"
+// @Param workshopId formData int true "车间ID"
+// @Param startTime formData string false "开始时间，为空时取录像元数据中的录制时间"
+// @Param notes formData string false "备注"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/upload [post]
+func (h *VideoUploadHandler) Upload(c *gin.Context) {
+	var req models.VideoUploadRequest
+	if err := c.ShouldBind(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), req.WorkshopID); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	video, err := h.uploadService.Upload(c.Request.Context(), file, &req, c.GetUint("userId"))
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, video)
+}
+
+// @Summary 创建分片上传
+// @Description 声明文件名和大小，返回上传ID，之后按顺序上传分片
+// @Tags 视频管理
+// @Accept json
+// @Produce json
+// @Param body body models.VideoUploadRequest true "车间、文件名和大小"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/uploads [post]
+func (h *VideoUploadHandler) Create(c *gin.Context) {
+	var req models.VideoUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), req.WorkshopID); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	upload, err := h.uploadService.Create(&req, c.GetUint("userId"))
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	utils.Success(c, upload)
+}
+
+// @Summary 获取上传进度
+// @Description 返回已上传的字节数（同时在 Upload-Offset 响应头中返回），中断后从该位置继续上传
+// @Tags 视频管理
+// @Accept json
+// @Produce json
+// @Param id path string true "上传ID"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/uploads/{id} [get]
+func (h *VideoUploadHandler) Get(c *gin.Context) {
+	upload, ok := h.ownUpload(c)
+	if !ok {
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	utils.Success(c, upload)
+}
+
+// @Summary 上传分片
+// @Description 请求体为分片数据，Upload-Offset 请求头为分片的起始位置，须等于已上传的字节数。
+// @Description 最后一个分片上传后校验文件并登记为录像，返回的 video 不为空
+// @Tags 视频管理
+// @Accept application/offset+octet-stream
+// @Produce json
+// @Param id path string true "上传ID"
+// @Param Upload-Offset header int true "分片起始位置"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/uploads/{id} [patch]
+func (h *VideoUploadHandler) Write(c *gin.Context) {
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid upload offset"))
+		return
+	}
+	if _, ok := h.ownUpload(c); !ok {
+		return
+	}
+
+	upload, video, err := h.uploadService.Write(c.Request.Context(), c.Param("id"), offset, c.Request.Body)
+	if upload != nil {
+		setUploadHeaders(c, upload)
+	}
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, gin.H{"offset": upload.Offset, "video": video})
+}
+
+// @Summary 取消上传
+// @Description 取消未完成的上传并删除已上传的数据
+// @Tags 视频管理
+// @Accept json
+// @Produce json
+// @Param id path string true "上传ID"
+// @Success 200 {object} utils.Response
+// @Router /api/videos/uploads/{id} [delete]
+func (h *VideoUploadHandler) Delete(c *gin.Context) {
+	if _, ok := h.ownUpload(c); !ok {
+		return
+	}
+
+	if err := h.uploadService.Delete(c.Param("id")); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
+// ownUpload 获取当前用户的上传，管理员可以操作所有上传
+func (h *VideoUploadHandler) ownUpload(c *gin.Context) (*models.VideoUpload, bool) {
+	upload, err := h.uploadService.Get(c.Param("id"))
+	if err != nil {
+		utils.Error(c, err)
+		return nil, false
+	}
+	if upload.UserID != c.GetUint("userId") && c.GetString("role") != config.RoleAdmin {
+		utils.Error(c, utils.ErrForbidden)
+		return nil, false
+	}
+	return upload, true
+}
+
+func setUploadHeaders(c *gin.Context, upload *models.VideoUpload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+}
@@ -1,21 +1,28 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 
 	"videodb/be/models"
 	"videodb/be/services"
+	"videodb/be/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
 type WebRTCHandler struct {
-	webrtcService *services.WebRTCService
+	webrtcService   *services.WebRTCService
+	workshopService *services.WorkshopService
+	videoService    *services.VideoService
 }
 
-func NewWebRTCHandler(webrtcService *services.WebRTCService) *WebRTCHandler {
+func NewWebRTCHandler(webrtcService *services.WebRTCService, workshopService *services.WorkshopService, videoService *services.VideoService) *WebRTCHandler {
 	return &WebRTCHandler{
-		webrtcService: webrtcService,
+		webrtcService:   webrtcService,
+		workshopService: workshopService,
+		videoService:    videoService,
 	}
 }
 
@@ -29,7 +36,39 @@ func (h *WebRTCHandler) HandleWebRTC(c *gin.Context) {
 		return
 	}
 
-	answer, err := h.webrtcService.HandleRTSP(req.RTSPURL, req.SDP)
+	// 检查当前用户是否有权限查看该车间
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), req.WorkshopID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, models.WebRTCResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 由服务端解析车间的RTSP地址及认证信息
+	workshop, err := h.workshopService.GetByID(req.WorkshopID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.WebRTCResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	urls, err := h.workshopService.LiveStreamURLs(workshop)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.WebRTCResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	answer, err := h.webrtcService.HandleRTSP(workshop.ID, urls, req.Quality, req.SDP)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.WebRTCResponse{
 			Success: false,
@@ -47,3 +86,103 @@ func (h *WebRTCHandler) HandleWebRTC(c *gin.Context) {
 		"message": "success",
 	})
 }
+
+// HandlePlayback 通过 WebRTC 回放录像，跳转/暂停/变速通过 control 数据通道控制
+func (h *WebRTCHandler) HandlePlayback(c *gin.Context) {
+	var req models.PlaybackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.WebRTCResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	video, err := h.videoService.GetByID(req.VideoID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.WebRTCResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 检查当前用户是否有权限查看录像所属车间
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), video.WorkshopID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, models.WebRTCResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 录像可能保存在对象存储中，由存储给出 FFmpeg 可读取的地址
+	source, err := h.videoService.Source(c.Request.Context(), video)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.WebRTCResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	answer, err := h.webrtcService.HandlePlayback(video, source, req.Offset, req.SDP)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.WebRTCResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": models.WebRTCResponse{
+			Success: true,
+			SDP:     answer.SDP,
+		},
+		"message": "success",
+	})
+}
+
+// HandleRTSPURL 通过 WebRTC 直接预览任意 RTSP 地址，用于添加车间前测试摄像头，仅管理员可用
+func (h *WebRTCHandler) HandleRTSPURL(c *gin.Context) {
+
+	var req models.WebRTCURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.WebRTCResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+	if !strings.HasPrefix(req.RTSPUrl, "rtsp://") && !strings.HasPrefix(req.RTSPUrl, "rtsps://") {
+		c.JSON(http.StatusBadRequest, models.WebRTCResponse{
+			Success: false,
+			Message: "Invalid RTSP URL",
+		})
+		return
+	}
+
+	answer, err := h.webrtcService.HandleRTSPURL(req.RTSPUrl, req.SDP)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.WebRTCResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": models.WebRTCResponse{
+			Success: true,
+			SDP:     answer.SDP,
+		},
+		"message": "success",
+	})
+}
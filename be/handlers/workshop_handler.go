@@ -66,6 +66,8 @@ func (h *WorkshopHandler) Create(c *gin.Context) {
 		return
 	}
 
+	workshop.RTSPPass = ""
+	workshop.RestreamPass = ""
 	utils.Success(c, workshop)
 }
 
@@ -75,7 +77,7 @@ func (h *WorkshopHandler) Create(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path int true "车间ID"
-// @Param body body models.Workshop true "车间信息"
+// @Param body body models.WorkshopUpdateRequest true "车间信息，未填写的字段保持不变"
 // @Success 200 {object} utils.Response
 // @Router /api/workshops/{id} [put]
 func (h *WorkshopHandler) Update(c *gin.Context) {
@@ -91,19 +93,21 @@ func (h *WorkshopHandler) Update(c *gin.Context) {
 		return
 	}
 
-	var workshop models.Workshop
-	if err := c.ShouldBindJSON(&workshop); err != nil {
+	var req models.WorkshopUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		fmt.Println("err:", err)
 		utils.Error(c, err)
 		return
 	}
 
-	workshop.ID = uint(id)
-	if err := h.workshopService.Update(uint(id), &workshop); err != nil {
+	workshop, err := h.workshopService.Update(uint(id), &req)
+	if err != nil {
 		utils.Error(c, err)
 		return
 	}
 
+	workshop.RTSPPass = ""
+	workshop.RestreamPass = ""
 	utils.Success(c, workshop)
 }
 
@@ -145,6 +149,12 @@ func (h *WorkshopHandler) GetPreview(c *gin.Context) {
 		return
 	}
 
+	// 检查当前用户是否有权限查看该车间
+	if err := h.workshopService.CheckAccess(c.GetUint("userId"), c.GetString("role"), uint(id)); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
 	workshop, err := h.workshopService.GetByID(uint(id))
 	if err != nil {
 		utils.Error(c, err)
@@ -159,3 +169,87 @@ func (h *WorkshopHandler) GetPreview(c *gin.Context) {
 
 	utils.Success(c, gin.H{"previewUrl": previewUrl})
 }
+
+// @Summary 获取车间访问权限
+// @Description 可以查看该车间画面和录像的普通用户，管理员拥有所有车间的权限
+// @Tags 车间管理
+// @Accept json
+// @Produce json
+// @Param id path int true "车间ID"
+// @Success 200 {object} utils.Response
+// @Router /api/workshops/{id}/permissions [get]
+func (h *WorkshopHandler) ListPermissions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	permissions, err := h.workshopService.ListPermissions(uint(id))
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, permissions)
+}
+
+// @Summary 授予车间访问权限
+// @Description 允许普通用户查看该车间的画面和录像
+// @Tags 车间管理
+// @Accept json
+// @Produce json
+// @Param id path int true "车间ID"
+// @Param body body models.WorkshopPermissionRequest true "用户"
+// @Success 200 {object} utils.Response
+// @Router /api/workshops/{id}/permissions [post]
+func (h *WorkshopHandler) Grant(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+
+	var req models.WorkshopPermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	permission, err := h.workshopService.Grant(uint(id), req.UserID)
+	if err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, permission)
+}
+
+// @Summary 收回车间访问权限
+// @Description 收回普通用户查看该车间画面和录像的权限
+// @Tags 车间管理
+// @Accept json
+// @Produce json
+// @Param id path int true "车间ID"
+// @Param userId path int true "用户ID"
+// @Success 200 {object} utils.Response
+// @Router /api/workshops/{id}/permissions/{userId} [delete]
+func (h *WorkshopHandler) Revoke(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid id format"))
+		return
+	}
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		utils.Error(c, fmt.Errorf("invalid user id format"))
+		return
+	}
+
+	if err := h.workshopService.Revoke(uint(id), uint(userID)); err != nil {
+		utils.Error(c, err)
+		return
+	}
+
+	utils.Success(c, nil)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/services"
+)
+
+// runImport 导入目录中已有的录像文件，例如：
+//
+//	go run . import -dir /mnt/nas/nvr -map cam01=1,cam02=2 -copy
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := flags.String("dir", "", "要导入的目录")
+	workshopID := flags.Uint("workshop", 0, "无法按子目录识别车间时使用的车间ID")
+	mapping := flags.String("map", "", "一级子目录到车间ID的映射，如 cam01=1,cam02=2，未配置的子目录按车间名称匹配")
+	copyFiles := flags.Bool("copy", false, "复制到车间的存储，默认直接引用原文件")
+	flags.Parse(args)
+
+	if *dir == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	req := &models.VideoImportRequest{
+		Dir:        *dir,
+		WorkshopID: uint(*workshopID),
+		Mapping:    make(map[string]uint),
+		Copy:       *copyFiles,
+	}
+	for _, item := range strings.Split(*mapping, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		id, err := strconv.ParseUint(value, 10, 32)
+		if !ok || err != nil {
+			log.Fatalf("Invalid mapping: %s", item)
+		}
+		req.Mapping[name] = uint(id)
+	}
+
+	cfg := &config.GlobalConfig
+	db := initDB()
+	videoStorage, err := services.NewVideoStorage(cfg.Storage, db)
+	if err != nil {
+		log.Fatalf("Failed to init storage: %v", err)
+	}
+	importService := services.NewVideoImportService(cfg, db, videoStorage)
+
+	// Ctrl+C 时导入完当前文件后退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var imported, skipped, failed int
+	err = importService.Run(ctx, req, func(result services.VideoImportResult) {
+		switch {
+		case result.Error != "":
+			failed++
+			log.Printf("Failed to import %s: %s", result.Path, result.Error)
+		case result.Skipped:
+			skipped++
+		default:
+			imported++
+			log.Printf("Imported %s as video %d", result.Path, result.VideoID)
+		}
+	})
+	log.Printf("Import finished: imported=%d skipped=%d failed=%d", imported, skipped, failed)
+	if err != nil {
+		log.Fatalf("Failed to import videos: %v", err)
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
 	"time"
 	"videodb/be/config"
 	"videodb/be/handlers"
+	"videodb/be/middleware"
 	"videodb/be/models"
 	"videodb/be/services"
 
@@ -48,7 +51,7 @@ func initDB() *gorm.DB {
 	}
 
 	// 自动迁移数据库表结构
-	err = db.AutoMigrate(&models.Video{}, &models.Workshop{})
+	err = db.AutoMigrate(&models.Video{}, &models.VideoHoldLog{}, &models.VideoUpload{}, &models.Clip{}, &models.Workshop{}, &models.WorkshopPermission{}, &models.WorkshopTag{}, &models.Storage{}, &models.StorageRule{})
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
@@ -63,27 +66,68 @@ func setupRouter(db *gorm.DB) *gin.Engine {
 	// CORS 配置
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"}, // 允许所有来源，修改操作需要处理，查询不用
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Upload-Offset"},
+		ExposeHeaders:    []string{"Content-Length", "Upload-Offset", "Upload-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 	// 使用全局配置
 	cfg := &config.GlobalConfig
 
+	// 录像存储，按配置使用本地磁盘或对象存储
+	videoStorage, err := services.NewVideoStorage(cfg.Storage, db)
+	if err != nil {
+		log.Fatalf("Failed to init storage: %v", err)
+	}
+
 	// 创建服务实例
-	videoService := services.NewVideoService(db)
-	rtspService := services.NewRTSPService()
+	videoService := services.NewVideoService(cfg, db, videoStorage)
+	rtspService := services.NewRTSPService(cfg, db, videoStorage)
 	workshopService := services.NewWorkshopService(db)
-	captureService := services.NewCaptureService(db)
-	webrtcService := services.NewWebRTCService(cfg)
+	captureService := services.NewCaptureService(db, videoStorage)
+	storageService := services.NewStorageService(db, videoStorage)
+	lifecycleService := services.NewStorageLifecycleService(cfg, db, videoStorage)
+	retentionService := services.NewRetentionService(cfg, db, videoStorage)
+	reconcileService := services.NewReconcileService(cfg, db, videoStorage)
+	importService := services.NewVideoImportService(cfg, db, videoStorage)
+	uploadService := services.NewVideoUploadService(cfg, db, videoStorage)
+	thumbnailService := services.NewThumbnailService(cfg, db, videoStorage)
+	clipService := services.NewClipService(cfg, db, videoStorage)
+	timelineService := services.NewTimelineService(cfg, db, videoStorage)
+	tagService := services.NewTagService(db)
+	streamHub := services.NewStreamHub(cfg)
+	webrtcService := services.NewWebRTCService(cfg, streamHub, tagService)
+
+	hlsService := services.NewHLSService(cfg, streamHub, workshopService)
+	mjpegService := services.NewMJPEGService(cfg, streamHub, workshopService)
+
+	// 启动 RTSP 转发服务，下游系统从共享拉流中读取摄像头画面
+	if cfg.Restream.Enabled {
+		restreamServer := services.NewRestreamServer(cfg, streamHub, workshopService)
+		if err := restreamServer.Start(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
 
 	// 创建处理器实例
 	videoHandler := handlers.NewVideoHandler(videoService, rtspService, workshopService)
 	workshopHandler := handlers.NewWorkshopHandler(workshopService, rtspService)
 	captureHandler := handlers.NewCaptureHandler(captureService)
-	webrtcHandler := handlers.NewWebRTCHandler(webrtcService) // 添加 WebRTC 处理器
+	webrtcHandler := handlers.NewWebRTCHandler(webrtcService, workshopService, videoService) // 添加 WebRTC 处理器
+	tagHandler := handlers.NewTagHandler(tagService, workshopService)
+	hlsHandler := handlers.NewHLSHandler(hlsService, workshopService)
+	mjpegHandler := handlers.NewMJPEGHandler(mjpegService, workshopService)
+	storageHandler := handlers.NewStorageHandler(storageService, lifecycleService, reconcileService)
+	retentionHandler := handlers.NewRetentionHandler(retentionService)
+	importHandler := handlers.NewVideoImportHandler(importService)
+	uploadHandler := handlers.NewVideoUploadHandler(uploadService, workshopService)
+	thumbnailHandler := handlers.NewThumbnailHandler(thumbnailService, videoService, workshopService)
+	clipHandler := handlers.NewClipHandler(clipService, workshopService)
+	timelineHandler := handlers.NewTimelineHandler(timelineService, workshopService)
+
+	// 启动定时任务
+	startCronJobs(cfg, retentionService)
 
 	// API 路由组
 	api := r.Group("/api") // 设置api前缀
@@ -95,19 +139,88 @@ func setupRouter(db *gorm.DB) *gin.Engine {
 			//videos.POST("", videoHandler.CreateVideo)
 			videos.GET("/:id", videoHandler.Get)
 			//videos.PUT("/:id", videoHandler.UpdateVideo)
-			videos.DELETE("/:id", videoHandler.Delete)
-			videos.GET("/:id/download", videoHandler.Download)
-			videos.DELETE("/batch", videoHandler.BatchDelete)
-			videos.GET("/stream", videoHandler.StreamVideo)
+			videos.DELETE("/:id", middleware.JWTAuth(), videoHandler.Delete)
+			videos.GET("/:id/download", middleware.MediaAuth(), videoHandler.Download)
+			videos.GET("/:id/stream", middleware.MediaAuth(), videoHandler.StreamVideo)
+			videos.GET("/:id/thumbnail", middleware.MediaAuth(), thumbnailHandler.Thumbnail)
+			videos.GET("/:id/sprite.jpg", middleware.MediaAuth(), thumbnailHandler.Sprite)
+			videos.GET("/:id/sprites.vtt", middleware.MediaAuth(), thumbnailHandler.SpriteVTT)
+			videos.PUT("/:id/hold", middleware.JWTAuth(), videoHandler.Hold)
+			videos.GET("/:id/holds", middleware.JWTAuth(), videoHandler.HoldLogs)
+			videos.POST("/hold", middleware.JWTAuth(), videoHandler.HoldRange)
+			videos.DELETE("/batch", middleware.JWTAuth(), videoHandler.BatchDelete)
+			videos.GET("/trash", middleware.JWTAuth(), videoHandler.ListTrash)
+			videos.POST("/trash/restore", middleware.JWTAuth(), videoHandler.Restore)
+			videos.DELETE("/trash", middleware.JWTAuth(), middleware.AdminOnly(), videoHandler.Purge)
+			videos.GET("/import", middleware.JWTAuth(), middleware.AdminOnly(), importHandler.Job)
+			videos.POST("/import", middleware.JWTAuth(), middleware.AdminOnly(), importHandler.Start)
+			videos.POST("/upload", middleware.JWTAuth(), uploadHandler.Upload)
+			videos.POST("/uploads", middleware.JWTAuth(), uploadHandler.Create)
+			videos.GET("/uploads/:id", middleware.JWTAuth(), uploadHandler.Get)
+			videos.HEAD("/uploads/:id", middleware.JWTAuth(), uploadHandler.Get)
+			videos.PATCH("/uploads/:id", middleware.JWTAuth(), uploadHandler.Write)
+			videos.DELETE("/uploads/:id", middleware.JWTAuth(), uploadHandler.Delete)
 		}
 
 		// 车间相关路由
 		workshops := api.Group("/workshops")
 		{
 			workshops.GET("", workshopHandler.List)
-			workshops.POST("", workshopHandler.Create)
-			workshops.PUT("/:id", workshopHandler.Update)
-			workshops.DELETE("/:id", workshopHandler.Delete)
+			workshops.POST("", middleware.JWTAuth(), middleware.AdminOnly(), workshopHandler.Create)
+			workshops.PUT("/:id", middleware.JWTAuth(), middleware.AdminOnly(), workshopHandler.Update)
+			workshops.DELETE("/:id", middleware.JWTAuth(), middleware.AdminOnly(), workshopHandler.Delete)
+			workshops.GET("/:id/permissions", middleware.JWTAuth(), middleware.AdminOnly(), workshopHandler.ListPermissions)
+			workshops.POST("/:id/permissions", middleware.JWTAuth(), middleware.AdminOnly(), workshopHandler.Grant)
+			workshops.DELETE("/:id/permissions/:userId", middleware.JWTAuth(), middleware.AdminOnly(), workshopHandler.Revoke)
+			workshops.GET("/:id/preview", middleware.JWTAuth(), workshopHandler.GetPreview)
+			workshops.GET("/:id/timeline", middleware.JWTAuth(), timelineHandler.Timeline)
+			workshops.GET("/:id/playback.m3u8", middleware.MediaAuth(), timelineHandler.Playlist)
+			workshops.GET("/:id/playback/:videoId/:segment", middleware.MediaAuth(), timelineHandler.Segment)
+			workshops.PUT("/:id/storage", middleware.JWTAuth(), middleware.AdminOnly(), storageHandler.AssignWorkshop)
+			workshops.GET("/:id/tags", middleware.JWTAuth(), tagHandler.List)
+			workshops.POST("/:id/tags", middleware.JWTAuth(), middleware.AdminOnly(), tagHandler.Bind)
+			workshops.DELETE("/:id/tags/:tagId", middleware.JWTAuth(), middleware.AdminOnly(), tagHandler.Unbind)
+		}
+
+		// 录像存储目标管理，配置中包含密钥，仅管理员可用
+		storages := api.Group("/storages", middleware.JWTAuth(), middleware.AdminOnly())
+		{
+			storages.GET("", storageHandler.List)
+			storages.POST("", storageHandler.Create)
+			storages.POST("/test", storageHandler.Test)
+			storages.GET("/rules", storageHandler.ListRules)
+			storages.POST("/rules", storageHandler.CreateRule)
+			storages.POST("/rules/run", storageHandler.RunRules)
+			storages.PUT("/rules/:id", storageHandler.UpdateRule)
+			storages.DELETE("/rules/:id", storageHandler.DeleteRule)
+			storages.GET("/reconcile", storageHandler.ReconcileReport)
+			storages.POST("/reconcile", storageHandler.Reconcile)
+			storages.POST("/reconcile/import", storageHandler.ImportOrphans)
+			storages.POST("/reconcile/missing", storageHandler.MarkMissing)
+			storages.PUT("/:id", storageHandler.Update)
+			storages.DELETE("/:id", storageHandler.Delete)
+			storages.POST("/:id/default", storageHandler.SetDefault)
+			storages.POST("/:id/test", storageHandler.TestSaved)
+		}
+
+		// 录像保留策略，预览和手动执行清理，仅管理员可用
+		retention := api.Group("/retention", middleware.JWTAuth(), middleware.AdminOnly())
+		{
+			retention.GET("/preview", retentionHandler.Preview)
+			retention.POST("/run", retentionHandler.Run)
+		}
+
+		// 摄像头画面路由，每个车间对应一路摄像头，供看板、工单系统等直接引用图片地址
+		cameras := api.Group("/cameras", middleware.MediaAuth())
+		{
+			cameras.GET("/:id/snapshot.jpg", mjpegHandler.Snapshot)
+			cameras.GET("/:id/mjpeg", mjpegHandler.Stream)
+		}
+
+		// 工业数据相关路由，采集端使用设备令牌上报
+		tags := api.Group("/tags")
+		{
+			tags.POST("/readings", middleware.DeviceAuth(), tagHandler.PushReadings)
 		}
 
 		// RTSP 流相关路由
@@ -127,26 +240,49 @@ func setupRouter(db *gorm.DB) *gin.Engine {
 			captures.POST("/:id/cancel", captureHandler.Cancel)
 		}
 
-		// WebRTC 相关路由
-		webrtc := api.Group("/webrtc")
+		// 录像片段导出，需要登录并校验车间权限，浏览器直接下载时可通过 token 参数传递登录凭证
+		clips := api.Group("/clips")
+		{
+			clips.POST("", middleware.JWTAuth(), clipHandler.Create)
+			clips.GET("", middleware.JWTAuth(), clipHandler.List)
+			clips.GET("/:id", middleware.JWTAuth(), clipHandler.Get)
+			clips.GET("/:id/download", middleware.MediaAuth(), clipHandler.Download)
+			clips.DELETE("/:id", middleware.JWTAuth(), clipHandler.Delete)
+		}
+
+		// WebRTC 相关路由，需要登录并校验车间权限
+		webrtc := api.Group("/webrtc", middleware.JWTAuth())
 		{
 			webrtc.POST("", webrtcHandler.HandleWebRTC)
+			webrtc.POST("/playback", webrtcHandler.HandlePlayback)
+			webrtc.POST("/url", middleware.AdminOnly(), webrtcHandler.HandleRTSPURL)
 		}
 
 	}
 
+	// 实时 HLS，播放列表中的地址相对于 /live/:id/
+	r.GET("/live/:id/:file", middleware.MediaAuth(), hlsHandler.Serve)
+
 	return r
 }
 
 // 启动定时任务
-func startCronJobs(videoService *services.VideoService) {
-	// 每天凌晨清理过期视频
+func startCronJobs(cfg *config.Config, retentionService *services.RetentionService) {
+	schedule := cfg.Storage.Retention.Schedule
+	if schedule == "" {
+		return
+	}
+
+	// 按保留策略清理录像，磁盘使用率超过高水位时紧急清理
 	c := cron.New(cron.WithLocation(time.Local))
-	c.AddFunc("0 0 * * *", func() {
-		if err := videoService.CleanExpiredVideos(); err != nil {
+	_, err := c.AddFunc(schedule, func() {
+		if _, _, err := retentionService.Run(context.Background()); err != nil {
 			log.Printf("Failed to clean expired videos: %v", err)
 		}
 	})
+	if err != nil {
+		log.Fatalf("Invalid retention schedule: %v", err)
+	}
 	c.Start()
 }
 
@@ -154,16 +290,18 @@ func main() {
 	// 初始化配置
 	initConfig()
 
+	// 子命令：导入已有录像文件
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(os.Args[2:])
+		return
+	}
+
 	// 初始化数据库连接
 	db := initDB()
 
 	// 设置路由
 	r := setupRouter(db)
 
-	// 启动定时任务
-	// videoService := services.NewVideoService(db)
-	// startCronJobs(videoService)
-
 	// 启动服务器
 	serverAddr := fmt.Sprintf(":%d", config.GlobalConfig.Server.Port)
 	log.Printf("Server starting on %s", serverAddr)
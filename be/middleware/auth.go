@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"errors"
 	"strings"
 	"time"
@@ -20,8 +21,21 @@ type Claims struct {
 
 // JWT认证中间件
 func JWTAuth() gin.HandlerFunc {
+	return jwtAuth(false)
+}
+
+// 媒体地址认证中间件，播放器加载 HLS 播放列表和切片、<img> 标签等无法设置请求头时，
+// 允许通过 token 参数传递登录凭证。仅用于实时画面和录像播放地址，其他接口只接受请求头
+func MediaAuth() gin.HandlerFunc {
+	return jwtAuth(true)
+}
+
+func jwtAuth(allowQuery bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.GetHeader("Authorization")
+		if token == "" && allowQuery {
+			token = c.Query("token")
+		}
 		if token == "" {
 			utils.Error(c, utils.ErrUnauthorized)
 			c.Abort()
@@ -48,6 +62,32 @@ func JWTAuth() gin.HandlerFunc {
 	}
 }
 
+// 仅允许管理员访问，需放在 JWTAuth 之后
+func AdminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("role") != config.RoleAdmin {
+			utils.Error(c, utils.ErrForbidden)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 采集端认证，采集端通过 X-Device-Token 请求头携带配置的设备令牌
+func DeviceAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := config.GlobalConfig.Tags.IngestToken
+		token := c.GetHeader("X-Device-Token")
+		if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			utils.Error(c, utils.ErrUnauthorized)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 // 生成token
 func GenerateToken(userID uint, username, role string) (string, error) {
 	claims := Claims{
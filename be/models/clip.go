@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// 录像片段导出任务，把时间段内的录像截取并拼接为一个文件
+type Clip struct {
+	BaseModel
+	WorkshopID   uint       `json:"workshopId" gorm:"index;not null"`
+	Workshop     Workshop   `json:"workshop" gorm:"foreignKey:WorkshopID"`
+	StartTime    time.Time  `json:"startTime" gorm:"not null"`
+	EndTime      time.Time  `json:"endTime" gorm:"not null"`
+	Status       string     `json:"status" gorm:"type:varchar(20);index"` // waiting, running, completed, failed
+	Progress     int        `json:"progress"`                             // 进度（百分比）
+	Mode         string     `json:"mode" gorm:"type:varchar(20)"`         // copy:直接复制流 reencode:重新编码
+	Segments     int        `json:"segments"`                             // 拼接的录像数
+	FileName     string     `json:"fileName" gorm:"type:varchar(255)"`
+	FileSize     int64      `json:"fileSize" gorm:"type:bigint"`
+	Duration     float64    `json:"duration" gorm:"type:decimal(10,2)"` // 导出文件的时长（秒），时间段内没有录像的部分被跳过
+	ErrorMessage string     `json:"errorMessage" gorm:"type:text"`
+	UserID       uint       `json:"userId" gorm:"index"`
+	ExpiresAt    *time.Time `json:"expiresAt" gorm:"index"` // 导出完成后开始计算，到期后删除文件和任务
+	DownloadURL  string     `json:"downloadUrl" gorm:"-"`   // 导出完成后的下载地址
+}
+
+// 导出车间录像片段
+type ClipRequest struct {
+	WorkshopID uint      `json:"workshopId" binding:"required"`
+	StartTime  time.Time `json:"startTime" binding:"required"`
+	EndTime    time.Time `json:"endTime" binding:"required,gtfield=StartTime"`
+}
@@ -3,11 +3,39 @@ package models
 // 存储配置模型
 type Storage struct {
 	BaseModel
-	Type      string `json:"type" gorm:"type:varchar(20);not null"` // local, s3, oss
-	Name      string `json:"name" gorm:"type:varchar(100);not null"`
-	Config    string `json:"config" gorm:"type:text"` // JSON格式的配置信息
+	Type      string `json:"type" gorm:"type:varchar(20);not null" binding:"required,oneof=local s3 oss"` // local, s3, oss
+	Name      string `json:"name" gorm:"type:varchar(100);not null;unique" binding:"required,max=100"`
+	Config    string `json:"config" gorm:"type:text" binding:"required"` // JSON格式的配置信息，返回时不包含密钥
 	IsDefault bool   `json:"isDefault" gorm:"default:false"`
-	Status    int    `json:"status" gorm:"type:tinyint;default:1"` // 1:启用 2:禁用
+	Status    int    `json:"status" gorm:"type:tinyint;default:1" binding:"omitempty,oneof=1 2"` // 1:启用 2:禁用
+}
+
+// 存储生命周期规则，把超过指定天数的录像从源存储迁移到归档存储
+type StorageRule struct {
+	BaseModel
+	Name            string `json:"name" gorm:"type:varchar(100);not null" binding:"required,max=100"`
+	SourceStorageID uint   `json:"sourceStorageId" gorm:"index"` // 0 表示配置文件中的存储
+	TargetStorageID uint   `json:"targetStorageId" gorm:"not null" binding:"required"`
+	AfterDays       int    `json:"afterDays" gorm:"not null" binding:"required,min=1"`                 // 录像开始时间超过该天数后迁移
+	Status          int    `json:"status" gorm:"type:tinyint;default:1" binding:"omitempty,oneof=1 2"` // 1:启用 2:禁用
+}
+
+// 车间存储分配请求，storageId 为 0 时使用默认存储
+type WorkshopStorageRequest struct {
+	StorageID uint `json:"storageId"`
+}
+
+// 导入存储中的孤立文件为录像记录
+type ReconcileImportRequest struct {
+	StorageID  uint     `json:"storageId"` // 0 表示配置文件中的存储
+	Storage    string   `json:"storage"`   // storageId 为 0 时的存储类型，默认 local
+	Keys       []string `json:"keys" binding:"required,min=1"`
+	WorkshopID uint     `json:"workshopId"` // 无法从文件路径识别车间时使用
+}
+
+// 把找不到文件的录像标记为文件丢失
+type ReconcileMissingRequest struct {
+	IDs []uint `json:"ids" binding:"required,min=1"`
 }
 
 // S3存储配置
@@ -17,9 +45,11 @@ type S3Config struct {
 	SecretAccessKey string `json:"secretAccessKey"`
 	BucketName      string `json:"bucketName"`
 	Region          string `json:"region"`
+	ForcePathStyle  bool   `json:"forcePathStyle"` // MinIO 等私有部署需开启
+	StorageClass    string `json:"storageClass"`   // 归档层可使用 STANDARD_IA 等，需可直接读取
 }
 
-// OSS存储配置
+// OSS存储配置，通过 OSS 的 S3 兼容接口访问
 type OSSConfig struct {
 	Endpoint        string `json:"endpoint"`
 	AccessKeyID     string `json:"accessKeyId"`
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// 车间关联的工业数据点，用于在实时画面上叠加显示
+type WorkshopTag struct {
+	BaseModel
+	WorkshopID uint   `json:"workshopId" gorm:"uniqueIndex:idx_workshop_tag;not null"`
+	Tag        string `json:"tag" gorm:"type:varchar(100);uniqueIndex:idx_workshop_tag;not null"` // 数据源中的点位标识
+	Name       string `json:"name" gorm:"type:varchar(100)"`                                      // 显示名称，如"炉温"
+	Unit       string `json:"unit" gorm:"type:varchar(20)"`                                       // 单位，如"℃"
+}
+
+// 数据点读数
+type TagReading struct {
+	Tag       string      `json:"tag" binding:"required"`
+	Name      string      `json:"name,omitempty"`
+	Value     interface{} `json:"value"`
+	Unit      string      `json:"unit,omitempty"`
+	Quality   string      `json:"quality,omitempty"` // good, bad, uncertain
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// 数据点绑定请求
+type WorkshopTagCreateRequest struct {
+	Tag  string `json:"tag" binding:"required,max=100"`
+	Name string `json:"name" binding:"max=100"`
+	Unit string `json:"unit" binding:"max=20"`
+}
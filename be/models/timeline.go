@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// 车间录像时间轴查询参数
+type TimelineQuery struct {
+	From time.Time `form:"from" binding:"required" time_format:"2006-01-02 15:04:05"`
+	To   time.Time `form:"to" binding:"required,gtfield=From" time_format:"2006-01-02 15:04:05"`
+}
+
+// 车间在一个时间段内的录像时间轴
+type Timeline struct {
+	WorkshopID uint              `json:"workshopId"`
+	From       time.Time         `json:"from"`
+	To         time.Time         `json:"to"`
+	Covered    []TimeRange       `json:"covered"`  // 有录像的时间段，相邻录像间隔很短时合并
+	Gaps       []TimeRange       `json:"gaps"`     // 没有录像的时间段
+	Segments   []TimelineSegment `json:"segments"` // 时间段内的录像，按开始时间排序
+	Coverage   float64           `json:"coverage"` // 有录像的时长占比（0-1）
+}
+
+// 时间段
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// 时间轴上的一个录像
+type TimelineSegment struct {
+	VideoID   uint      `json:"videoId"`
+	FileName  string    `json:"fileName"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Duration  float64   `json:"duration"`
+}
@@ -8,7 +8,10 @@ import (
 type Video struct {
 	BaseModel
 	FileName   string    `json:"fileName" gorm:"type:varchar(255);not null"`
-	FilePath   string    `json:"filePath" gorm:"type:varchar(255);not null"`
+	FilePath   string    `json:"filePath" gorm:"type:varchar(255);not null"`      // 存储内的对象键，旧记录为本地绝对路径
+	Storage    string    `json:"storage" gorm:"type:varchar(20);default:'local'"` // 存储类型 local, s3, oss
+	StorageID  uint      `json:"storageId" gorm:"index"`                          // 存储目标ID，0 表示配置文件中的存储
+	Checksum   string    `json:"checksum" gorm:"type:varchar(64)"`                // 文件 SHA-256，迁移到归档存储时计算并校验
 	FileSize   int64     `json:"fileSize" gorm:"type:bigint"`
 	Duration   float64   `json:"duration" gorm:"type:decimal(10,2)"` // 视频时长(秒)
 	WorkshopID uint      `json:"workshopId" gorm:"index"`
@@ -16,8 +19,33 @@ type Video struct {
 	CaptureID  uint      `json:"captureId" gorm:"index"` // 关联的采集任务ID
 	StartTime  time.Time `json:"startTime" gorm:"index"`
 	EndTime    time.Time `json:"endTime" gorm:"index"`
-	Status     int       `json:"status" gorm:"type:tinyint;default:1"` // 1:正常 2:已删除
-	Notes      string    `json:"notes" gorm:"type:text"`
+	Status     int       `json:"status" gorm:"type:tinyint;default:1;index"` // 1:正常 2:已删除（在回收站中） 3:删除中 4:文件丢失
+	// 直接引用的外部文件（如原地导入的 NAS 录像），只读：删除和清理时只删除记录，不移动、不删除文件，也不迁移到归档存储
+	External bool `json:"external" gorm:"default:false;index"`
+	// 保全（法律保全、事故取证等），保全期间录像不能被删除，也不会被自动清理
+	Protected  bool       `json:"protected" gorm:"default:false;index"`
+	HoldReason string     `json:"holdReason" gorm:"type:varchar(255)"`
+	HoldOwner  string     `json:"holdOwner" gorm:"type:varchar(100)"` // 保全负责人
+	HoldUntil  *time.Time `json:"holdUntil"`                          // 保全到期时间，为空表示一直保全
+	// 媒体信息，由 ffprobe 读取
+	Format     string     `json:"format" gorm:"type:varchar(50)"` // 容器格式
+	BitRate    int64      `json:"bitRate"`                        // 码率（bit/s）
+	VideoCodec string     `json:"videoCodec" gorm:"type:varchar(20)"`
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	FrameRate  float64    `json:"frameRate" gorm:"type:decimal(6,2)"`
+	AudioCodec string     `json:"audioCodec" gorm:"type:varchar(20)"` // 为空表示没有音频
+	ProbedAt   *time.Time `json:"probedAt"`                           // 读取媒体信息的时间，为空时由补全任务读取
+	// 封面图和拖动预览图，与录像保存在同一存储中
+	ThumbnailPath  string  `json:"thumbnailPath" gorm:"type:varchar(255)"` // 封面图的对象键，为空表示尚未生成
+	SpritePath     string  `json:"spritePath" gorm:"type:varchar(255)"`    // 预览图拼图的对象键
+	SpriteInterval float64 `json:"spriteInterval"`                         // 预览图间隔（秒）
+	SpriteColumns  int     `json:"spriteColumns"`                          // 拼图的列数
+	SpriteCount    int     `json:"spriteCount"`                            // 预览图数量
+	// 回收站，删除的录像文件移到回收站中，到期后自动清除
+	TrashPath string     `json:"-" gorm:"type:varchar(255)"` // 文件在回收站中的对象键
+	TrashedAt *time.Time `json:"trashedAt" gorm:"index"`     // 移入回收站的时间
+	Notes     string     `json:"notes" gorm:"type:text"`
 }
 
 // 视频查询参数
@@ -38,9 +66,68 @@ type VideoCreateRequest struct {
 	Notes      string    `json:"notes"`
 }
 
+// 录像保全请求，protected 为 false 时解除保全
+type VideoHoldRequest struct {
+	Protected bool       `json:"protected"`
+	Reason    string     `json:"reason" binding:"required_if=Protected true,max=255"`
+	Owner     string     `json:"owner" binding:"max=100"` // 为空时为当前用户
+	Until     *time.Time `json:"until"`
+}
+
+// 按时间段批量保全车间录像，与时间段有重叠的录像都会被保全
+type VideoHoldRangeRequest struct {
+	VideoHoldRequest
+	WorkshopID uint      `json:"workshopId" binding:"required"`
+	StartTime  time.Time `json:"startTime" binding:"required"`
+	EndTime    time.Time `json:"endTime" binding:"required,gtfield=StartTime"`
+}
+
+// 录像保全变更记录
+type VideoHoldLog struct {
+	BaseModel
+	VideoID   uint       `json:"videoId" gorm:"index;not null"`
+	Action    string     `json:"action" gorm:"type:varchar(20);not null"` // hold:保全 release:解除
+	Reason    string     `json:"reason" gorm:"type:varchar(255)"`
+	Owner     string     `json:"owner" gorm:"type:varchar(100)"`
+	HoldUntil *time.Time `json:"holdUntil"`
+	Operator  string     `json:"operator" gorm:"type:varchar(100)"` // 操作人
+}
+
+// 分片上传中的录像，上传完成后登记为录像并删除
+type VideoUpload struct {
+	ID         string     `json:"id" gorm:"primaryKey;type:varchar(32)"`
+	WorkshopID uint       `json:"workshopId" gorm:"index"`
+	FileName   string     `json:"fileName" gorm:"type:varchar(255);not null"`
+	Size       int64      `json:"size" gorm:"type:bigint"`
+	Offset     int64      `json:"offset" gorm:"-"` // 已上传的字节数，按临时文件大小计算
+	StartTime  *time.Time `json:"startTime"`       // 为空时取录像元数据中的录制时间
+	Notes      string     `json:"notes" gorm:"type:text"`
+	UserID     uint       `json:"userId" gorm:"index"`
+	ExpiresAt  time.Time  `json:"expiresAt" gorm:"index"` // 到期未完成的上传被清除，每次上传分片后顺延
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// 创建分片上传
+type VideoUploadRequest struct {
+	WorkshopID uint       `json:"workshopId" form:"workshopId" binding:"required"`
+	FileName   string     `json:"fileName" binding:"max=255"`
+	Size       int64      `json:"size" binding:"min=0"`
+	MimeType   string     `json:"mimeType"`
+	StartTime  *time.Time `json:"startTime" form:"startTime"`
+	Notes      string     `json:"notes" form:"notes"`
+}
+
 // 视频更新请求
 type VideoUpdateRequest struct {
 	FileName string `json:"fileName"`
 	Notes    string `json:"notes"`
 	Status   int    `json:"status"`
 }
+
+// 导入目录中已有的录像文件
+type VideoImportRequest struct {
+	Dir        string          `json:"dir" binding:"required"`
+	WorkshopID uint            `json:"workshopId"` // 无法按子目录识别车间时使用
+	Mapping    map[string]uint `json:"mapping"`    // 一级子目录名到车间ID，未配置的子目录按车间名称匹配
+	Copy       bool            `json:"copy"`       // 复制到车间的存储，否则直接引用原文件
+}
@@ -1,9 +1,21 @@
 package models
 
+import (
+	"time"
+)
+
 // WebRTCRequest 前端发送的请求结构
+// 只允许指定车间ID，RTSP地址及认证信息由服务端从数据库中解析
 type WebRTCRequest struct {
-	RTSPURL string `json:"rtspUrl"`
-	SDP     string `json:"sdp"`
+	WorkshopID uint   `json:"workshopId" binding:"required"`
+	SDP        string `json:"sdp" binding:"required"`
+	Quality    string `json:"quality" binding:"omitempty,oneof=main sub auto"` // 画质偏好，默认按配置
+}
+
+// WebRTCURLRequest 直接预览 RTSP 地址的请求，仅管理员可用
+type WebRTCURLRequest struct {
+	RTSPUrl string `json:"rtspUrl" binding:"required"`
+	SDP     string `json:"sdp" binding:"required"`
 }
 
 // WebRTCResponse 返回给前端的响应结构
@@ -12,3 +24,37 @@ type WebRTCResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message,omitempty"`
 }
+
+// TagUpdateMessage 通过数据通道推送给前端的工业数据。
+// RTPTimestamp 为 ServerTime 时刻视频轨道对应的 RTP 时间戳（90kHz），
+// 前端据此把读数时间换算到视频帧上叠加显示。
+type TagUpdateMessage struct {
+	Type         string       `json:"type"` // 固定为 tags
+	ServerTime   time.Time    `json:"serverTime"`
+	RTPTimestamp uint32       `json:"rtpTimestamp"`
+	Readings     []TagReading `json:"readings"`
+}
+
+// PlaybackRequest 录像回放请求
+type PlaybackRequest struct {
+	VideoID uint    `json:"videoId" binding:"required"`
+	SDP     string  `json:"sdp" binding:"required"`
+	Offset  float64 `json:"offset" binding:"omitempty,min=0"` // 起始位置(秒)
+}
+
+// PlaybackControlMessage 前端通过 control 数据通道发送的回放控制指令
+type PlaybackControlMessage struct {
+	Type     string  `json:"type"`               // seek, pause, play, rate, status
+	Position float64 `json:"position,omitempty"` // seek 目标位置(秒)
+	Rate     float64 `json:"rate,omitempty"`     // rate 播放速率
+}
+
+// PlaybackStatusMessage 服务端推送的回放状态
+type PlaybackStatusMessage struct {
+	Type     string  `json:"type"` // status, ended, error
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration"`
+	Rate     float64 `json:"rate"`
+	Paused   bool    `json:"paused"`
+	Message  string  `json:"message,omitempty"`
+}
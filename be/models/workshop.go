@@ -3,24 +3,66 @@ package models
 // 车间模型
 type Workshop struct {
 	BaseModel
-	Name        string  `json:"name" gorm:"type:varchar(100);not null;unique"`
-	RTSPUrl     string  `json:"rtspUrl" gorm:"type:varchar(255);not null"`
-	Status      int     `json:"status" gorm:"type:tinyint;default:0"` // 2:离线 1:在线
-	Description string  `json:"description" gorm:"type:text"`
-	Videos      []Video `json:"videos" gorm:"foreignKey:WorkshopID"`
+	Name       string `json:"name" gorm:"type:varchar(100);not null;unique"`
+	RTSPUrl    string `json:"rtspUrl" gorm:"type:varchar(255);not null"` // 主码流
+	SubRTSPUrl string `json:"subRtspUrl" gorm:"type:varchar(255)"`       // 子码流，弱网时使用
+	RTSPUser   string `json:"rtspUser" gorm:"type:varchar(100)"`
+	RTSPPass   string `json:"rtspPass,omitempty" gorm:"type:varchar(100)"` // 仅写入，列表中不返回
+	// RTSP 转发的读取账号，未设置时不对外转发该车间画面
+	RestreamUser string `json:"restreamUser" gorm:"type:varchar(100)"`
+	RestreamPass string `json:"restreamPass,omitempty" gorm:"type:varchar(100)"` // 仅写入，列表中不返回
+	Status       int    `json:"status" gorm:"type:tinyint;default:0"`            // 2:离线 1:在线
+	RecordAudio  bool   `json:"recordAudio" gorm:"default:false"`                // 录像是否保留音频
+	StorageID    uint   `json:"storageId" gorm:"default:0"`                      // 录像存储目标，0 时使用默认存储
+	// 录像保留天数，0 时使用全局默认，-1 表示不按时间清理
+	RetentionDays int `json:"retentionDays" gorm:"default:0" binding:"min=-1"`
+	// 录像总容量上限（GB），超出时删除最早的未保全录像，0 表示不限制
+	RetentionMaxGB int     `json:"retentionMaxGb" gorm:"default:0" binding:"min=0"`
+	Description    string  `json:"description" gorm:"type:text"`
+	Videos         []Video `json:"videos" gorm:"foreignKey:WorkshopID"`
 }
 
 // 车间创建请求
 type WorkshopCreateRequest struct {
-	Name        string `json:"name" binding:"required,max=100"`
-	RTSPUrl     string `json:"rtspUrl" binding:"required,url"`
-	Description string `json:"description"`
+	Name         string `json:"name" binding:"required,max=100"`
+	RTSPUrl      string `json:"rtspUrl" binding:"required,url"`
+	SubRTSPUrl   string `json:"subRtspUrl" binding:"omitempty,url"`
+	RTSPUser     string `json:"rtspUser"`
+	RTSPPass     string `json:"rtspPass"`
+	RestreamUser string `json:"restreamUser"`
+	RestreamPass string `json:"restreamPass"`
+	RecordAudio  bool   `json:"recordAudio"`
+	Description  string `json:"description"`
+	// 保留策略，见 Workshop
+	RetentionDays  int `json:"retentionDays" binding:"min=-1"`
+	RetentionMaxGB int `json:"retentionMaxGb" binding:"min=0"`
 }
 
-// 车间更新请求
+// 车间更新请求，未填写的字段保持不变；开关和保留策略使用指针区分未填写和零值
 type WorkshopUpdateRequest struct {
-	Name        string `json:"name" binding:"max=100"`
-	RTSPUrl     string `json:"rtspUrl" binding:"url"`
-	Status      int    `json:"status" binding:"oneof=0 1"`
-	Description string `json:"description"`
+	Name         string `json:"name" binding:"max=100"`
+	RTSPUrl      string `json:"rtspUrl" binding:"omitempty,url"`
+	SubRTSPUrl   string `json:"subRtspUrl" binding:"omitempty,url"`
+	RTSPUser     string `json:"rtspUser"`
+	RTSPPass     string `json:"rtspPass"`
+	RestreamUser string `json:"restreamUser"`
+	RestreamPass string `json:"restreamPass"`
+	Status       int    `json:"status" binding:"omitempty,oneof=1 2"`
+	RecordAudio  *bool  `json:"recordAudio"`
+	Description  string `json:"description"`
+	// 保留策略，见 Workshop
+	RetentionDays  *int `json:"retentionDays" binding:"omitempty,min=-1"`
+	RetentionMaxGB *int `json:"retentionMaxGb" binding:"omitempty,min=0"`
+}
+
+// 车间访问权限，记录普通用户可以查看哪些车间的实时画面
+type WorkshopPermission struct {
+	BaseModel
+	UserID     uint `json:"userId" gorm:"uniqueIndex:idx_user_workshop;not null"`
+	WorkshopID uint `json:"workshopId" gorm:"uniqueIndex:idx_user_workshop;not null"`
+}
+
+// 车间访问权限授予请求
+type WorkshopPermissionRequest struct {
+	UserID uint `json:"userId" binding:"required"`
 }
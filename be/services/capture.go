@@ -1,23 +1,28 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"time"
+	"videodb/be/config"
 	"videodb/be/models"
+	"videodb/be/utils"
 
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 	"gorm.io/gorm"
 )
 
 type CaptureService struct {
-	db *gorm.DB
+	db      *gorm.DB
+	storage *VideoStorage
 }
 
-func NewCaptureService(db *gorm.DB) *CaptureService {
-	return &CaptureService{db: db}
+func NewCaptureService(db *gorm.DB, storage *VideoStorage) *CaptureService {
+	return &CaptureService{db: db, storage: storage}
 }
 
 func (s *CaptureService) Create(capture *models.Capture) error {
@@ -84,16 +89,14 @@ func (s *CaptureService) startCapture(capture *models.Capture) {
 		return
 	}
 
-	// 创建基础存储目录
-	homeDir, err := os.UserHomeDir()
+	rtspURL, err := workshopStreamURL(&workshop)
 	if err != nil {
+		s.updateCaptureStatus(capture, "failed", err.Error())
 		return
 	}
-	baseStorageDir := filepath.Join(homeDir, "videodb", "storage", "captures")
-	//baseStorageDir := filepath.Join("/tmp", "videodb", "storage", "captures")
 
-	// 创建输出目录 - 使用车间ID
-	outputDir := filepath.Join(baseStorageDir, fmt.Sprintf("%d", workshop.ID))
+	// 采集先写入临时目录，完成后再存入录像存储 - 使用车间ID
+	outputDir := filepath.Join(config.GlobalConfig.Storage.TempPath, "captures", fmt.Sprintf("%d", workshop.ID))
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		s.updateCaptureStatus(capture, "failed", fmt.Sprintf("创建输出目录失败: %v", err))
 		return
@@ -114,10 +117,11 @@ func (s *CaptureService) startCapture(capture *models.Capture) {
 
 		// 生成输出文件名
 		timestamp := currentTime.Format("20060102_150405")
-		outputFile := filepath.Join(outputDir, fmt.Sprintf("capture_%s.mp4", timestamp))
+		fileName := fmt.Sprintf("capture_%s.mp4", timestamp)
+		outputFile := filepath.Join(outputDir, fileName)
 
 		// 执行视频采集
-		err := s.captureVideo(workshop.RTSPUrl, outputFile, capture.Interval)
+		err := s.captureVideo(rtspURL, outputFile, capture.Interval, workshop.RecordAudio)
 		if err != nil {
 			s.updateCaptureStatus(capture, "failed", fmt.Sprintf("视频采集失败: %v", err))
 			return
@@ -130,10 +134,32 @@ func (s *CaptureService) startCapture(capture *models.Capture) {
 			return
 		}
 
+		// 读取实际录制的时长和编码信息，读取失败时按采集间隔记录，之后由补全任务重新读取
+		media, err := utils.NewFFprobe(config.GlobalConfig.RTSP.FFprobePath).Probe(context.Background(), outputFile)
+		if err != nil {
+			fmt.Printf("Failed to probe captured file %s: %v\n", outputFile, err)
+		}
+
+		// 存入车间的录像存储，对象键按车间区分
+		target, err := s.storage.Target(workshop.ID)
+		if err != nil {
+			os.Remove(outputFile)
+			s.updateCaptureStatus(capture, "failed", fmt.Sprintf("获取录像存储失败: %v", err))
+			return
+		}
+		key := path.Join("captures", fmt.Sprintf("%d", workshop.ID), fileName)
+		if err := target.Driver.Put(context.Background(), key, outputFile); err != nil {
+			os.Remove(outputFile)
+			s.updateCaptureStatus(capture, "failed", fmt.Sprintf("保存视频文件失败: %v", err))
+			return
+		}
+
 		// 创建视频记录
 		video := &models.Video{
-			FileName:   filepath.Base(outputFile),
-			FilePath:   outputFile,
+			FileName:   fileName,
+			FilePath:   key,
+			Storage:    target.Type,
+			StorageID:  target.ID,
 			FileSize:   fileInfo.Size(),
 			Duration:   float64(capture.Interval * 60), // 转换为秒
 			WorkshopID: capture.WorkshopID,
@@ -143,6 +169,9 @@ func (s *CaptureService) startCapture(capture *models.Capture) {
 			Status:     1,
 			Notes:      fmt.Sprintf("自动采集 - 任务ID:%d", capture.ID),
 		}
+		if media != nil {
+			applyMediaInfo(video, media)
+		}
 
 		if err := s.db.Create(video).Error; err != nil {
 			s.updateCaptureStatus(capture, "failed", fmt.Sprintf("保存视频记录失败: %v", err))
@@ -165,18 +194,27 @@ func (s *CaptureService) startCapture(capture *models.Capture) {
 	s.updateCaptureStatus(capture, "completed", "")
 }
 
-func (s *CaptureService) captureVideo(rtspUrl string, outputFile string, durationMinutes int) error {
+func (s *CaptureService) captureVideo(rtspUrl string, outputFile string, durationMinutes int, recordAudio bool) error {
+	outputArgs := ffmpeg.KwArgs{
+		"c:v":    "libx264",
+		"preset": "medium",
+		"crf":    "23",
+	}
+	// 根据车间设置决定是否保留音频
+	if recordAudio {
+		outputArgs["map"] = []string{"0:v:0", "0:a:0?"}
+		outputArgs["c:a"] = "aac"
+	} else {
+		outputArgs["an"] = ""
+	}
+
 	// 使用 FFmpeg 采集视频
 	// 设置采集时长为指定的分钟数
 	err := ffmpeg.Input(rtspUrl, ffmpeg.KwArgs{
 		"rtsp_transport": "tcp",
 		"t":              fmt.Sprintf("%d", durationMinutes*60), // 转换为秒
 	}).
-		Output(outputFile, ffmpeg.KwArgs{
-			"c:v":    "libx264",
-			"preset": "medium",
-			"crf":    "23",
-		}).
+		Output(outputFile, outputArgs).
 		OverWriteOutput().
 		Run()
 
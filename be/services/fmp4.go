@@ -0,0 +1,132 @@
+package services
+
+import "encoding/binary"
+
+// 遍历 MP4 box，回调返回 false 时停止
+func walkMP4Boxes(data []byte, fn func(boxType string, payload []byte) bool) {
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data[0:4]))
+		boxType := string(data[4:8])
+		header := 8
+		switch size {
+		case 0:
+			size = len(data)
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size = int(binary.BigEndian.Uint64(data[8:16]))
+			header = 16
+		}
+		if size < header || size > len(data) {
+			return
+		}
+		if !fn(boxType, data[header:size]) {
+			return
+		}
+		data = data[size:]
+	}
+}
+
+// fmp4FirstSampleIsSync 判断 fMP4 分片中指定轨道的第一个样本是否为同步样本（关键帧），
+// 无法从分片中确定时按关键帧处理
+func fmp4FirstSampleIsSync(data []byte, trackID uint32) bool {
+	sync := true
+	found := false
+
+	walkMP4Boxes(data, func(boxType string, moof []byte) bool {
+		if boxType != "moof" {
+			return true
+		}
+		walkMP4Boxes(moof, func(boxType string, traf []byte) bool {
+			if boxType != "traf" {
+				return true
+			}
+			if flags, ok := trafFirstSampleFlags(traf, trackID); ok {
+				// sample_is_non_sync_sample
+				sync = (flags>>16)&1 == 0
+				found = true
+				return false
+			}
+			return true
+		})
+		// 只检查第一个 moof
+		return false
+	})
+
+	if !found {
+		return true
+	}
+	return sync
+}
+
+// trafFirstSampleFlags 从 traf 中读取第一个样本的 sample_flags
+func trafFirstSampleFlags(traf []byte, trackID uint32) (uint32, bool) {
+	var defaultFlags uint32
+	hasDefault := false
+	matched := false
+	var result uint32
+	found := false
+
+	walkMP4Boxes(traf, func(boxType string, payload []byte) bool {
+		switch boxType {
+		case "tfhd":
+			if len(payload) < 8 {
+				return false
+			}
+			flags := binary.BigEndian.Uint32(payload[0:4]) & 0xffffff
+			if binary.BigEndian.Uint32(payload[4:8]) != trackID {
+				return false
+			}
+			matched = true
+
+			offset := 8
+			for _, field := range []struct {
+				flag uint32
+				size int
+			}{{0x01, 8}, {0x02, 4}, {0x08, 4}, {0x10, 4}} {
+				if flags&field.flag != 0 {
+					offset += field.size
+				}
+			}
+			if flags&0x20 != 0 && len(payload) >= offset+4 {
+				defaultFlags = binary.BigEndian.Uint32(payload[offset : offset+4])
+				hasDefault = true
+			}
+		case "trun":
+			if !matched || len(payload) < 8 {
+				return false
+			}
+			flags := binary.BigEndian.Uint32(payload[0:4]) & 0xffffff
+			offset := 8
+			if flags&0x01 != 0 {
+				offset += 4
+			}
+			if flags&0x04 != 0 {
+				if len(payload) >= offset+4 {
+					result, found = binary.BigEndian.Uint32(payload[offset:offset+4]), true
+				}
+				return false
+			}
+			if flags&0x400 != 0 {
+				if flags&0x100 != 0 {
+					offset += 4
+				}
+				if flags&0x200 != 0 {
+					offset += 4
+				}
+				if len(payload) >= offset+4 {
+					result, found = binary.BigEndian.Uint32(payload[offset:offset+4]), true
+				}
+				return false
+			}
+			if hasDefault {
+				result, found = defaultFlags, true
+			}
+			return false
+		}
+		return true
+	})
+
+	return result, found
+}
@@ -0,0 +1,693 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"videodb/be/config"
+	"videodb/be/utils"
+)
+
+const (
+	hlsSourcePlaylist = "stream.m3u8" // FFmpeg 输出的播放列表，仅用于获取切片信息
+	hlsInitFile       = "init.mp4"
+	hlsStartTimeout   = 20 * time.Second
+	hlsVideoTrackID   = 1
+	// 移出播放列表后仍保留在磁盘上的切片数，供稍慢的播放器继续下载
+	hlsKeepSegments = 2
+)
+
+// HLSService 把共享拉流按需封装为实时 HLS（可选 LL-HLS 分片），播放列表和切片由后端直接提供。
+// 首次请求播放列表时启动，超过空闲时间无请求后停止 FFmpeg 并删除临时文件。
+type HLSService struct {
+	config          config.HLSConfig
+	ffmpegPath      string
+	hub             *StreamHub
+	workshopService *WorkshopService
+
+	mutex   sync.Mutex
+	muxers  map[uint]*hlsMuxer
+	pending map[uint]*pendingStart // 正在启动的切片
+}
+
+func NewHLSService(cfg *config.Config, hub *StreamHub, workshopService *WorkshopService) *HLSService {
+	hlsConfig := cfg.HLS
+	if hlsConfig.Dir == "" {
+		hlsConfig.Dir = filepath.Join(cfg.Storage.TempPath, "hls")
+	}
+	if hlsConfig.SegmentDuration <= 0 {
+		hlsConfig.SegmentDuration = 2 * time.Second
+	}
+	if hlsConfig.PartDuration <= 0 {
+		hlsConfig.PartDuration = 500 * time.Millisecond
+	}
+	if hlsConfig.ListSize <= 0 {
+		hlsConfig.ListSize = 6
+	}
+	if hlsConfig.IdleTimeout <= 0 {
+		hlsConfig.IdleTimeout = 30 * time.Second
+	}
+
+	s := &HLSService{
+		config:          hlsConfig,
+		ffmpegPath:      cfg.RTSP.FFmpegPath,
+		hub:             hub,
+		workshopService: workshopService,
+		muxers:          make(map[uint]*hlsMuxer),
+		pending:         make(map[uint]*pendingStart),
+	}
+	go s.reap()
+	return s
+}
+
+// Playlist 返回车间的实时播放列表，必要时启动切片。
+// msn/part 为 LL-HLS 阻塞式刷新参数（_HLS_msn/_HLS_part），为负数表示不等待。
+// token 会附加到播放列表中的地址上，供无法设置请求头的播放器继续鉴权。
+func (s *HLSService) Playlist(ctx context.Context, workshopID uint, msn, part int, token string) ([]byte, error) {
+	m, err := s.muxer(workshopID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.waitReady(ctx); err != nil {
+		return nil, err
+	}
+	if msn >= 0 && s.config.LowLatency {
+		m.waitFor(ctx, msn, part)
+	}
+
+	var query string
+	if token != "" {
+		query = "?token=" + url.QueryEscape(token)
+	}
+	return m.playlist(query), nil
+}
+
+// File 返回初始化段、切片或 LL-HLS 分片的内容，只有正在运行的切片才能访问
+func (s *HLSService) File(workshopID uint, name string) ([]byte, error) {
+	s.mutex.Lock()
+	m, ok := s.muxers[workshopID]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, utils.ErrFileNotFound
+	}
+
+	m.touch()
+	return m.file(name)
+}
+
+func (s *HLSService) muxer(workshopID uint) (*hlsMuxer, error) {
+	for {
+		s.mutex.Lock()
+		if m, ok := s.muxers[workshopID]; ok && !m.isClosed() {
+			s.mutex.Unlock()
+			m.touch()
+			return m, nil
+		}
+		if p, ok := s.pending[workshopID]; ok {
+			s.mutex.Unlock()
+			if err := p.wait(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		p := newPendingStart()
+		s.pending[workshopID] = p
+		s.mutex.Unlock()
+
+		// 连接摄像头和启动 FFmpeg 期间不持有锁，避免阻塞其他车间的请求
+		m, err := s.newMuxer(workshopID)
+
+		s.mutex.Lock()
+		delete(s.pending, workshopID)
+		if err == nil {
+			s.muxers[workshopID] = m
+		}
+		s.mutex.Unlock()
+		p.finish(err)
+
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+}
+
+// newMuxer 按车间配置的主码流启动切片
+func (s *HLSService) newMuxer(workshopID uint) (*hlsMuxer, error) {
+	workshop, err := s.workshopService.GetByID(workshopID)
+	if err != nil {
+		return nil, err
+	}
+	urls, err := s.workshopService.LiveStreamURLs(workshop)
+	if err != nil {
+		return nil, err
+	}
+	return s.startMuxer(workshopID, urls.Main)
+}
+
+func (s *HLSService) removeMuxer(m *hlsMuxer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.muxers[m.workshopID] == m {
+		delete(s.muxers, m.workshopID)
+	}
+}
+
+// reap 定期停止没有观看者的切片
+func (s *HLSService) reap() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var idle []*hlsMuxer
+
+		s.mutex.Lock()
+		for id, m := range s.muxers {
+			if m.idleSince() > s.config.IdleTimeout {
+				delete(s.muxers, id)
+				idle = append(idle, m)
+			}
+		}
+		s.mutex.Unlock()
+
+		for _, m := range idle {
+			m.close()
+		}
+	}
+}
+
+// hlsMuxer 一个车间的实时切片，共享拉流的 RTP 包经本地 UDP 送给 FFmpeg 封装为 fMP4 切片。
+// LL-HLS 模式下 FFmpeg 按分片时长切分，由后端把分片组合成完整切片并生成播放列表。
+type hlsMuxer struct {
+	service    *HLSService
+	workshopID uint
+	dir        string
+
+	sub    *StreamSubscription
+	cmd    *exec.Cmd
+	exited chan struct{}
+	stop   chan struct{}
+
+	forwarder rtpForwarder
+
+	mutex      sync.Mutex
+	lastAccess time.Time
+	segments   []*hlsSegment
+	nextMSN    int
+	lastFile   int
+	updated    chan struct{}
+	closed     bool
+}
+
+type hlsSegment struct {
+	msn      int
+	parts    []*hlsPart
+	complete bool
+}
+
+func (seg *hlsSegment) duration() float64 {
+	var d float64
+	for _, p := range seg.parts {
+		d += p.duration
+	}
+	return d
+}
+
+type hlsPart struct {
+	name        string
+	duration    float64
+	independent bool
+}
+
+func (s *HLSService) startMuxer(workshopID uint, rtspURL string) (*hlsMuxer, error) {
+	dir := filepath.Join(s.config.Dir, strconv.FormatUint(uint64(workshopID), 10))
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to clean HLS directory: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create HLS directory: %v", err)
+	}
+
+	m := &hlsMuxer{
+		service:    s,
+		workshopID: workshopID,
+		dir:        dir,
+		exited:     make(chan struct{}),
+		stop:       make(chan struct{}),
+		lastAccess: time.Now(),
+		lastFile:   -1,
+		updated:    make(chan struct{}),
+	}
+
+	key := fmt.Sprintf("%d/%s", workshopID, config.StreamQualityMain)
+	sub, err := s.hub.Subscribe(key, rtspURL, m.forwarder.writeVideo, m.forwarder.writeAudio)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	m.sub = sub
+
+	if err := m.start(); err != nil {
+		sub.Close()
+		m.forwarder.close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	go m.watchPlaylist()
+	go func() {
+		select {
+		case <-sub.Done():
+		case <-m.exited:
+		}
+		s.removeMuxer(m)
+		m.close()
+	}()
+
+	return m, nil
+}
+
+// start 生成描述本地 RTP 输入的 SDP 并启动 FFmpeg
+func (m *hlsMuxer) start() error {
+	videoTrack, audioTrack := m.sub.RTSPTracks()
+
+	sdpPath := filepath.Join(m.dir, "stream.sdp")
+	if err := m.forwarder.prepare(sdpPath, videoTrack, audioTrack); err != nil {
+		return err
+	}
+
+	cfg := m.service.config
+	hlsTime := cfg.SegmentDuration
+	hlsFlags := "temp_file"
+	if cfg.LowLatency {
+		// 按分片时长切分，分片不一定从关键帧开始
+		hlsTime = cfg.PartDuration
+		hlsFlags += "+split_by_time"
+	}
+
+	args := []string{
+		"-loglevel", "error",
+		"-protocol_whitelist", "file,udp,rtp",
+		"-i", sdpPath,
+		"-map", "0:v:0",
+		"-c:v", "copy",
+	}
+	if audioTrack != nil {
+		args = append(args,
+			"-map", "0:a:0",
+			"-c:a", "aac",
+			"-b:a", "64k")
+	}
+	args = append(args,
+		"-f", "hls",
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", hlsInitFile,
+		"-hls_segment_filename", filepath.Join(m.dir, "f%d.m4s"),
+		"-hls_time", strconv.FormatFloat(hlsTime.Seconds(), 'f', 3, 64),
+		"-hls_list_size", strconv.Itoa(hlsListFiles(cfg)),
+		"-hls_flags", hlsFlags,
+		filepath.Join(m.dir, hlsSourcePlaylist))
+
+	ffmpegPath := m.service.ffmpegPath
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	m.cmd = exec.Command(ffmpegPath, args...)
+	if err := m.cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start HLS muxer: %v", err)
+	}
+	go func() {
+		m.cmd.Wait()
+		close(m.exited)
+	}()
+
+	// FFmpeg 启动后再开始转发 RTP 包
+	if err := m.forwarder.connect(); err != nil {
+		m.cmd.Process.Kill()
+		return err
+	}
+	return nil
+}
+
+// FFmpeg 播放列表保留的文件数，需覆盖后端播放列表窗口内的所有分片
+func hlsListFiles(cfg config.HLSConfig) int {
+	n := cfg.ListSize + hlsKeepSegments + 1
+	if cfg.LowLatency {
+		n *= int(math.Ceil(cfg.SegmentDuration.Seconds()/cfg.PartDuration.Seconds())) * 2
+	}
+	return n
+}
+
+// watchPlaylist 轮询 FFmpeg 的播放列表，登记新生成的切片或分片
+func (m *hlsMuxer) watchPlaylist() {
+	interval := 100 * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(filepath.Join(m.dir, hlsSourcePlaylist))
+		if err != nil {
+			continue
+		}
+
+		var duration float64
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "#EXTINF:") {
+				value := strings.TrimSuffix(strings.TrimPrefix(line, "#EXTINF:"), ",")
+				if i := strings.Index(value, ","); i >= 0 {
+					value = value[:i]
+				}
+				duration, _ = strconv.ParseFloat(value, 64)
+				continue
+			}
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			number, ok := hlsFileNumber(line)
+			if !ok || number <= m.lastFileNumber() {
+				continue
+			}
+			m.addFile(line, number, duration)
+		}
+	}
+}
+
+// hlsFileNumber 解析 FFmpeg 输出文件 f<N>.m4s 的编号
+func hlsFileNumber(name string) (int, bool) {
+	if !strings.HasPrefix(name, "f") || !strings.HasSuffix(name, ".m4s") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "f"), ".m4s"))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func (m *hlsMuxer) lastFileNumber() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.lastFile
+}
+
+func (m *hlsMuxer) addFile(name string, number int, duration float64) {
+	cfg := m.service.config
+
+	independent := true
+	if cfg.LowLatency {
+		data, err := os.ReadFile(filepath.Join(m.dir, name))
+		if err != nil {
+			return
+		}
+		independent = fmp4FirstSampleIsSync(data, hlsVideoTrackID)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		return
+	}
+	m.lastFile = number
+	part := &hlsPart{name: name, duration: duration, independent: independent}
+
+	var last *hlsSegment
+	if len(m.segments) > 0 {
+		last = m.segments[len(m.segments)-1]
+	}
+
+	switch {
+	case !cfg.LowLatency:
+		// 普通 HLS 每个文件就是一个完整切片
+		m.appendSegment(part, true)
+	case last == nil || last.complete:
+		// 切片必须从关键帧开始
+		if !independent {
+			os.Remove(filepath.Join(m.dir, name))
+			return
+		}
+		m.appendSegment(part, false)
+	case independent && last.duration() >= cfg.SegmentDuration.Seconds():
+		last.complete = true
+		m.appendSegment(part, false)
+	default:
+		last.parts = append(last.parts, part)
+	}
+
+	m.trim()
+
+	close(m.updated)
+	m.updated = make(chan struct{})
+}
+
+func (m *hlsMuxer) appendSegment(part *hlsPart, complete bool) {
+	m.segments = append(m.segments, &hlsSegment{
+		msn:      m.nextMSN,
+		parts:    []*hlsPart{part},
+		complete: complete,
+	})
+	m.nextMSN++
+}
+
+// trim 删除已移出播放列表较久的切片文件
+func (m *hlsMuxer) trim() {
+	keep := m.service.config.ListSize + hlsKeepSegments + 1
+	for len(m.segments) > keep {
+		for _, p := range m.segments[0].parts {
+			os.Remove(filepath.Join(m.dir, p.name))
+		}
+		m.segments = m.segments[1:]
+	}
+}
+
+// waitReady 等待第一个完整切片生成
+func (m *hlsMuxer) waitReady(ctx context.Context) error {
+	timeout := time.NewTimer(hlsStartTimeout)
+	defer timeout.Stop()
+
+	for {
+		m.mutex.Lock()
+		closed := m.closed
+		ready := len(m.segments) > 0 && m.segments[0].complete
+		updated := m.updated
+		m.mutex.Unlock()
+
+		if closed {
+			return utils.ErrStreamNotReady
+		}
+		if ready {
+			return nil
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return utils.ErrStreamNotReady
+		}
+	}
+}
+
+// waitFor 阻塞式刷新：等待指定切片（及分片）生成，超时后返回当前播放列表
+func (m *hlsMuxer) waitFor(ctx context.Context, msn, part int) {
+	timeout := time.NewTimer(3 * m.service.config.SegmentDuration)
+	defer timeout.Stop()
+
+	for {
+		m.mutex.Lock()
+		available := m.closed
+		for _, seg := range m.segments {
+			if seg.msn > msn ||
+				(seg.msn == msn && (seg.complete || (part >= 0 && len(seg.parts) > part))) {
+				available = true
+				break
+			}
+		}
+		updated := m.updated
+		m.mutex.Unlock()
+
+		if available {
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return
+		case <-timeout.C:
+			return
+		}
+	}
+}
+
+// playlist 生成播放列表，LL-HLS 模式下最近的切片同时列出分片
+func (m *hlsMuxer) playlist(query string) []byte {
+	cfg := m.service.config
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// 播放列表窗口：最近 ListSize 个完整切片及正在生成的切片
+	var segments []*hlsSegment
+	complete := 0
+	for i := len(m.segments) - 1; i >= 0; i-- {
+		seg := m.segments[i]
+		if seg.complete {
+			if complete == cfg.ListSize {
+				break
+			}
+			complete++
+		}
+		segments = append([]*hlsSegment{seg}, segments...)
+	}
+
+	targetDuration := math.Ceil(cfg.SegmentDuration.Seconds())
+	for _, seg := range segments {
+		if d := math.Ceil(seg.duration()); seg.complete && d > targetDuration {
+			targetDuration = d
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if cfg.LowLatency {
+		partTarget := cfg.PartDuration.Seconds()
+		b.WriteString("#EXT-X-VERSION:9\n")
+		fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(targetDuration))
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", partTarget*3)
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget)
+	} else {
+		b.WriteString("#EXT-X-VERSION:7\n")
+		fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(targetDuration))
+	}
+	if len(segments) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].msn)
+	}
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s%s\"\n", hlsInitFile, query)
+
+	for i, seg := range segments {
+		// 只为最后几个切片列出分片
+		if cfg.LowLatency && i >= len(segments)-3 {
+			for _, p := range seg.parts {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.5f,URI=\"%s%s\"", p.duration, p.name, query)
+				if p.independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+		if seg.complete {
+			fmt.Fprintf(&b, "#EXTINF:%.5f,\nseg%d.m4s%s\n", seg.duration(), seg.msn, query)
+		}
+	}
+
+	return []byte(b.String())
+}
+
+// file 读取初始化段、完整切片（由分片拼接）或单个分片
+func (m *hlsMuxer) file(name string) ([]byte, error) {
+	if name == hlsInitFile {
+		data, err := os.ReadFile(filepath.Join(m.dir, hlsInitFile))
+		if err != nil {
+			return nil, utils.ErrFileNotFound
+		}
+		return data, nil
+	}
+
+	var files []string
+	m.mutex.Lock()
+	if strings.HasPrefix(name, "seg") && strings.HasSuffix(name, ".m4s") {
+		msn, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "seg"), ".m4s"))
+		if err == nil {
+			for _, seg := range m.segments {
+				if seg.msn == msn && seg.complete {
+					for _, p := range seg.parts {
+						files = append(files, p.name)
+					}
+				}
+			}
+		}
+	} else {
+		for _, seg := range m.segments {
+			for _, p := range seg.parts {
+				if p.name == name {
+					files = append(files, p.name)
+				}
+			}
+		}
+	}
+	m.mutex.Unlock()
+
+	if len(files) == 0 {
+		return nil, utils.ErrFileNotFound
+	}
+
+	var data []byte
+	for _, f := range files {
+		chunk, err := os.ReadFile(filepath.Join(m.dir, f))
+		if err != nil {
+			return nil, utils.ErrFileNotFound
+		}
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
+
+func (m *hlsMuxer) touch() {
+	m.mutex.Lock()
+	m.lastAccess = time.Now()
+	m.mutex.Unlock()
+}
+
+func (m *hlsMuxer) idleSince() time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return time.Since(m.lastAccess)
+}
+
+func (m *hlsMuxer) isClosed() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.closed
+}
+
+// close 停止切片并删除临时文件
+func (m *hlsMuxer) close() {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.stop)
+	close(m.updated)
+	m.mutex.Unlock()
+
+	m.sub.Close()
+	m.forwarder.close()
+	if m.cmd.Process != nil {
+		m.cmd.Process.Kill()
+	}
+	<-m.exited
+	os.RemoveAll(m.dir)
+}
@@ -0,0 +1,493 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// LiveStreamURLs 车间的主/子码流地址
+type LiveStreamURLs struct {
+	Main string
+	Sub  string
+}
+
+// 连续多少个接收报告质量差时降级、质量好时升级
+const (
+	adaptiveDowngradeReports = 2
+	adaptiveUpgradeReports   = 10
+)
+
+// liveSession 一个浏览器的实时预览会话，可在主/子码流之间切换而无需重新协商。
+// 切换时等待新码流的关键帧，并改写 RTP 序号和时间戳保证浏览器端连续。
+type liveSession struct {
+	hub        *StreamHub
+	config     config.WebRTCConfig
+	workshopID uint
+	keyPrefix  string // 共享拉流的 key 前缀，默认为车间ID
+	urls       LiveStreamURLs
+	auto       bool
+
+	videoTrack *webrtc.TrackLocalStaticRTP
+	audioTrack *webrtc.TrackLocalStaticRTP
+	onEnded    func()
+
+	mutex          sync.Mutex
+	generation     uint64
+	active         *StreamSubscription
+	activeGen      uint64
+	activeQuality  string
+	pending        *StreamSubscription
+	pendingGen     uint64
+	pendingQuality string
+	videoRewriter  rtpRewriter
+	audioRewriter  rtpRewriter
+	closed         bool
+	done           chan struct{}
+
+	badReports  int
+	goodReports int
+}
+
+func newLiveSession(hub *StreamHub, cfg config.WebRTCConfig, workshopID uint, urls LiveStreamURLs, quality string) *liveSession {
+	if quality == "" {
+		quality = cfg.DefaultQuality
+	}
+	if cfg.DowngradeLoss <= 0 {
+		cfg.DowngradeLoss = 0.1
+	}
+	if cfg.UpgradeLoss <= 0 {
+		cfg.UpgradeLoss = 0.02
+	}
+
+	s := &liveSession{
+		hub:           hub,
+		config:        cfg,
+		workshopID:    workshopID,
+		keyPrefix:     strconv.FormatUint(uint64(workshopID), 10),
+		urls:          urls,
+		videoRewriter: rtpRewriter{clockRate: 90000},
+		done:          make(chan struct{}),
+	}
+
+	// 未配置子码流时始终使用主码流
+	switch {
+	case urls.Sub == "":
+		s.activeQuality = config.StreamQualityMain
+	case quality == config.StreamQualitySub:
+		s.activeQuality = config.StreamQualitySub
+	case quality == config.StreamQualityMain:
+		s.activeQuality = config.StreamQualityMain
+	default:
+		s.auto = true
+		s.activeQuality = config.StreamQualityMain
+	}
+	return s
+}
+
+func (s *liveSession) url(quality string) string {
+	if quality == config.StreamQualitySub {
+		return s.urls.Sub
+	}
+	return s.urls.Main
+}
+
+func (s *liveSession) subscribe(quality string, gen uint64) (*StreamSubscription, error) {
+	key := fmt.Sprintf("%s/%s", s.keyPrefix, quality)
+	return s.hub.Subscribe(key, s.url(quality), func(pkt *rtp.Packet) {
+		s.handleVideo(gen, pkt)
+	}, func(pkt *rtp.Packet) {
+		s.handleAudio(gen, pkt)
+	})
+}
+
+// start 订阅初始码流，返回订阅以便调用方确定音频编码
+func (s *liveSession) start() (*StreamSubscription, error) {
+	s.mutex.Lock()
+	s.generation++
+	gen := s.generation
+	s.activeGen = gen
+	quality := s.activeQuality
+	s.mutex.Unlock()
+
+	sub, err := s.subscribe(quality, gen)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	s.active = sub
+	s.mutex.Unlock()
+
+	go s.watch(sub, gen)
+	return sub, nil
+}
+
+// setTracks 在协商完成后设置输出轨道
+func (s *liveSession) setTracks(video, audio *webrtc.TrackLocalStaticRTP) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.videoTrack = video
+	s.audioTrack = audio
+	if audio != nil {
+		s.audioRewriter = rtpRewriter{clockRate: audio.Codec().ClockRate}
+	}
+}
+
+// switchQuality 切换到指定码流，新码流到达关键帧后才真正切换
+func (s *liveSession) switchQuality(quality string) {
+	if quality == config.StreamQualitySub && s.urls.Sub == "" {
+		return
+	}
+
+	s.mutex.Lock()
+	if s.closed || quality == s.pendingQuality || (s.pending == nil && quality == s.activeQuality) {
+		s.mutex.Unlock()
+		return
+	}
+	if s.pending != nil {
+		go s.pending.Close()
+		s.pending = nil
+	}
+	s.generation++
+	gen := s.generation
+	s.pendingGen = gen
+	s.pendingQuality = quality
+	s.mutex.Unlock()
+
+	sub, err := s.subscribe(quality, gen)
+	if err != nil {
+		fmt.Printf("Failed to switch workshop %d to %s stream: %v\n", s.workshopID, quality, err)
+		s.mutex.Lock()
+		if s.pendingGen == gen {
+			s.pendingQuality = ""
+		}
+		s.mutex.Unlock()
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed || s.pendingGen != gen {
+		go sub.Close()
+		return
+	}
+	s.pending = sub
+	go s.watch(sub, gen)
+}
+
+func (s *liveSession) handleVideo(gen uint64, pkt *rtp.Packet) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// 新码流的第一个关键帧到达时完成切换
+	if gen == s.pendingGen && s.pending != nil {
+		if !isH264Keyframe(pkt.Payload) {
+			return
+		}
+		old := s.active
+		s.active, s.activeGen, s.activeQuality = s.pending, s.pendingGen, s.pendingQuality
+		s.pending, s.pendingQuality = nil, ""
+		s.videoRewriter.resync()
+		s.audioRewriter.resync()
+		s.badReports, s.goodReports = 0, 0
+		if old != nil {
+			go old.Close()
+		}
+	}
+
+	if gen != s.activeGen || s.videoTrack == nil {
+		return
+	}
+	if err := s.videoTrack.WriteRTP(s.videoRewriter.rewrite(pkt)); err != nil {
+		fmt.Printf("Error writing RTP: %v\n", err)
+	}
+}
+
+func (s *liveSession) handleAudio(gen uint64, pkt *rtp.Packet) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if gen != s.activeGen || s.active == nil || s.audioTrack == nil {
+		return
+	}
+	// 切换后的码流音频编码与已协商的不一致时丢弃音频
+	if codec, ok := s.active.AudioCodec(); !ok || codec.MimeType != s.audioTrack.Codec().MimeType {
+		return
+	}
+	s.audioTrack.WriteRTP(s.audioRewriter.rewrite(pkt))
+}
+
+// watch 当前码流异常结束时重新拉流，浏览器在此期间停留在最后一帧，多次重试失败后结束会话
+func (s *liveSession) watch(sub *StreamSubscription, gen uint64) {
+	<-sub.Done()
+
+	s.mutex.Lock()
+	ended := !s.closed && gen == s.activeGen && s.active == sub
+	if !s.closed && gen == s.pendingGen && s.pending == sub {
+		s.pending, s.pendingQuality = nil, ""
+	}
+	s.mutex.Unlock()
+
+	if !ended {
+		return
+	}
+
+	for i := 0; i < streamRetryLimit; i++ {
+		select {
+		case <-s.done:
+			return
+		case <-time.After(streamRetryDelay):
+		}
+
+		err := s.restart()
+		if err == nil {
+			return
+		}
+		fmt.Printf("Failed to reconnect stream %s: %v\n", s.keyPrefix, err)
+	}
+
+	if s.onEnded != nil {
+		s.onEnded()
+	}
+}
+
+// restart 重新订阅当前码流
+func (s *liveSession) restart() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.generation++
+	gen := s.generation
+	s.activeGen = gen
+	quality := s.activeQuality
+	s.mutex.Unlock()
+
+	sub, err := s.subscribe(quality, gen)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	if s.closed || s.activeGen != gen {
+		s.mutex.Unlock()
+		sub.Close()
+		return nil
+	}
+	s.active = sub
+	s.videoRewriter.resync()
+	s.audioRewriter.resync()
+	s.mutex.Unlock()
+
+	go s.watch(sub, gen)
+	return nil
+}
+
+// readRTCP 读取浏览器的接收报告，自动模式下根据丢包率和带宽估计切换码流
+func (s *liveSession) readRTCP(sender *webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		if !s.auto {
+			continue
+		}
+
+		for _, p := range packets {
+			switch pkt := p.(type) {
+			case *rtcp.ReceiverReport:
+				for _, report := range pkt.Reports {
+					s.evaluate(float64(report.FractionLost)/256, 0)
+				}
+			case *rtcp.ReceiverEstimatedMaximumBitrate:
+				s.evaluate(-1, pkt.Bitrate)
+			}
+		}
+	}
+}
+
+// evaluate 根据一次报告更新链路质量统计，loss 为负表示本次报告不含丢包信息
+func (s *liveSession) evaluate(loss float64, bitrate float32) {
+	s.mutex.Lock()
+	bad := loss > s.config.DowngradeLoss ||
+		(bitrate > 0 && s.config.MinBitrate > 0 && bitrate < float32(s.config.MinBitrate*1000))
+	good := !bad && loss >= 0 && loss <= s.config.UpgradeLoss
+
+	switch {
+	case bad:
+		s.badReports++
+		s.goodReports = 0
+	case good:
+		s.goodReports++
+		s.badReports = 0
+	}
+
+	var target string
+	if s.pending == nil {
+		if s.activeQuality == config.StreamQualityMain && s.badReports >= adaptiveDowngradeReports {
+			target = config.StreamQualitySub
+		} else if s.activeQuality == config.StreamQualitySub && s.goodReports >= adaptiveUpgradeReports {
+			target = config.StreamQualityMain
+		}
+	}
+	s.mutex.Unlock()
+
+	if target != "" {
+		go s.switchQuality(target)
+	}
+}
+
+func (s *liveSession) close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	active, pending := s.active, s.pending
+	s.active, s.pending = nil, nil
+	s.mutex.Unlock()
+
+	if active != nil {
+		active.Close()
+	}
+	if pending != nil {
+		pending.Close()
+	}
+}
+
+// serveTags 通过数据通道定期推送车间关联的工业数据
+func (s *liveSession) serveTags(dc *webrtc.DataChannel, source TagSource, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	dc.OnOpen(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+			}
+
+			readings, err := source.Latest(s.workshopID)
+			if err != nil {
+				fmt.Printf("Failed to load tags for workshop %d: %v\n", s.workshopID, err)
+				continue
+			}
+
+			s.mutex.Lock()
+			now := time.Now()
+			rtpTimestamp := s.videoRewriter.timestampAt(now)
+			s.mutex.Unlock()
+
+			data, err := json.Marshal(models.TagUpdateMessage{
+				Type:         "tags",
+				ServerTime:   now,
+				RTPTimestamp: rtpTimestamp,
+				Readings:     readings,
+			})
+			if err != nil {
+				continue
+			}
+			if err := dc.SendText(string(data)); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// rtpRewriter 改写 RTP 序号和时间戳，使切换码流后的输出保持连续
+type rtpRewriter struct {
+	clockRate uint32
+	started   bool
+	needSync  bool
+	seqOffset uint16
+	tsOffset  uint32
+	lastSeq   uint16
+	lastTS    uint32
+	lastTime  time.Time
+}
+
+func (r *rtpRewriter) resync() {
+	r.needSync = true
+}
+
+func (r *rtpRewriter) rewrite(pkt *rtp.Packet) *rtp.Packet {
+	if r.started && r.needSync {
+		elapsed := uint32(time.Since(r.lastTime).Seconds() * float64(r.clockRate))
+		r.seqOffset = r.lastSeq + 1 - pkt.SequenceNumber
+		r.tsOffset = r.lastTS + elapsed - pkt.Timestamp
+	}
+	r.started = true
+	r.needSync = false
+
+	out := *pkt
+	out.SequenceNumber += r.seqOffset
+	out.Timestamp += r.tsOffset
+
+	r.lastSeq = out.SequenceNumber
+	r.lastTS = out.Timestamp
+	r.lastTime = time.Now()
+	return &out
+}
+
+// timestampAt 估算给定时刻对应的输出 RTP 时间戳
+func (r *rtpRewriter) timestampAt(t time.Time) uint32 {
+	if !r.started {
+		return 0
+	}
+	return r.lastTS + uint32(t.Sub(r.lastTime).Seconds()*float64(r.clockRate))
+}
+
+// isH264Keyframe 判断 RTP 负载是否为 IDR 帧或参数集的开始
+func isH264Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	isKey := func(nalType byte) bool {
+		return nalType == 5 || nalType == 7
+	}
+
+	nalType := payload[0] & 0x1f
+	switch nalType {
+	case 24: // STAP-A
+		for i := 1; i+2 < len(payload); {
+			size := int(payload[i])<<8 | int(payload[i+1])
+			i += 2
+			if i >= len(payload) {
+				break
+			}
+			if isKey(payload[i] & 0x1f) {
+				return true
+			}
+			i += size
+		}
+		return false
+	case 28: // FU-A
+		if len(payload) < 2 {
+			return false
+		}
+		start := payload[1]&0x80 != 0
+		return start && isKey(payload[1]&0x1f)
+	default:
+		return isKey(nalType)
+	}
+}
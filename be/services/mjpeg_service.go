@@ -0,0 +1,521 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"videodb/be/config"
+	"videodb/be/utils"
+)
+
+const mjpegSnapshotTimeout = 10 * time.Second
+
+// MJPEGService 为不支持 WebRTC/HLS 的简单客户端（工控机浏览器、大屏等）提供 MJPEG 画面。
+// 同一车间的所有客户端共用一个解码进程，拉流中断时客户端保持最后一帧并自动重试。
+type MJPEGService struct {
+	config          config.MJPEGConfig
+	ffmpegPath      string
+	dir             string
+	hub             *StreamHub
+	workshopService *WorkshopService
+
+	mutex     sync.Mutex
+	streams   map[uint]*mjpegStream
+	pending   map[uint]*pendingStart // 正在启动的解码
+	snapshots map[uint]mjpegSnapshot
+}
+
+// mjpegSnapshot 缓存的截图
+type mjpegSnapshot struct {
+	data []byte
+	at   time.Time
+}
+
+func NewMJPEGService(cfg *config.Config, hub *StreamHub, workshopService *WorkshopService) *MJPEGService {
+	mjpegConfig := cfg.MJPEG
+	if mjpegConfig.FPS <= 0 {
+		mjpegConfig.FPS = 5
+	}
+	if mjpegConfig.Quality < 2 || mjpegConfig.Quality > 31 {
+		mjpegConfig.Quality = 5
+	}
+	if mjpegConfig.SnapshotCache < 0 {
+		mjpegConfig.SnapshotCache = 0
+	}
+
+	return &MJPEGService{
+		config:          mjpegConfig,
+		ffmpegPath:      cfg.RTSP.FFmpegPath,
+		dir:             filepath.Join(cfg.Storage.TempPath, "mjpeg"),
+		hub:             hub,
+		workshopService: workshopService,
+		streams:         make(map[uint]*mjpegStream),
+		pending:         make(map[uint]*pendingStart),
+		snapshots:       make(map[uint]mjpegSnapshot),
+	}
+}
+
+// MaxFPS 返回 MJPEG 解码帧率，客户端请求的帧率不能超过该值
+func (s *MJPEGService) MaxFPS() int {
+	return s.config.FPS
+}
+
+// MJPEGSubscription 客户端对 MJPEG 画面的订阅
+type MJPEGSubscription struct {
+	id     uint64
+	stream *mjpegStream
+	frames chan []byte
+	done   chan struct{}
+}
+
+// Frames 返回 JPEG 帧，客户端处理不及时时丢弃旧帧
+func (sub *MJPEGSubscription) Frames() <-chan []byte {
+	return sub.frames
+}
+
+// Done 在画面彻底中断（重试失败）或取消订阅后关闭
+func (sub *MJPEGSubscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+func (sub *MJPEGSubscription) Close() {
+	sub.stream.removeClient(sub.id)
+}
+
+// Subscribe 订阅车间的 MJPEG 画面，必要时启动解码
+func (s *MJPEGService) Subscribe(workshopID uint) (*MJPEGSubscription, error) {
+	for {
+		s.mutex.Lock()
+		if stream, ok := s.streams[workshopID]; ok && !stream.isClosed() {
+			s.mutex.Unlock()
+			return stream.addClient(), nil
+		}
+		if p, ok := s.pending[workshopID]; ok {
+			s.mutex.Unlock()
+			if err := p.wait(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		p := newPendingStart()
+		s.pending[workshopID] = p
+		s.mutex.Unlock()
+
+		// 连接摄像头和启动 FFmpeg 期间不持有锁，避免阻塞其他车间的请求和截图缓存
+		stream, err := s.startStream(workshopID)
+
+		s.mutex.Lock()
+		delete(s.pending, workshopID)
+		if err == nil {
+			s.streams[workshopID] = stream
+		}
+		s.mutex.Unlock()
+		p.finish(err)
+
+		if err != nil {
+			return nil, err
+		}
+		return stream.addClient(), nil
+	}
+}
+
+// startStream 按车间配置的主码流启动解码
+func (s *MJPEGService) startStream(workshopID uint) (*mjpegStream, error) {
+	workshop, err := s.workshopService.GetByID(workshopID)
+	if err != nil {
+		return nil, err
+	}
+	urls, err := s.workshopService.LiveStreamURLs(workshop)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &mjpegStream{
+		service:    s,
+		workshopID: workshopID,
+		rtspURL:    urls.Main,
+		clients:    make(map[uint64]*MJPEGSubscription),
+		done:       make(chan struct{}),
+	}
+	if err := stream.start(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Snapshot 返回车间当前画面的一帧 JPEG。优先从共享拉流解码，
+// 共享拉流不可用（如摄像头编码无法转发）时直接用 FFmpeg 截图，结果缓存一小段时间
+func (s *MJPEGService) Snapshot(ctx context.Context, workshopID uint) ([]byte, error) {
+	if frame := s.cachedSnapshot(workshopID); frame != nil {
+		return frame, nil
+	}
+
+	frame, err := s.snapshotFromStream(ctx, workshopID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		frame, err = s.snapshotFromFFmpeg(ctx, workshopID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.config.SnapshotCache > 0 {
+		s.mutex.Lock()
+		s.snapshots[workshopID] = mjpegSnapshot{data: frame, at: time.Now()}
+		s.mutex.Unlock()
+	}
+	return frame, nil
+}
+
+func (s *MJPEGService) cachedSnapshot(workshopID uint) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// 已有 MJPEG 观看者时直接取最新一帧
+	if stream, ok := s.streams[workshopID]; ok {
+		if frame := stream.latestFrame(); frame != nil {
+			return frame
+		}
+	}
+	if snapshot, ok := s.snapshots[workshopID]; ok {
+		if time.Since(snapshot.at) < s.config.SnapshotCache {
+			return snapshot.data
+		}
+		delete(s.snapshots, workshopID)
+	}
+	return nil
+}
+
+func (s *MJPEGService) snapshotFromStream(ctx context.Context, workshopID uint) ([]byte, error) {
+	sub, err := s.Subscribe(workshopID)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Close()
+
+	if frame := sub.stream.latestFrame(); frame != nil {
+		return frame, nil
+	}
+
+	timeout := time.NewTimer(mjpegSnapshotTimeout)
+	defer timeout.Stop()
+
+	select {
+	case frame := <-sub.Frames():
+		return frame, nil
+	case <-sub.Done():
+		return nil, utils.ErrStreamNotReady
+	case <-timeout.C:
+		return nil, utils.ErrStreamNotReady
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *MJPEGService) snapshotFromFFmpeg(ctx context.Context, workshopID uint) ([]byte, error) {
+	workshop, err := s.workshopService.GetByID(workshopID)
+	if err != nil {
+		return nil, err
+	}
+	urls, err := s.workshopService.LiveStreamURLs(workshop)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mjpegSnapshotTimeout)
+	defer cancel()
+
+	frame, err := utils.NewFFmpeg(s.ffmpegPath).CaptureFrame(ctx, urls.Main, s.config.MaxWidth)
+	if err != nil || len(frame) == 0 {
+		return nil, utils.ErrStreamNotReady
+	}
+	return frame, nil
+}
+
+func (s *MJPEGService) removeStream(stream *mjpegStream) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.streams[stream.workshopID] == stream {
+		delete(s.streams, stream.workshopID)
+	}
+}
+
+// mjpegStream 一个车间的 MJPEG 解码，共享拉流的 RTP 包经本地 UDP 送给 FFmpeg 解码为 JPEG
+type mjpegStream struct {
+	service    *MJPEGService
+	workshopID uint
+	rtspURL    string
+
+	mutex     sync.Mutex
+	sub       *StreamSubscription
+	forwarder *rtpForwarder
+	cmd       *exec.Cmd
+	clients   map[uint64]*MJPEGSubscription
+	nextID    uint64
+	latest    []byte
+	idleTimer *time.Timer
+	closed    bool
+	done      chan struct{}
+}
+
+// start 订阅共享拉流并启动 FFmpeg 解码
+func (m *mjpegStream) start() error {
+	s := m.service
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create MJPEG directory: %v", err)
+	}
+
+	forwarder := &rtpForwarder{}
+	key := fmt.Sprintf("%d/%s", m.workshopID, config.StreamQualityMain)
+	sub, err := s.hub.Subscribe(key, m.rtspURL, forwarder.writeVideo, nil)
+	if err != nil {
+		return err
+	}
+
+	sdpPath := filepath.Join(s.dir, fmt.Sprintf("%d.sdp", m.workshopID))
+	video, _ := sub.RTSPTracks()
+	if err := forwarder.prepare(sdpPath, video, nil); err != nil {
+		sub.Close()
+		return err
+	}
+
+	filter := "fps=" + strconv.Itoa(s.config.FPS)
+	if s.config.MaxWidth > 0 {
+		filter += fmt.Sprintf(",scale=w='min(%d,iw)':h=-2", s.config.MaxWidth)
+	}
+
+	ffmpegPath := s.ffmpegPath
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	cmd := exec.Command(ffmpegPath,
+		"-loglevel", "error",
+		"-protocol_whitelist", "file,udp,rtp",
+		"-i", sdpPath,
+		"-map", "0:v:0",
+		"-vf", filter,
+		"-c:v", "mjpeg",
+		"-q:v", strconv.Itoa(s.config.Quality),
+		"-f", "mjpeg",
+		"pipe:1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		sub.Close()
+		return fmt.Errorf("failed to create MJPEG pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		sub.Close()
+		return fmt.Errorf("failed to start MJPEG decoder: %v", err)
+	}
+	if err := forwarder.connect(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		sub.Close()
+		return err
+	}
+
+	m.mutex.Lock()
+	m.sub, m.forwarder, m.cmd = sub, forwarder, cmd
+	m.mutex.Unlock()
+
+	exited := make(chan struct{})
+	go func() {
+		m.readFrames(stdout)
+		cmd.Wait()
+		close(exited)
+	}()
+	go m.watch(sub, exited)
+
+	return nil
+}
+
+// readFrames 从 FFmpeg 输出中按 SOI/EOI 标记切分 JPEG 帧
+func (m *mjpegStream) readFrames(r io.Reader) {
+	reader := bufio.NewReaderSize(r, 256*1024)
+	var frame bytes.Buffer
+	var prev byte
+
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+
+		if frame.Len() == 0 {
+			// 等待 SOI (FFD8)
+			if prev == 0xff && b == 0xd8 {
+				frame.Write([]byte{0xff, 0xd8})
+			}
+			prev = b
+			continue
+		}
+
+		frame.WriteByte(b)
+		if prev == 0xff && b == 0xd9 {
+			m.broadcast(append([]byte(nil), frame.Bytes()...))
+			frame.Reset()
+			b = 0
+		}
+		prev = b
+	}
+}
+
+func (m *mjpegStream) broadcast(frame []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.latest = frame
+	for _, client := range m.clients {
+		select {
+		case client.frames <- frame:
+		default:
+			// 丢弃未取走的旧帧，只保留最新一帧
+			select {
+			case <-client.frames:
+			default:
+			}
+			select {
+			case client.frames <- frame:
+			default:
+			}
+		}
+	}
+}
+
+// watch 拉流或解码中断时停止当前进程并重试，客户端在此期间保持最后一帧
+func (m *mjpegStream) watch(sub *StreamSubscription, exited chan struct{}) {
+	select {
+	case <-sub.Done():
+	case <-exited:
+	case <-m.done:
+	}
+	m.stopDecoder()
+
+	for i := 0; i < streamRetryLimit; i++ {
+		select {
+		case <-m.done:
+			return
+		case <-time.After(streamRetryDelay):
+		}
+
+		err := m.start()
+		if err == nil {
+			return
+		}
+		fmt.Printf("Failed to restart MJPEG stream for workshop %d: %v\n", m.workshopID, err)
+	}
+
+	m.close()
+}
+
+// stopDecoder 停止当前的订阅和 FFmpeg 进程
+func (m *mjpegStream) stopDecoder() {
+	m.mutex.Lock()
+	sub, forwarder, cmd := m.sub, m.forwarder, m.cmd
+	m.sub, m.forwarder, m.cmd = nil, nil, nil
+	m.mutex.Unlock()
+
+	if sub != nil {
+		sub.Close()
+	}
+	if forwarder != nil {
+		forwarder.close()
+	}
+	if cmd != nil && cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
+
+func (m *mjpegStream) latestFrame() []byte {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.latest
+}
+
+func (m *mjpegStream) addClient() *MJPEGSubscription {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.idleTimer != nil {
+		m.idleTimer.Stop()
+		m.idleTimer = nil
+	}
+
+	m.nextID++
+	sub := &MJPEGSubscription{
+		id:     m.nextID,
+		stream: m,
+		frames: make(chan []byte, 1),
+		done:   make(chan struct{}),
+	}
+	if m.closed {
+		close(sub.done)
+		return sub
+	}
+	m.clients[sub.id] = sub
+	return sub
+}
+
+func (m *mjpegStream) removeClient(id uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if sub, ok := m.clients[id]; ok {
+		close(sub.done)
+		delete(m.clients, id)
+	}
+	// 最后一个客户端离开后稍等再停止，方便定时抓图的客户端复用解码进程
+	if len(m.clients) == 0 && !m.closed && m.idleTimer == nil {
+		m.idleTimer = time.AfterFunc(streamIdleTimeout, m.closeIfIdle)
+	}
+}
+
+func (m *mjpegStream) closeIfIdle() {
+	m.mutex.Lock()
+	idle := len(m.clients) == 0
+	m.mutex.Unlock()
+
+	if idle {
+		m.close()
+	}
+}
+
+func (m *mjpegStream) isClosed() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.closed
+}
+
+func (m *mjpegStream) close() {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.done)
+	if m.idleTimer != nil {
+		m.idleTimer.Stop()
+		m.idleTimer = nil
+	}
+	for id, sub := range m.clients {
+		close(sub.done)
+		delete(m.clients, id)
+	}
+	m.mutex.Unlock()
+
+	m.service.removeStream(m)
+	m.stopDecoder()
+	os.Remove(filepath.Join(m.service.dir, fmt.Sprintf("%d.sdp", m.workshopID)))
+}
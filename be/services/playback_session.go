@@ -0,0 +1,361 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+	"videodb/be/models"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// 回放速率范围，非正常速度时不输出音频
+const (
+	playbackMinRate = 0.25
+	playbackMaxRate = 4
+
+	playbackStatusInterval = time.Second
+)
+
+// playbackSession 一个浏览器的录像回放会话。
+// 跳转、暂停和变速都通过重新启动回放源实现，RTP 序号和时间戳经改写后保持连续，无需重新协商。
+type playbackSession struct {
+	hub      *StreamHub
+	video    *models.Video
+	source   string // FFmpeg 读取的录像地址，本地路径或预签名 URL
+	hasAudio bool
+	keyBase  string
+
+	mutex         sync.Mutex
+	videoTrack    *webrtc.TrackLocalStaticRTP
+	audioTrack    *webrtc.TrackLocalStaticRTP
+	generation    uint64
+	active        *StreamSubscription
+	activeGen     uint64
+	videoRewriter rtpRewriter
+	audioRewriter rtpRewriter
+
+	// 当前回放源的起始位置，播放中的位置由视频 RTP 时间戳推算
+	offset   float64
+	rate     float64
+	firstTS  uint32
+	lastTS   uint32
+	gotVideo bool
+	position float64
+	paused   bool
+
+	dc     *webrtc.DataChannel
+	closed bool
+	done   chan struct{}
+}
+
+func newPlaybackSession(hub *StreamHub, video *models.Video, source string, hasAudio bool) *playbackSession {
+	return &playbackSession{
+		hub:           hub,
+		video:         video,
+		source:        source,
+		hasAudio:      hasAudio,
+		keyBase:       fmt.Sprintf("playback/%d/%d", video.ID, time.Now().UnixNano()),
+		videoRewriter: rtpRewriter{clockRate: 90000},
+		rate:          1,
+		paused:        true,
+		done:          make(chan struct{}),
+	}
+}
+
+// setTracks 在协商完成后设置输出轨道
+func (s *playbackSession) setTracks(video, audio *webrtc.TrackLocalStaticRTP) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.videoTrack = video
+	s.audioTrack = audio
+	if audio != nil {
+		s.audioRewriter = rtpRewriter{clockRate: audio.Codec().ClockRate}
+	}
+}
+
+// play 从指定位置开始回放，替换当前的回放源
+func (s *playbackSession) play(offset float64) error {
+	offset = s.clamp(offset)
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.generation++
+	gen := s.generation
+	rate := s.rate
+	s.mutex.Unlock()
+
+	opts := FileSourceOptions{
+		Offset: offset,
+		Rate:   rate,
+		Audio:  s.hasAudio && rate == 1,
+	}
+	key := fmt.Sprintf("%s/%d", s.keyBase, gen)
+	sub, err := s.hub.SubscribeFile(key, s.source, opts, func(pkt *rtp.Packet) {
+		s.handleVideo(gen, pkt)
+	}, func(pkt *rtp.Packet) {
+		s.handleAudio(gen, pkt)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	if s.closed || s.generation != gen {
+		s.mutex.Unlock()
+		sub.Close()
+		return nil
+	}
+	old := s.active
+	s.active, s.activeGen = sub, gen
+	s.offset, s.position = offset, offset
+	s.gotVideo = false
+	s.paused = false
+	s.videoRewriter.resync()
+	s.audioRewriter.resync()
+	s.mutex.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	go s.watch(sub, gen)
+	return nil
+}
+
+// pause 停止回放源并记住当前位置，浏览器保持最后一帧
+func (s *playbackSession) pause() {
+	s.mutex.Lock()
+	if s.paused {
+		s.mutex.Unlock()
+		return
+	}
+	s.position = s.currentPosition()
+	s.paused = true
+	s.generation++
+	old := s.active
+	s.active, s.activeGen = nil, 0
+	s.mutex.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+}
+
+// resume 从暂停的位置继续回放，已播放到结尾时从头开始
+func (s *playbackSession) resume() error {
+	s.mutex.Lock()
+	paused, position := s.paused, s.position
+	s.mutex.Unlock()
+
+	if !paused {
+		return nil
+	}
+	if s.video.Duration > 0 && position >= s.video.Duration {
+		position = 0
+	}
+	return s.play(position)
+}
+
+// seek 跳转到指定位置，暂停状态下只记录位置
+func (s *playbackSession) seek(position float64) error {
+	s.mutex.Lock()
+	if s.paused {
+		s.position = s.clamp(position)
+		s.mutex.Unlock()
+		return nil
+	}
+	s.mutex.Unlock()
+
+	return s.play(position)
+}
+
+// setRate 修改播放速率，播放中时从当前位置按新速率重新开始
+func (s *playbackSession) setRate(rate float64) error {
+	if rate < playbackMinRate || rate > playbackMaxRate {
+		return fmt.Errorf("rate must be between %g and %g", float64(playbackMinRate), float64(playbackMaxRate))
+	}
+
+	s.mutex.Lock()
+	if rate == s.rate {
+		s.mutex.Unlock()
+		return nil
+	}
+	position := s.currentPosition()
+	s.rate = rate
+	paused := s.paused
+	if paused {
+		s.position = position
+	}
+	s.mutex.Unlock()
+
+	if paused {
+		return nil
+	}
+	return s.play(position)
+}
+
+func (s *playbackSession) clamp(position float64) float64 {
+	if position < 0 || math.IsNaN(position) {
+		return 0
+	}
+	if s.video.Duration > 0 && position > s.video.Duration {
+		return s.video.Duration
+	}
+	return position
+}
+
+// currentPosition 返回当前回放位置，调用方需持有锁
+func (s *playbackSession) currentPosition() float64 {
+	if s.paused || !s.gotVideo {
+		return s.position
+	}
+	// 倍速时视频时间戳已按速率压缩，换算回录像中的位置
+	elapsed := float64(s.lastTS-s.firstTS) / 90000 * s.rate
+	return s.clamp(s.offset + elapsed)
+}
+
+func (s *playbackSession) handleVideo(gen uint64, pkt *rtp.Packet) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if gen != s.activeGen || s.videoTrack == nil {
+		return
+	}
+	if !s.gotVideo {
+		s.firstTS = pkt.Timestamp
+		s.gotVideo = true
+	}
+	s.lastTS = pkt.Timestamp
+
+	if err := s.videoTrack.WriteRTP(s.videoRewriter.rewrite(pkt)); err != nil {
+		fmt.Printf("Error writing RTP: %v\n", err)
+	}
+}
+
+func (s *playbackSession) handleAudio(gen uint64, pkt *rtp.Packet) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if gen != s.activeGen || s.audioTrack == nil {
+		return
+	}
+	s.audioTrack.WriteRTP(s.audioRewriter.rewrite(pkt))
+}
+
+// watch 回放源结束（播放到文件结尾或转码失败）时进入暂停状态并通知前端
+func (s *playbackSession) watch(sub *StreamSubscription, gen uint64) {
+	<-sub.Done()
+
+	s.mutex.Lock()
+	ended := !s.closed && gen == s.activeGen && s.active == sub
+	if ended {
+		s.position = s.currentPosition()
+		if s.video.Duration > 0 && s.video.Duration-s.position < 1 {
+			s.position = s.video.Duration
+		}
+		s.paused = true
+		s.active, s.activeGen = nil, 0
+	}
+	s.mutex.Unlock()
+
+	if ended {
+		s.sendStatus("ended", "")
+	}
+}
+
+// serveControl 处理前端 control 数据通道上的回放控制指令，并定期推送回放状态
+func (s *playbackSession) serveControl(dc *webrtc.DataChannel) {
+	s.mutex.Lock()
+	s.dc = dc
+	s.mutex.Unlock()
+
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var ctrl models.PlaybackControlMessage
+		if err := json.Unmarshal(msg.Data, &ctrl); err != nil {
+			s.sendStatus("error", "invalid control message")
+			return
+		}
+
+		var err error
+		switch ctrl.Type {
+		case "seek":
+			err = s.seek(ctrl.Position)
+		case "pause":
+			s.pause()
+		case "play":
+			err = s.resume()
+		case "rate":
+			err = s.setRate(ctrl.Rate)
+		case "status":
+		default:
+			err = fmt.Errorf("unknown control type: %s", ctrl.Type)
+		}
+
+		if err != nil {
+			s.sendStatus("error", err.Error())
+			return
+		}
+		s.sendStatus("status", "")
+	})
+
+	dc.OnOpen(func() {
+		ticker := time.NewTicker(playbackStatusInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+			}
+			s.sendStatus("status", "")
+		}
+	})
+}
+
+func (s *playbackSession) sendStatus(msgType string, message string) {
+	s.mutex.Lock()
+	dc := s.dc
+	status := models.PlaybackStatusMessage{
+		Type:     msgType,
+		Position: s.currentPosition(),
+		Duration: s.video.Duration,
+		Rate:     s.rate,
+		Paused:   s.paused,
+		Message:  message,
+	}
+	s.mutex.Unlock()
+
+	if dc == nil || dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	dc.SendText(string(data))
+}
+
+func (s *playbackSession) close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	active := s.active
+	s.active = nil
+	s.mutex.Unlock()
+
+	if active != nil {
+		active.Close()
+	}
+}
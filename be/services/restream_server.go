@@ -0,0 +1,367 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"videodb/be/config"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/auth"
+	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/pion/rtp"
+)
+
+// RestreamServer 内嵌 RTSP 服务，把共享拉流转发给 MES、分析盒子等下游系统。
+// 地址为 rtsp://<host>:8554/<车间ID>（主码流）和 rtsp://<host>:8554/<车间ID>/sub（子码流），
+// 读取账号按车间单独配置，未配置账号的车间不对外转发。
+type RestreamServer struct {
+	config          *config.Config
+	hub             *StreamHub
+	workshopService *WorkshopService
+	server          *gortsplib.Server
+
+	mutex      sync.Mutex
+	paths      map[string]*restreamPath
+	pending    map[string]*pendingStart // 正在创建的转发路径
+	sessions   map[*gortsplib.ServerSession]*restreamPath
+	validators map[uint]*restreamValidator
+}
+
+// restreamValidator 缓存车间的认证器，Digest 认证依赖同一个 nonce
+type restreamValidator struct {
+	user      string
+	pass      string
+	validator *auth.Validator
+}
+
+func NewRestreamServer(config *config.Config, hub *StreamHub, workshopService *WorkshopService) *RestreamServer {
+	return &RestreamServer{
+		config:          config,
+		hub:             hub,
+		workshopService: workshopService,
+		paths:           make(map[string]*restreamPath),
+		pending:         make(map[string]*pendingStart),
+		sessions:        make(map[*gortsplib.ServerSession]*restreamPath),
+		validators:      make(map[uint]*restreamValidator),
+	}
+}
+
+// Start 开始监听 RTSP 端口
+func (s *RestreamServer) Start() error {
+	address := s.config.Restream.Address
+	if address == "" {
+		address = ":8554"
+	}
+
+	s.server = &gortsplib.Server{
+		Handler:     s,
+		RTSPAddress: address,
+	}
+	if err := s.server.Start(); err != nil {
+		return fmt.Errorf("failed to start RTSP restream server: %v", err)
+	}
+	log.Printf("RTSP restream server listening on %s", address)
+	return nil
+}
+
+func (s *RestreamServer) Close() {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+// OnDescribe 校验读取账号并返回该路径的轨道信息
+func (s *RestreamServer) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	path, res := s.authorize(ctx.Path, ctx.Request)
+	if res != nil {
+		return res, nil, nil
+	}
+	return &base.Response{StatusCode: base.StatusOK}, path.stream, nil
+}
+
+// OnSetup 校验读取账号并把会话登记为该路径的观看者
+func (s *RestreamServer) OnSetup(ctx *gortsplib.ServerHandlerOnSetupCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	path, res := s.authorize(ctx.Path, ctx.Request)
+	if res != nil {
+		return res, nil, nil
+	}
+
+	s.mutex.Lock()
+	if _, ok := s.sessions[ctx.Session]; !ok {
+		s.sessions[ctx.Session] = path
+		path.addReader()
+	}
+	s.mutex.Unlock()
+
+	return &base.Response{StatusCode: base.StatusOK}, path.stream, nil
+}
+
+func (s *RestreamServer) OnPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+func (s *RestreamServer) OnSessionClose(ctx *gortsplib.ServerHandlerOnSessionCloseCtx) {
+	s.mutex.Lock()
+	path, ok := s.sessions[ctx.Session]
+	delete(s.sessions, ctx.Session)
+	s.mutex.Unlock()
+
+	if ok {
+		path.removeReader()
+	}
+}
+
+// authorize 解析路径并校验车间的读取账号，失败时返回应答
+func (s *RestreamServer) authorize(pathName string, req *base.Request) (*restreamPath, *base.Response) {
+	workshopID, quality, ok := parseRestreamPath(pathName)
+	if !ok {
+		return nil, &base.Response{StatusCode: base.StatusNotFound}
+	}
+
+	workshop, err := s.workshopService.GetByID(workshopID)
+	if err != nil || workshop.RestreamUser == "" {
+		return nil, &base.Response{StatusCode: base.StatusNotFound}
+	}
+
+	validator := s.validator(workshopID, workshop.RestreamUser, workshop.RestreamPass)
+	if err := validator.ValidateRequest(req); err != nil {
+		return nil, &base.Response{
+			StatusCode: base.StatusUnauthorized,
+			Header: base.Header{
+				"WWW-Authenticate": validator.Header(),
+			},
+		}
+	}
+
+	urls, err := s.workshopService.LiveStreamURLs(workshop)
+	if err != nil {
+		return nil, &base.Response{StatusCode: base.StatusInternalServerError}
+	}
+	rtspURL := urls.Main
+	if quality == config.StreamQualitySub {
+		rtspURL = urls.Sub
+	}
+	if rtspURL == "" {
+		return nil, &base.Response{StatusCode: base.StatusNotFound}
+	}
+
+	path, err := s.path(workshopID, quality, rtspURL)
+	if err != nil {
+		log.Printf("Failed to restream workshop %d: %v", workshopID, err)
+		return nil, &base.Response{StatusCode: base.StatusBadGateway}
+	}
+	return path, nil
+}
+
+func (s *RestreamServer) validator(workshopID uint, user, pass string) *auth.Validator {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, ok := s.validators[workshopID]
+	if !ok || v.user != user || v.pass != pass {
+		v = &restreamValidator{
+			user:      user,
+			pass:      pass,
+			validator: auth.NewValidator(user, pass, nil),
+		}
+		s.validators[workshopID] = v
+	}
+	return v.validator
+}
+
+// path 获取或创建转发路径，同一车间同一码流的所有下游共用一个订阅
+func (s *RestreamServer) path(workshopID uint, quality string, rtspURL string) (*restreamPath, error) {
+	key := fmt.Sprintf("%d/%s", workshopID, quality)
+
+	for {
+		s.mutex.Lock()
+		if path, ok := s.paths[key]; ok {
+			s.mutex.Unlock()
+			return path, nil
+		}
+		if p, ok := s.pending[key]; ok {
+			s.mutex.Unlock()
+			if err := p.wait(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		p := newPendingStart()
+		s.pending[key] = p
+		s.mutex.Unlock()
+
+		// 订阅时可能需要连接摄像头，不持有锁，避免阻塞其他路径和会话
+		path, err := s.startPath(key, rtspURL)
+
+		s.mutex.Lock()
+		delete(s.pending, key)
+		if err == nil {
+			s.paths[key] = path
+		}
+		s.mutex.Unlock()
+		p.finish(err)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// 登记后再开始监视，避免拉流立即结束时已关闭的路径留在索引中
+		path.mutex.Lock()
+		// 没有观看者的路径（如只发送了 DESCRIBE）在空闲超时后释放
+		path.idleTimer = time.AfterFunc(streamIdleTimeout, path.closeIfIdle)
+		path.mutex.Unlock()
+		go path.watch()
+		return path, nil
+	}
+}
+
+// startPath 订阅共享拉流并创建转发用的 ServerStream
+func (s *RestreamServer) startPath(key string, rtspURL string) (*restreamPath, error) {
+	path := &restreamPath{server: s, key: key}
+	sub, err := s.hub.Subscribe(key, rtspURL, path.writeVideo, path.writeAudio)
+	if err != nil {
+		return nil, err
+	}
+
+	videoTrack, audioTrack := sub.RTSPTracks()
+	tracks := gortsplib.Tracks{videoTrack}
+	audioTrackID := -1
+	if audioTrack != nil {
+		audioTrackID = len(tracks)
+		tracks = append(tracks, audioTrack)
+	}
+
+	path.mutex.Lock()
+	path.audioTrackID = audioTrackID
+	path.sub = sub
+	path.stream = gortsplib.NewServerStream(tracks)
+	path.mutex.Unlock()
+
+	return path, nil
+}
+
+func (s *RestreamServer) removePath(path *restreamPath) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.paths[path.key] == path {
+		delete(s.paths, path.key)
+	}
+}
+
+// parseRestreamPath 解析 <车间ID> 或 <车间ID>/sub 形式的路径
+func parseRestreamPath(path string) (uint, string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) > 2 {
+		return 0, "", false
+	}
+
+	id, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || id == 0 {
+		return 0, "", false
+	}
+
+	quality := config.StreamQualityMain
+	if len(parts) == 2 {
+		if parts[1] != config.StreamQualitySub {
+			return 0, "", false
+		}
+		quality = config.StreamQualitySub
+	}
+	return uint(id), quality, true
+}
+
+// restreamPath 一路对外转发的码流
+type restreamPath struct {
+	server *RestreamServer
+	key    string
+
+	mutex        sync.Mutex
+	audioTrackID int
+	sub          *StreamSubscription
+	stream       *gortsplib.ServerStream
+	readers      int
+	idleTimer    *time.Timer
+	closed       bool
+}
+
+func (p *restreamPath) writeVideo(pkt *rtp.Packet) {
+	p.mutex.Lock()
+	stream := p.stream
+	p.mutex.Unlock()
+
+	if stream != nil {
+		stream.WritePacketRTP(0, pkt)
+	}
+}
+
+func (p *restreamPath) writeAudio(pkt *rtp.Packet) {
+	p.mutex.Lock()
+	stream, trackID := p.stream, p.audioTrackID
+	p.mutex.Unlock()
+
+	if stream != nil && trackID >= 0 {
+		stream.WritePacketRTP(trackID, pkt)
+	}
+}
+
+func (p *restreamPath) addReader() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.readers++
+	if p.idleTimer != nil {
+		p.idleTimer.Stop()
+		p.idleTimer = nil
+	}
+}
+
+func (p *restreamPath) removeReader() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.readers--
+	if p.readers <= 0 && !p.closed && p.idleTimer == nil {
+		p.idleTimer = time.AfterFunc(streamIdleTimeout, p.closeIfIdle)
+	}
+}
+
+func (p *restreamPath) closeIfIdle() {
+	p.mutex.Lock()
+	idle := p.readers <= 0
+	p.mutex.Unlock()
+
+	if idle {
+		p.close()
+	}
+}
+
+// watch 拉流结束时断开所有下游
+func (p *restreamPath) watch() {
+	<-p.sub.Done()
+	p.close()
+}
+
+func (p *restreamPath) close() {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	p.closed = true
+	if p.idleTimer != nil {
+		p.idleTimer.Stop()
+		p.idleTimer = nil
+	}
+	sub, stream := p.sub, p.stream
+	p.stream = nil
+	p.mutex.Unlock()
+
+	p.server.removePath(p)
+	sub.Close()
+	stream.Close()
+}
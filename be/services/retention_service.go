@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+
+	"gorm.io/gorm"
+)
+
+// 每次查询的候选录像数
+const retentionBatch = 200
+
+// 清理原因
+const (
+	RetentionReasonAge       = "age"       // 超过保留天数
+	RetentionReasonSize      = "size"      // 车间录像超过容量上限
+	RetentionReasonWatermark = "watermark" // 磁盘使用率超过高水位
+	RetentionReasonTrash     = "trash"     // 在回收站中超过保留天数
+)
+
+// RetentionService 按保留策略清理录像：
+//  1. 超过车间保留天数（未设置时使用全局默认）的录像；
+//  2. 车间录像总大小超过容量上限时，从最早的录像开始删除；
+//  3. 在回收站中超过保留天数的录像；
+//  4. 本地磁盘使用率超过高水位时，先清空回收站，再从最早的录像开始删除，直到低于低水位。
+//
+// 保全期内的录像不会被自动删除，但计入车间容量。
+type RetentionService struct {
+	db            *gorm.DB
+	storage       *VideoStorage
+	videoPath     string
+	defaultDays   int
+	trashDays     int
+	highWatermark float64
+	lowWatermark  float64
+	running       int32
+}
+
+// RetentionItem 将被删除的录像
+type RetentionItem struct {
+	VideoID    uint      `json:"videoId"`
+	WorkshopID uint      `json:"workshopId"`
+	FileName   string    `json:"fileName"`
+	FileSize   int64     `json:"fileSize"`
+	Storage    string    `json:"storage"`
+	StorageID  uint      `json:"storageId"`
+	StartTime  time.Time `json:"startTime"`
+	Reason     string    `json:"reason"`
+}
+
+// RetentionDisk 本地存储所在磁盘的使用情况
+type RetentionDisk struct {
+	StorageID   uint    `json:"storageId"` // 0 表示配置文件中的本地存储
+	Path        string  `json:"path"`
+	Total       uint64  `json:"total"`
+	Used        uint64  `json:"used"`
+	UsedPercent float64 `json:"usedPercent"`
+	Purge       bool    `json:"purge"` // 是否超过高水位需要紧急清理
+	Error       string  `json:"error,omitempty"`
+}
+
+// RetentionPlan 一次清理的计划，预览时只返回不执行
+type RetentionPlan struct {
+	Items     []RetentionItem `json:"items"`
+	TotalSize int64           `json:"totalSize"`
+	Disks     []RetentionDisk `json:"disks"`
+}
+
+func NewRetentionService(cfg *config.Config, db *gorm.DB, storage *VideoStorage) *RetentionService {
+	retention := cfg.Storage.Retention
+	high, low := retention.HighWatermark, retention.LowWatermark
+	if low <= 0 || low > high {
+		low = high
+	}
+
+	return &RetentionService{
+		db:            db,
+		storage:       storage,
+		videoPath:     cfg.Storage.VideoPath,
+		defaultDays:   retention.DefaultDays,
+		trashDays:     cfg.Storage.TrashDays,
+		highWatermark: high,
+		lowWatermark:  low,
+	}
+}
+
+// Preview 返回当前会被删除的录像，不执行删除
+func (s *RetentionService) Preview(ctx context.Context) (*RetentionPlan, error) {
+	return s.plan(ctx)
+}
+
+// Run 执行一次清理，已在执行时直接返回 false
+func (s *RetentionService) Run(ctx context.Context) (*RetentionPlan, bool, error) {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		return nil, false, nil
+	}
+	defer atomic.StoreInt32(&s.running, 0)
+
+	plan, err := s.plan(ctx)
+	if err != nil {
+		return nil, true, err
+	}
+
+	deleted := plan.Items[:0]
+	var totalSize int64
+	for _, item := range plan.Items {
+		if ctx.Err() != nil {
+			break
+		}
+		// 单个录像失败不影响其他录像，下次执行时重试
+		ok, err := s.delete(ctx, item.VideoID)
+		if err != nil {
+			fmt.Printf("Failed to delete video %d by retention: %v\n", item.VideoID, err)
+			continue
+		}
+		if ok {
+			deleted = append(deleted, item)
+			totalSize += item.FileSize
+		}
+	}
+	plan.Items = deleted
+	plan.TotalSize = totalSize
+
+	if len(deleted) > 0 {
+		fmt.Printf("Retention deleted %d videos, %d bytes\n", len(deleted), totalSize)
+	}
+	return plan, true, nil
+}
+
+// delete 彻底删除未保全的录像，录像已被删除或期间被保全时返回 false
+func (s *RetentionService) delete(ctx context.Context, id uint) (bool, error) {
+	var video models.Video
+	err := s.db.Where("status IN ?", []int{config.VideoStatusNormal, config.VideoStatusDeleted, config.VideoStatusMissing}).First(&video, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = purgeVideo(ctx, s.db, s.storage, &video, unheldVideos(time.Now()))
+	if errors.Is(err, errVideoChanged) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// plan 依次按保留天数、车间容量和磁盘水位挑选要删除的录像
+func (s *RetentionService) plan(ctx context.Context) (*RetentionPlan, error) {
+	p := &retentionPlanner{
+		ctx:      ctx,
+		plan:     &RetentionPlan{Items: []RetentionItem{}, Disks: []RetentionDisk{}},
+		selected: make(map[uint]bool),
+	}
+
+	var workshops []models.Workshop
+	if err := s.db.Select("id", "retention_days", "retention_max_gb").Find(&workshops).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workshops: %v", err)
+	}
+
+	for _, workshop := range workshops {
+		days := workshop.RetentionDays
+		if days == 0 {
+			days = s.defaultDays
+		}
+		if days <= 0 {
+			continue
+		}
+		// 导入和上传的录像开始时间可能很早，按录制时间和入库时间中较晚的计算保留天数；
+		// 外部文件只是引用，不按时间清理
+		cutoff := time.Now().AddDate(0, 0, -days)
+		query := s.db.Where("workshop_id = ? AND status IN ? AND external = ? AND start_time < ? AND created_at < ?",
+			workshop.ID, listedVideoStatuses, false, cutoff, cutoff)
+		if _, err := p.selectOldest(query, RetentionReasonAge, -1); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, workshop := range workshops {
+		if workshop.RetentionMaxGB <= 0 {
+			continue
+		}
+		var total int64
+		// 外部文件不占用平台的存储，不计入容量上限
+		err := s.db.Model(&models.Video{}).Where("workshop_id = ? AND status = ? AND external = ?", workshop.ID, config.VideoStatusNormal, false).
+			Select("COALESCE(SUM(file_size), 0)").Scan(&total).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to sum video size: %v", err)
+		}
+		over := total - p.freedInWorkshop(workshop.ID) - int64(workshop.RetentionMaxGB)<<30
+		if over <= 0 {
+			continue
+		}
+		query := s.db.Where("workshop_id = ? AND status = ? AND external = ?", workshop.ID, config.VideoStatusNormal, false)
+		if _, err := p.selectOldest(query, RetentionReasonSize, over); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.trashDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -s.trashDays)
+		query := s.db.Where("status = ? AND trashed_at < ?", config.VideoStatusDeleted, cutoff)
+		if _, err := p.selectOldest(query, RetentionReasonTrash, -1); err != nil {
+			return nil, err
+		}
+	}
+
+	disks, err := s.localDisks()
+	if err != nil {
+		return nil, err
+	}
+	for _, disk := range disks {
+		if disk.Error == "" && s.highWatermark > 0 && disk.UsedPercent >= s.highWatermark {
+			disk.Purge = true
+			target := uint64(float64(disk.Total) * s.lowWatermark / 100)
+			need := int64(disk.Used) - int64(target) - p.freedInLocalStorage(disk.StorageID)
+			// 先清除回收站中的录像
+			for _, status := range []int{config.VideoStatusDeleted, config.VideoStatusNormal} {
+				if need <= 0 {
+					break
+				}
+				query := s.localVideos(disk.StorageID).Where("status = ? AND external = ?", status, false)
+				if need, err = p.selectOldest(query, RetentionReasonWatermark, need); err != nil {
+					return nil, err
+				}
+			}
+		}
+		p.plan.Disks = append(p.plan.Disks, disk)
+	}
+
+	return p.plan, nil
+}
+
+// localDisks 返回所有本地存储所在磁盘的使用情况
+func (s *RetentionService) localDisks() ([]RetentionDisk, error) {
+	disks := []RetentionDisk{{Path: s.videoPath}}
+
+	var storages []models.Storage
+	if err := s.db.Where("type = ?", config.StorageTypeLocal).Order("id").Find(&storages).Error; err != nil {
+		return nil, fmt.Errorf("failed to get storages: %v", err)
+	}
+	for _, storage := range storages {
+		var cfg models.LocalConfig
+		if err := json.Unmarshal([]byte(storage.Config), &cfg); err != nil || cfg.BasePath == "" {
+			continue
+		}
+		disks = append(disks, RetentionDisk{StorageID: storage.ID, Path: cfg.BasePath})
+	}
+
+	for i := range disks {
+		total, used, err := utils.DiskUsage(disks[i].Path)
+		if err != nil {
+			disks[i].Error = err.Error()
+			continue
+		}
+		disks[i].Total = total
+		disks[i].Used = used
+		if total > 0 {
+			disks[i].UsedPercent = float64(used) * 100 / float64(total)
+		}
+	}
+	return disks, nil
+}
+
+// localVideos 查询保存在指定本地存储中的录像，0 表示配置文件中的本地存储（含未记录存储类型的旧录像）
+func (s *RetentionService) localVideos(storageID uint) *gorm.DB {
+	if storageID != 0 {
+		return s.db.Where("storage_id = ?", storageID)
+	}
+	return s.db.Where("storage_id = 0 AND (storage = ? OR storage = '' OR storage IS NULL)", config.StorageTypeLocal)
+}
+
+// retentionPlanner 记录已选中的录像，避免同一录像因多个原因重复计入
+type retentionPlanner struct {
+	ctx      context.Context
+	plan     *RetentionPlan
+	selected map[uint]bool
+}
+
+// selectOldest 按开始时间从早到晚选中符合条件的未保全录像，
+// need 大于 0 时选够 need 字节为止并返回仍需的字节数，小于 0 时全部选中
+func (p *retentionPlanner) selectOldest(query *gorm.DB, reason string, need int64) (int64, error) {
+	var lastStart time.Time
+	var lastID uint
+	for {
+		if err := p.ctx.Err(); err != nil {
+			return need, err
+		}
+
+		var videos []models.Video
+		err := query.Session(&gorm.Session{}).
+			Select("id", "workshop_id", "file_name", "file_size", "storage", "storage_id", "start_time").
+			Scopes(unheldVideos(time.Now())).
+			Where("start_time > ? OR (start_time = ? AND id > ?)", lastStart, lastStart, lastID).
+			Order("start_time, id").Limit(retentionBatch).Find(&videos).Error
+		if err != nil {
+			return need, fmt.Errorf("failed to get videos: %v", err)
+		}
+		if len(videos) == 0 {
+			return need, nil
+		}
+
+		for _, video := range videos {
+			lastStart, lastID = video.StartTime, video.ID
+			if p.selected[video.ID] {
+				continue
+			}
+			p.selected[video.ID] = true
+			p.plan.Items = append(p.plan.Items, RetentionItem{
+				VideoID:    video.ID,
+				WorkshopID: video.WorkshopID,
+				FileName:   video.FileName,
+				FileSize:   video.FileSize,
+				Storage:    video.Storage,
+				StorageID:  video.StorageID,
+				StartTime:  video.StartTime,
+				Reason:     reason,
+			})
+			p.plan.TotalSize += video.FileSize
+
+			if need > 0 {
+				need -= video.FileSize
+				if need <= 0 {
+					return need, nil
+				}
+			}
+		}
+	}
+}
+
+// freedInWorkshop 已选中录像中属于指定车间的总大小
+func (p *retentionPlanner) freedInWorkshop(workshopID uint) int64 {
+	var size int64
+	for _, item := range p.plan.Items {
+		if item.WorkshopID == workshopID {
+			size += item.FileSize
+		}
+	}
+	return size
+}
+
+// freedInLocalStorage 已选中录像中保存在指定本地存储的总大小
+func (p *retentionPlanner) freedInLocalStorage(storageID uint) int64 {
+	var size int64
+	for _, item := range p.plan.Items {
+		if item.StorageID != storageID {
+			continue
+		}
+		if storageID == 0 && item.Storage != "" && item.Storage != config.StorageTypeLocal {
+			continue
+		}
+		size += item.FileSize
+	}
+	return size
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/aler9/gortsplib"
+	"github.com/pion/rtp"
+	psdp "github.com/pion/sdp/v3"
+)
+
+// rtpForwarder 把共享拉流的 RTP 包经本地 UDP 转发给 FFmpeg，FFmpeg 通过 SDP 文件读取。
+// 订阅时先把 writeVideo/writeAudio 作为回调传入，拿到轨道信息后再 prepare，FFmpeg 启动后 connect。
+type rtpForwarder struct {
+	videoPort int
+	audioPort int
+	hasAudio  bool
+
+	mutex     sync.RWMutex
+	videoConn *net.UDPConn
+	audioConn *net.UDPConn
+}
+
+// prepare 分配本地端口并生成 SDP 文件，audio 为 nil 时只转发视频
+func (f *rtpForwarder) prepare(sdpPath string, video, audio gortsplib.Track) error {
+	sdp := &psdp.SessionDescription{
+		SessionName: psdp.SessionName("IDIP"),
+		Origin: psdp.Origin{
+			Username:       "-",
+			NetworkType:    "IN",
+			AddressType:    "IP4",
+			UnicastAddress: "127.0.0.1",
+		},
+		ConnectionInformation: &psdp.ConnectionInformation{
+			NetworkType: "IN",
+			AddressType: "IP4",
+			Address:     &psdp.Address{Address: "127.0.0.1"},
+		},
+		TimeDescriptions: []psdp.TimeDescription{{}},
+	}
+
+	var err error
+	if f.videoPort, err = freeRTPPort(); err != nil {
+		return err
+	}
+	videoMedia := video.MediaDescription()
+	videoMedia.MediaName.Port = psdp.RangedPort{Value: f.videoPort}
+	sdp.MediaDescriptions = append(sdp.MediaDescriptions, videoMedia)
+
+	if audio != nil {
+		if f.audioPort, err = freeRTPPort(); err != nil {
+			return err
+		}
+		audioMedia := audio.MediaDescription()
+		audioMedia.MediaName.Port = psdp.RangedPort{Value: f.audioPort}
+		sdp.MediaDescriptions = append(sdp.MediaDescriptions, audioMedia)
+		f.hasAudio = true
+	}
+
+	data, err := sdp.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to build SDP: %v", err)
+	}
+	if err := os.WriteFile(sdpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write SDP: %v", err)
+	}
+	return nil
+}
+
+// connect 在 FFmpeg 启动后开始转发
+func (f *rtpForwarder) connect() error {
+	loopback := net.IPv4(127, 0, 0, 1)
+	videoConn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: loopback, Port: f.videoPort})
+	if err != nil {
+		return fmt.Errorf("failed to connect to FFmpeg: %v", err)
+	}
+	var audioConn *net.UDPConn
+	if f.hasAudio {
+		audioConn, err = net.DialUDP("udp", nil, &net.UDPAddr{IP: loopback, Port: f.audioPort})
+		if err != nil {
+			videoConn.Close()
+			return fmt.Errorf("failed to connect to FFmpeg: %v", err)
+		}
+	}
+
+	f.mutex.Lock()
+	f.videoConn, f.audioConn = videoConn, audioConn
+	f.mutex.Unlock()
+	return nil
+}
+
+func (f *rtpForwarder) writeVideo(pkt *rtp.Packet) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if f.videoConn == nil {
+		return
+	}
+	if buf, err := pkt.Marshal(); err == nil {
+		f.videoConn.Write(buf)
+	}
+}
+
+func (f *rtpForwarder) writeAudio(pkt *rtp.Packet) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if f.audioConn == nil {
+		return
+	}
+	if buf, err := pkt.Marshal(); err == nil {
+		f.audioConn.Write(buf)
+	}
+}
+
+func (f *rtpForwarder) close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.videoConn != nil {
+		f.videoConn.Close()
+		f.videoConn = nil
+	}
+	if f.audioConn != nil {
+		f.audioConn.Close()
+		f.audioConn = nil
+	}
+}
+
+// freeRTPPort 查找一对可用的本地 UDP 端口（RTP 偶数端口及其后的 RTCP 端口）
+func freeRTPPort() (int, error) {
+	loopback := net.IPv4(127, 0, 0, 1)
+	for i := 0; i < 20; i++ {
+		rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: loopback, Port: 0})
+		if err != nil {
+			return 0, fmt.Errorf("failed to allocate RTP port: %v", err)
+		}
+		port := rtpConn.LocalAddr().(*net.UDPAddr).Port
+		if port%2 != 0 {
+			rtpConn.Close()
+			continue
+		}
+		rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: loopback, Port: port + 1})
+		rtpConn.Close()
+		if err != nil {
+			continue
+		}
+		rtcpConn.Close()
+		return port, nil
+	}
+	return 0, fmt.Errorf("failed to allocate RTP port")
+}
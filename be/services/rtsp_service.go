@@ -5,23 +5,40 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+
+	"gorm.io/gorm"
 )
 
 type RTSPService struct {
+	db          *gorm.DB
+	storage     *VideoStorage
+	tempPath    string
+	ffprobePath string
+
 	recordings     map[uint]*exec.Cmd
 	recordingMutex sync.Mutex
 }
 
-func NewRTSPService() *RTSPService {
+func NewRTSPService(cfg *config.Config, db *gorm.DB, storage *VideoStorage) *RTSPService {
 	return &RTSPService{
-		recordings: make(map[uint]*exec.Cmd),
+		db:          db,
+		storage:     storage,
+		tempPath:    filepath.Join(cfg.Storage.TempPath, "recordings"),
+		ffprobePath: cfg.RTSP.FFprobePath,
+		recordings:  make(map[uint]*exec.Cmd),
 	}
 }
 
-// 开始录制
-func (s *RTSPService) StartRecording(ctx context.Context, rtspURL string, outputPath string, workshopID uint) error {
+// 开始录制，先写入临时目录，停止录制后存入车间的录像存储并创建录像记录
+func (s *RTSPService) StartRecording(ctx context.Context, rtspURL string, workshopID uint, recordAudio bool) error {
 	s.recordingMutex.Lock()
 	defer s.recordingMutex.Unlock()
 
@@ -30,12 +47,15 @@ func (s *RTSPService) StartRecording(ctx context.Context, rtspURL string, output
 		return fmt.Errorf("workshop %d is already recording", workshopID)
 	}
 
+	outputDir := filepath.Join(s.tempPath, strconv.FormatUint(uint64(workshopID), 10))
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create recording directory: %v", err)
+	}
+	startTime := time.Now()
+	outputFile := filepath.Join(outputDir, fmt.Sprintf("%d_%s.mp4", workshopID, startTime.Format("20060102150405")))
+
 	// 使用FFmpeg录制RTSP流
-	cmd := exec.CommandContext(ctx, "ffmpeg",
-		"-i", rtspURL,
-		"-c", "copy",
-		"-f", "mp4",
-		outputPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg", recordingArgs(rtspURL, outputFile, recordAudio)...)
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start recording: %v", err)
@@ -54,11 +74,84 @@ func (s *RTSPService) StartRecording(ctx context.Context, rtspURL string, output
 		if err := cmd.Wait(); err != nil {
 			fmt.Printf("Recording for workshop %d ended with error: %v\n", workshopID, err)
 		}
+		// 保存完成前仍视为录制中，避免同一秒内重新开始录制覆盖临时文件
+		if err := s.saveRecording(workshopID, outputFile, startTime); err != nil {
+			fmt.Printf("Failed to save recording for workshop %d: %v\n", workshopID, err)
+		}
 	}()
 
 	return nil
 }
 
+// saveRecording 把录制完成的文件存入车间的录像存储并创建录像记录，与定时采集相同
+func (s *RTSPService) saveRecording(workshopID uint, outputFile string, startTime time.Time) error {
+	fileInfo, err := os.Stat(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to get recording file: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		os.Remove(outputFile)
+		return fmt.Errorf("recording file is empty")
+	}
+	endTime := time.Now()
+
+	// 读取实际录制的时长和编码信息，读取失败时按录制时间记录，之后由补全任务重新读取
+	media, err := utils.NewFFprobe(s.ffprobePath).Probe(context.Background(), outputFile)
+	if err != nil {
+		fmt.Printf("Failed to probe recording %s: %v\n", outputFile, err)
+	}
+
+	target, err := s.storage.Target(workshopID)
+	if err != nil {
+		os.Remove(outputFile)
+		return err
+	}
+	fileName := filepath.Base(outputFile)
+	key := path.Join("recordings", strconv.FormatUint(uint64(workshopID), 10), fileName)
+	if err := target.Driver.Put(context.Background(), key, outputFile); err != nil {
+		os.Remove(outputFile)
+		return fmt.Errorf("failed to store recording: %v", err)
+	}
+
+	video := &models.Video{
+		FileName:   fileName,
+		FilePath:   key,
+		Storage:    target.Type,
+		StorageID:  target.ID,
+		FileSize:   fileInfo.Size(),
+		Duration:   endTime.Sub(startTime).Seconds(),
+		WorkshopID: workshopID,
+		StartTime:  startTime,
+		EndTime:    endTime,
+		Status:     config.VideoStatusNormal,
+		Notes:      "手动录制",
+	}
+	if media != nil {
+		applyMediaInfo(video, media)
+	}
+	if err := s.db.Create(video).Error; err != nil {
+		target.Driver.Delete(context.Background(), key)
+		return fmt.Errorf("failed to save video: %v", err)
+	}
+	return nil
+}
+
+// 生成录制参数，视频直接复制；保留音频时统一转为 AAC，
+// 因为 G.711 等摄像头常见音频编码无法直接封装进 MP4
+func recordingArgs(rtspURL string, outputPath string, recordAudio bool) []string {
+	args := []string{
+		"-rtsp_transport", "tcp",
+		"-i", rtspURL,
+		"-map", "0:v:0",
+	}
+	if recordAudio {
+		args = append(args, "-map", "0:a:0?", "-c:v", "copy", "-c:a", "aac")
+	} else {
+		args = append(args, "-an", "-c:v", "copy")
+	}
+	return append(args, "-f", "mp4", outputPath)
+}
+
 // 停止录制
 func (s *RTSPService) StopRecording(workshopID uint) error {
 	s.recordingMutex.Lock()
@@ -77,11 +170,9 @@ func (s *RTSPService) StopRecording(workshopID uint) error {
 	return nil
 }
 
-// 获取预览流地址
+// 获取预览流地址，实时 HLS 由后端按需生成，返回相对于后端地址的路径
 func (s *RTSPService) GetPreviewURL(workshopID uint) (string, error) {
-	// 这里可以实现转换RTSP流为HLS或WebRTC流的逻辑
-	// 示例中仅返回模拟地址
-	return fmt.Sprintf("http://localhost:8882/live/%d/index.m3u8", workshopID), nil
+	return fmt.Sprintf("/live/%d/index.m3u8", workshopID), nil
 }
 
 // 检查RTSP流是否可用
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+
+	"gorm.io/gorm"
+)
+
+// 预签名地址的有效期，需覆盖一次录像回放的时长
+const storagePresignExpire = 6 * time.Hour
+
+// StorageDriver 录像文件存储驱动，key 为存储内的对象键（即 Video.FilePath）
+type StorageDriver interface {
+	// Put 把本地文件存入 key，成功后本地文件不再保留
+	Put(ctx context.Context, key string, localPath string) error
+	// PutStream 把 r 中的内容写入 key，用于在存储之间迁移
+	PutStream(ctx context.Context, key string, r io.Reader) error
+	// GetRange 从 offset 开始读取 length 字节，length 小于 0 时读到末尾
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Stat 获取对象信息，不存在时返回 utils.ErrFileNotFound
+	Stat(ctx context.Context, key string) (*StorageObject, error)
+	// Delete 删除对象，对象不存在时不报错
+	Delete(ctx context.Context, key string) error
+	// Walk 遍历存储中的所有对象，fn 返回错误时停止遍历
+	Walk(ctx context.Context, fn func(key string, info *StorageObject) error) error
+	// Presign 返回可直接读取对象的地址，供 FFmpeg 等外部程序使用：
+	// 对象存储为预签名 URL，本地存储为文件路径
+	Presign(ctx context.Context, key string, expire time.Duration) (string, error)
+}
+
+// storageMover 可以在存储内直接移动对象的驱动，其他驱动移动时先复制再删除
+type storageMover interface {
+	// Move 把 from 移动到 to，from 不存在时返回 utils.ErrFileNotFound
+	Move(ctx context.Context, from, to string) error
+}
+
+// moveObject 在同一存储内移动对象，用于把录像移入或移出回收站
+func moveObject(ctx context.Context, driver StorageDriver, from, to string) error {
+	if mover, ok := driver.(storageMover); ok {
+		return mover.Move(ctx, from, to)
+	}
+
+	body, err := driver.GetRange(ctx, from, 0, -1)
+	if err != nil {
+		return err
+	}
+	err = driver.PutStream(ctx, to, body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	return driver.Delete(ctx, from)
+}
+
+// StorageObject 存储对象信息
+type StorageObject struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// VideoStorage 为录像选择存储驱动。存储目标可以在后台管理（models.Storage），
+// 未配置存储目标时使用配置文件中的存储；切换存储后，之前保存的录像仍从原存储访问
+type VideoStorage struct {
+	db          *gorm.DB
+	defaultType string
+	drivers     map[string]StorageDriver // 配置文件中的存储，按类型索引
+
+	mutex   sync.Mutex
+	targets map[uint]StorageDriver // 已创建的存储目标驱动，按存储目标ID缓存
+}
+
+// StorageTarget 新录像写入的存储
+type StorageTarget struct {
+	ID     uint // 存储目标ID，0 表示配置文件中的存储
+	Type   string
+	Driver StorageDriver
+}
+
+func NewVideoStorage(cfg config.StorageConfig, db *gorm.DB) (*VideoStorage, error) {
+	storageType := cfg.Type
+	if storageType == "" {
+		storageType = config.StorageTypeLocal
+	}
+
+	s := &VideoStorage{
+		db:          db,
+		defaultType: storageType,
+		drivers: map[string]StorageDriver{
+			config.StorageTypeLocal: NewLocalStorage(cfg.VideoPath),
+		},
+		targets: make(map[uint]StorageDriver),
+	}
+
+	switch storageType {
+	case config.StorageTypeLocal:
+	case config.StorageTypeS3:
+		driver, err := NewS3Storage(cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		s.drivers[config.StorageTypeS3] = driver
+	default:
+		return nil, fmt.Errorf("unsupported storage type: %s", storageType)
+	}
+
+	return s, nil
+}
+
+// Target 返回车间新录像的存储：车间指定的存储目标 > 默认存储目标 > 配置文件中的存储，
+// 已禁用的存储目标不再写入
+func (s *VideoStorage) Target(workshopID uint) (*StorageTarget, error) {
+	var workshop models.Workshop
+	if err := s.db.Select("id", "storage_id").First(&workshop, workshopID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workshop: %v", err)
+	}
+
+	if workshop.StorageID != 0 {
+		if target, err := s.enabledTarget("id = ?", workshop.StorageID); target != nil || err != nil {
+			return target, err
+		}
+	}
+	if target, err := s.enabledTarget("is_default = ?", true); target != nil || err != nil {
+		return target, err
+	}
+	return &StorageTarget{Type: s.defaultType, Driver: s.drivers[s.defaultType]}, nil
+}
+
+// enabledTarget 查找符合条件的已启用存储目标，不存在时返回 nil
+func (s *VideoStorage) enabledTarget(query string, args ...interface{}) (*StorageTarget, error) {
+	var storage models.Storage
+	err := s.db.Where("status = ?", config.StorageStatusEnabled).Where(query, args...).First(&storage).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage: %v", err)
+	}
+
+	driver, err := s.targetDriver(&storage)
+	if err != nil {
+		return nil, err
+	}
+	return &StorageTarget{ID: storage.ID, Type: storage.Type, Driver: driver}, nil
+}
+
+// VideoDriver 返回录像所在存储的驱动，未记录存储类型的旧录像视为本地存储
+func (s *VideoStorage) VideoDriver(video *models.Video) (StorageDriver, error) {
+	if video.StorageID != 0 {
+		var storage models.Storage
+		if err := s.db.First(&storage, video.StorageID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, utils.ErrStorageNotFound
+			}
+			return nil, fmt.Errorf("failed to get storage: %v", err)
+		}
+		return s.targetDriver(&storage)
+	}
+
+	storageType := video.Storage
+	if storageType == "" {
+		storageType = config.StorageTypeLocal
+	}
+	driver, ok := s.drivers[storageType]
+	if !ok {
+		return nil, utils.ErrStorageNotFound
+	}
+	return driver, nil
+}
+
+func (s *VideoStorage) targetDriver(storage *models.Storage) (StorageDriver, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if driver, ok := s.targets[storage.ID]; ok {
+		return driver, nil
+	}
+	driver, err := NewStorageDriver(storage)
+	if err != nil {
+		return nil, err
+	}
+	s.targets[storage.ID] = driver
+	return driver, nil
+}
+
+// forget 存储目标修改或删除后丢弃缓存的驱动
+func (s *VideoStorage) forget(id uint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.targets, id)
+}
+
+// NewStorageDriver 根据存储目标的类型和 JSON 配置创建驱动
+func NewStorageDriver(storage *models.Storage) (StorageDriver, error) {
+	switch storage.Type {
+	case config.StorageTypeLocal:
+		var cfg models.LocalConfig
+		if err := json.Unmarshal([]byte(storage.Config), &cfg); err != nil {
+			return nil, fmt.Errorf("invalid local storage config: %v", err)
+		}
+		if cfg.BasePath == "" {
+			return nil, fmt.Errorf("local storage base path is required")
+		}
+		return NewLocalStorage(cfg.BasePath), nil
+	case config.StorageTypeS3:
+		var cfg models.S3Config
+		if err := json.Unmarshal([]byte(storage.Config), &cfg); err != nil {
+			return nil, fmt.Errorf("invalid s3 storage config: %v", err)
+		}
+		return NewS3Storage(config.S3StorageConfig{
+			Endpoint:        cfg.Endpoint,
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			BucketName:      cfg.BucketName,
+			Region:          cfg.Region,
+			ForcePathStyle:  cfg.ForcePathStyle,
+			StorageClass:    cfg.StorageClass,
+		})
+	case config.StorageTypeOSS:
+		var cfg models.OSSConfig
+		if err := json.Unmarshal([]byte(storage.Config), &cfg); err != nil {
+			return nil, fmt.Errorf("invalid oss storage config: %v", err)
+		}
+		return NewS3Storage(config.S3StorageConfig{
+			Endpoint:        cfg.Endpoint,
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			BucketName:      cfg.BucketName,
+			Region:          ossRegion(cfg.Endpoint),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported storage type: %s", storage.Type)
+	}
+}
+
+// ossRegion 从 OSS 访问域名中解析地域，如 https://oss-cn-hangzhou.aliyuncs.com 解析为 oss-cn-hangzhou
+func ossRegion(endpoint string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
+	if i := strings.Index(host, "."); i > 0 {
+		host = host[:i]
+	}
+	return strings.TrimSuffix(host, "-internal")
+}
+
+// storageReader 基于 GetRange 实现的可跳转读取，用于 http.ServeContent 处理 Range 请求。
+// 跳转时只记录位置，下次读取时再从新位置打开
+type storageReader struct {
+	ctx    context.Context
+	driver StorageDriver
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func newStorageReader(ctx context.Context, driver StorageDriver, key string, size int64) *storageReader {
+	return &storageReader{ctx: ctx, driver: driver, key: key, size: size}
+}
+
+func (r *storageReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.driver.GetRange(r.ctx, r.key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *storageReader) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = r.offset + offset
+	case io.SeekEnd:
+		target = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if target < 0 {
+		return 0, fmt.Errorf("negative position: %d", target)
+	}
+	if target != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = target
+	return target, nil
+}
+
+func (r *storageReader) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+
+	"gorm.io/gorm"
+)
+
+// 每批处理的录像数
+const storageLifecycleBatch = 50
+
+// StorageLifecycleService 按生命周期规则定期把旧录像从源存储（通常是本地磁盘）迁移到归档存储。
+// 迁移时计算 SHA-256 并从归档存储读回校验，校验通过后再原子地更新录像位置并删除源文件，
+// 下载、播放等读取都按录像记录的存储进行，迁移对用户透明。
+type StorageLifecycleService struct {
+	db       *gorm.DB
+	storage  *VideoStorage
+	interval time.Duration
+	running  int32
+}
+
+func NewStorageLifecycleService(cfg *config.Config, db *gorm.DB, storage *VideoStorage) *StorageLifecycleService {
+	interval := cfg.Storage.LifecycleInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	s := &StorageLifecycleService{
+		db:       db,
+		storage:  storage,
+		interval: interval,
+	}
+	go s.loop()
+	return s
+}
+
+// 获取生命周期规则列表
+func (s *StorageLifecycleService) ListRules() ([]models.StorageRule, error) {
+	var rules []models.StorageRule
+	err := s.db.Order("id").Find(&rules).Error
+	return rules, err
+}
+
+// 创建生命周期规则
+func (s *StorageLifecycleService) CreateRule(rule *models.StorageRule) error {
+	if rule.Status == 0 {
+		rule.Status = config.StorageStatusEnabled
+	}
+	if err := s.validateRule(rule); err != nil {
+		return err
+	}
+	return s.db.Create(rule).Error
+}
+
+// 更新生命周期规则
+func (s *StorageLifecycleService) UpdateRule(id uint, rule *models.StorageRule) error {
+	var old models.StorageRule
+	if err := s.db.First(&old, id).Error; err != nil {
+		return fmt.Errorf("storage rule not found with id: %d", id)
+	}
+	if rule.Status == 0 {
+		rule.Status = old.Status
+	}
+	if err := s.validateRule(rule); err != nil {
+		return err
+	}
+
+	rule.ID = id
+	rule.CreatedAt = old.CreatedAt
+	return s.db.Model(&models.StorageRule{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"name":              rule.Name,
+		"source_storage_id": rule.SourceStorageID,
+		"target_storage_id": rule.TargetStorageID,
+		"after_days":        rule.AfterDays,
+		"status":            rule.Status,
+	}).Error
+}
+
+// 删除生命周期规则，已迁移的录像不受影响
+func (s *StorageLifecycleService) DeleteRule(id uint) error {
+	return s.db.Delete(&models.StorageRule{}, id).Error
+}
+
+func (s *StorageLifecycleService) validateRule(rule *models.StorageRule) error {
+	if rule.SourceStorageID == rule.TargetStorageID {
+		return fmt.Errorf("source and target storage must be different")
+	}
+
+	var target models.Storage
+	if err := s.db.First(&target, rule.TargetStorageID).Error; err != nil {
+		return fmt.Errorf("storage not found with id: %d", rule.TargetStorageID)
+	}
+	if target.Status != config.StorageStatusEnabled {
+		return fmt.Errorf("target storage is disabled")
+	}
+	if rule.SourceStorageID != 0 {
+		var source models.Storage
+		if err := s.db.First(&source, rule.SourceStorageID).Error; err != nil {
+			return fmt.Errorf("storage not found with id: %d", rule.SourceStorageID)
+		}
+	}
+	return nil
+}
+
+// Trigger 立即在后台执行一次生命周期规则，已在执行时返回 false
+func (s *StorageLifecycleService) Trigger() bool {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		return false
+	}
+	go func() {
+		defer atomic.StoreInt32(&s.running, 0)
+		s.run(context.Background())
+	}()
+	return true
+}
+
+func (s *StorageLifecycleService) loop() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.Trigger()
+	}
+}
+
+// run 依次执行所有启用的规则
+func (s *StorageLifecycleService) run(ctx context.Context) {
+	var rules []models.StorageRule
+	if err := s.db.Where("status = ?", config.StorageStatusEnabled).Order("id").Find(&rules).Error; err != nil {
+		fmt.Printf("Failed to load storage rules: %v\n", err)
+		return
+	}
+
+	for i := range rules {
+		migrated, err := s.runRule(ctx, &rules[i])
+		if err != nil {
+			fmt.Printf("Storage rule %d failed: %v\n", rules[i].ID, err)
+		}
+		if migrated > 0 {
+			fmt.Printf("Storage rule %d migrated %d videos\n", rules[i].ID, migrated)
+		}
+	}
+}
+
+func (s *StorageLifecycleService) runRule(ctx context.Context, rule *models.StorageRule) (int, error) {
+	var target models.Storage
+	if err := s.db.First(&target, rule.TargetStorageID).Error; err != nil {
+		return 0, fmt.Errorf("failed to get target storage: %v", err)
+	}
+	if target.Status != config.StorageStatusEnabled {
+		return 0, nil
+	}
+	targetDriver, err := s.storage.targetDriver(&target)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -rule.AfterDays)
+	migrated := 0
+	var lastID uint
+	for {
+		var videos []models.Video
+		// 外部文件只读，不迁移
+		err := s.db.Where("storage_id = ? AND status = ? AND external = ? AND start_time < ? AND id > ?", rule.SourceStorageID, config.VideoStatusNormal, false, cutoff, lastID).
+			Order("id").Limit(storageLifecycleBatch).Find(&videos).Error
+		if err != nil {
+			return migrated, err
+		}
+		if len(videos) == 0 {
+			return migrated, nil
+		}
+
+		for i := range videos {
+			if ctx.Err() != nil {
+				return migrated, ctx.Err()
+			}
+			lastID = videos[i].ID
+			// 单个录像失败不影响其他录像，下次执行时重试
+			if err := s.migrate(ctx, &videos[i], &target, targetDriver); err != nil {
+				fmt.Printf("Failed to migrate video %d: %v\n", videos[i].ID, err)
+				continue
+			}
+			migrated++
+		}
+	}
+}
+
+// migrate 把录像复制到归档存储，校验后更新录像位置并删除源文件
+func (s *StorageLifecycleService) migrate(ctx context.Context, video *models.Video, target *models.Storage, targetDriver StorageDriver) error {
+	sourceDriver, err := s.storage.VideoDriver(video)
+	if err != nil {
+		return err
+	}
+	key := archiveKey(video)
+
+	// 不覆盖归档存储中已有的文件，可能属于其他录像
+	if _, err := targetDriver.Stat(ctx, key); err == nil {
+		return fmt.Errorf("archive key already exists: %s", key)
+	} else if !errors.Is(err, utils.ErrFileNotFound) {
+		return err
+	}
+
+	// 上传时计算校验和
+	src, err := sourceDriver.GetRange(ctx, video.FilePath, 0, -1)
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	counter := &countingReader{r: io.TeeReader(src, hasher)}
+	err = targetDriver.PutStream(ctx, key, counter)
+	src.Close()
+	if err != nil {
+		return err
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+
+	// 源文件已有校验和时先核对，避免把损坏的文件归档
+	if video.Checksum != "" && video.Checksum != checksum {
+		targetDriver.Delete(context.Background(), key)
+		return fmt.Errorf("source checksum mismatch")
+	}
+
+	// 从归档存储读回校验
+	if err := verifyStoredObject(ctx, targetDriver, key, counter.n, checksum); err != nil {
+		targetDriver.Delete(context.Background(), key)
+		return err
+	}
+
+	// 封面图和预览图一起迁移
+	updates := copyThumbnails(ctx, video, sourceDriver, targetDriver, key)
+	updates["storage"] = target.Type
+	updates["storage_id"] = target.ID
+	updates["file_path"] = key
+	updates["checksum"] = checksum
+
+	// 只有录像仍在原位置时才更新，期间被删除或已被其他任务迁移则放弃本次迁移
+	result := s.db.Model(&models.Video{}).
+		Where("id = ? AND storage_id = ? AND file_path = ?", video.ID, video.StorageID, video.FilePath).
+		Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 {
+		targetDriver.Delete(context.Background(), key)
+		deleteThumbnails(context.Background(), targetDriver, &models.Video{
+			ThumbnailPath: updates["thumbnail_path"].(string),
+			SpritePath:    updates["sprite_path"].(string),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		return nil
+	}
+
+	// 源文件删除失败不影响迁移结果，录像已从归档存储读取
+	if err := sourceDriver.Delete(context.Background(), video.FilePath); err != nil {
+		fmt.Printf("Failed to delete migrated source file of video %d: %v\n", video.ID, err)
+	}
+	if err := deleteThumbnails(context.Background(), sourceDriver, video); err != nil {
+		fmt.Printf("Failed to delete migrated thumbnails of video %d: %v\n", video.ID, err)
+	}
+	return nil
+}
+
+// verifyStoredObject 读取对象并核对大小和 SHA-256
+func verifyStoredObject(ctx context.Context, driver StorageDriver, key string, size int64, checksum string) error {
+	info, err := driver.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if info.Size != size {
+		return fmt.Errorf("archived size mismatch: %d != %d", info.Size, size)
+	}
+
+	body, err := driver.GetRange(ctx, key, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, body); err != nil {
+		return fmt.Errorf("failed to read archived file: %v", err)
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != checksum {
+		return fmt.Errorf("archived checksum mismatch")
+	}
+	return nil
+}
+
+// archiveKey 录像在归档存储中的对象键，旧录像的绝对路径转换为按车间和录像ID区分的键，
+// 不同目录下的同名文件不会冲突
+func archiveKey(video *models.Video) string {
+	if filepath.IsAbs(video.FilePath) {
+		return path.Join("captures", fmt.Sprintf("%d", video.WorkshopID), fmt.Sprintf("%d", video.ID), filepath.Base(video.FilePath))
+	}
+	return filepath.ToSlash(video.FilePath)
+}
+
+// countingReader 统计读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"videodb/be/utils"
+)
+
+// LocalStorage 本地磁盘存储，对象键为相对 basePath 的路径；
+// 旧录像记录中保存的是绝对路径，直接按原路径访问
+type LocalStorage struct {
+	basePath string
+}
+
+func NewLocalStorage(basePath string) *LocalStorage {
+	return &LocalStorage{basePath: basePath}
+}
+
+// path 把对象键解析为本地路径，拒绝跳出存储目录的相对路径
+func (s *LocalStorage) path(key string) (string, error) {
+	if key == "" {
+		return "", utils.ErrInvalidParameter
+	}
+	if filepath.IsAbs(key) {
+		return filepath.Clean(key), nil
+	}
+	cleaned := filepath.Clean(key)
+	if cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", utils.ErrInvalidParameter
+	}
+	return filepath.Join(s.basePath, cleaned), nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, localPath string) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create storage directory: %v", err)
+	}
+
+	// 同一文件系统内直接移动，跨设备时复制后删除
+	if err := os.Rename(localPath, target); err == nil {
+		return nil
+	}
+	if err := utils.CopyFile(localPath, target); err != nil {
+		os.Remove(target)
+		return fmt.Errorf("failed to store file: %v", err)
+	}
+	os.Remove(localPath)
+	return nil
+}
+
+func (s *LocalStorage) Move(ctx context.Context, from, to string) error {
+	source, err := s.path(from)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(source); err != nil {
+		if os.IsNotExist(err) {
+			return utils.ErrFileNotFound
+		}
+		return fmt.Errorf("failed to stat file: %v", err)
+	}
+	return s.Put(ctx, to, source)
+}
+
+func (s *LocalStorage) Walk(ctx context.Context, fn func(key string, info *StorageObject) error) error {
+	return filepath.WalkDir(s.basePath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// 存储目录尚未创建时没有对象
+			if path == s.basePath && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			// 遍历期间被删除的文件
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(s.basePath, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), &StorageObject{Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+// relKey 把旧录像记录中的绝对路径转换为对象键，不在存储目录内时返回 false
+func (s *LocalStorage) relKey(path string) (string, bool) {
+	base, err := filepath.Abs(s.basePath)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(base, filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+func (s *LocalStorage) PutStream(ctx context.Context, key string, r io.Reader) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create storage directory: %v", err)
+	}
+
+	// 先写入同目录下的临时文件，完整写入后再改名，避免留下不完整的文件
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to store file: %v", err)
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store file: %v", err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, utils.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to seek file: %v", err)
+		}
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+func (s *LocalStorage) Stat(ctx context.Context, key string) (*StorageObject, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, utils.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to stat file: %v", err)
+	}
+	if info.IsDir() {
+		return nil, utils.ErrFileNotFound
+	}
+	return &StorageObject{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Presign 本地存储直接返回文件路径
+func (s *LocalStorage) Presign(ctx context.Context, key string, expire time.Duration) (string, error) {
+	return s.path(key)
+}
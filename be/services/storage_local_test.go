@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"videodb/be/utils"
+)
+
+func TestLocalStoragePath(t *testing.T) {
+	base := t.TempDir()
+	legacy := filepath.Join(t.TempDir(), "legacy", "1_20240105083000.mp4")
+	s := NewLocalStorage(base)
+
+	tests := []struct {
+		name string
+		key  string
+		want string
+		err  error
+	}{
+		{name: "对象键", key: "captures/1/a.mp4", want: filepath.Join(base, "captures", "1", "a.mp4")},
+		{name: "目录内的 ..", key: "captures/../imports/a.mp4", want: filepath.Join(base, "imports", "a.mp4")},
+		{name: ".. 开头的文件名", key: "..a/b.mp4", want: filepath.Join(base, "..a", "b.mp4")},
+		{name: "旧记录的绝对路径", key: legacy, want: legacy},
+		{name: "空键", key: "", err: utils.ErrInvalidParameter},
+		{name: "上级目录", key: "..", err: utils.ErrInvalidParameter},
+		{name: "跳出存储目录", key: "../a.mp4", err: utils.ErrInvalidParameter},
+		{name: "多级跳出存储目录", key: "captures/../../a.mp4", err: utils.ErrInvalidParameter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.path(tt.key)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("path(%q) error = %v, want %v", tt.key, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("path(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalStorageGetRange(t *testing.T) {
+	s := NewLocalStorage(t.TempDir())
+	ctx := context.Background()
+	writeStorageObject(t, s, "a.mp4", "0123456789")
+
+	tests := []struct {
+		name           string
+		offset, length int64
+		want           string
+	}{
+		{name: "整个文件", offset: 0, length: -1, want: "0123456789"},
+		{name: "从中间读到末尾", offset: 6, length: -1, want: "6789"},
+		{name: "中间一段", offset: 3, length: 4, want: "3456"},
+		{name: "长度为 0", offset: 3, length: 0, want: ""},
+		{name: "超出末尾的长度", offset: 8, length: 10, want: "89"},
+		{name: "从末尾开始", offset: 10, length: -1, want: ""},
+		{name: "超出末尾的位置", offset: 20, length: 5, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := s.GetRange(ctx, "a.mp4", tt.offset, tt.length)
+			if err != nil {
+				t.Fatalf("GetRange() error = %v", err)
+			}
+			defer body.Close()
+			data, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("read error = %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("GetRange(%d, %d) = %q, want %q", tt.offset, tt.length, data, tt.want)
+			}
+		})
+	}
+
+	if _, err := s.GetRange(ctx, "missing.mp4", 0, -1); !errors.Is(err, utils.ErrFileNotFound) {
+		t.Errorf("GetRange() of missing file error = %v, want %v", err, utils.ErrFileNotFound)
+	}
+	if _, err := s.GetRange(ctx, "../a.mp4", 0, -1); !errors.Is(err, utils.ErrInvalidParameter) {
+		t.Errorf("GetRange() outside storage error = %v, want %v", err, utils.ErrInvalidParameter)
+	}
+}
+
+func TestLocalStorageWalk(t *testing.T) {
+	s := NewLocalStorage(t.TempDir())
+	ctx := context.Background()
+	writeStorageObject(t, s, "a.mp4", "abc")
+	writeStorageObject(t, s, "captures/1/b.mp4", "12345")
+	if err := os.MkdirAll(filepath.Join(s.basePath, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]int64{}
+	err := s.Walk(ctx, func(key string, info *StorageObject) error {
+		got[key] = info.Size
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	want := map[string]int64{"a.mp4": 3, "captures/1/b.mp4": 5}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk() = %v, want %v", got, want)
+	}
+
+	// fn 返回错误时停止遍历
+	stop := errors.New("stop")
+	count := 0
+	err = s.Walk(ctx, func(key string, info *StorageObject) error {
+		count++
+		return stop
+	})
+	if !errors.Is(err, stop) || count != 1 {
+		t.Errorf("Walk() stopping = %v after %d objects, want %v after 1", err, count, stop)
+	}
+
+	// 存储目录尚未创建时没有对象
+	missing := NewLocalStorage(filepath.Join(t.TempDir(), "missing"))
+	err = missing.Walk(ctx, func(key string, info *StorageObject) error {
+		t.Errorf("unexpected object %q", key)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Walk() of missing directory error = %v", err)
+	}
+}
+
+func TestLocalStoragePutMoveDelete(t *testing.T) {
+	s := NewLocalStorage(t.TempDir())
+	ctx := context.Background()
+
+	local := filepath.Join(t.TempDir(), "a.mp4")
+	if err := os.WriteFile(local, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, "captures/1/a.mp4", local); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, err := os.Stat(local); !os.IsNotExist(err) {
+		t.Errorf("local file still exists after Put(): %v", err)
+	}
+
+	if err := s.Move(ctx, "captures/1/a.mp4", ".trash/1/a.mp4"); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	if _, err := s.Stat(ctx, "captures/1/a.mp4"); !errors.Is(err, utils.ErrFileNotFound) {
+		t.Errorf("Stat() of moved object error = %v, want %v", err, utils.ErrFileNotFound)
+	}
+	info, err := s.Stat(ctx, ".trash/1/a.mp4")
+	if err != nil || info.Size != 3 {
+		t.Fatalf("Stat() after Move() = %+v, %v", info, err)
+	}
+	if err := s.Move(ctx, "missing.mp4", "b.mp4"); !errors.Is(err, utils.ErrFileNotFound) {
+		t.Errorf("Move() of missing object error = %v, want %v", err, utils.ErrFileNotFound)
+	}
+
+	if err := s.Delete(ctx, ".trash/1/a.mp4"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := s.Delete(ctx, ".trash/1/a.mp4"); err != nil {
+		t.Errorf("Delete() of missing object error = %v", err)
+	}
+}
+
+// writeStorageObject 通过 PutStream 写入测试对象
+func writeStorageObject(t *testing.T, driver StorageDriver, key, content string) {
+	t.Helper()
+	if err := driver.PutStream(context.Background(), key, strings.NewReader(content)); err != nil {
+		t.Fatalf("PutStream(%q) error = %v", key, err)
+	}
+}
@@ -0,0 +1,487 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+
+	"gorm.io/gorm"
+)
+
+// 报告中每个存储最多列出的孤立文件和丢失文件的录像数
+const reconcileListLimit = 1000
+
+// 采集文件的对象键：captures/<车间ID>/capture_<开始时间>.mp4
+var captureKeyPattern = regexp.MustCompile(`^captures/(\d+)/capture_(\d{8}_\d{6})\.mp4$`)
+
+// ReconcileService 核对存储中的文件与录像记录：
+// 没有录像记录的文件为孤立文件，可以导入为录像；找不到文件的录像可以标记为文件丢失。
+// 定期核对只生成报告，重新找到文件的丢失录像会自动恢复为正常状态
+type ReconcileService struct {
+	db          *gorm.DB
+	storage     *VideoStorage
+	ffprobePath string
+	running     int32
+
+	mutex  sync.Mutex
+	report ReconcileReport
+}
+
+// ReconcileReport 最近一次核对的结果
+type ReconcileReport struct {
+	Running    bool               `json:"running"`
+	StartedAt  *time.Time         `json:"startedAt"`
+	FinishedAt *time.Time         `json:"finishedAt"`
+	Storages   []ReconcileStorage `json:"storages"`
+}
+
+// ReconcileStorage 单个存储的核对结果
+type ReconcileStorage struct {
+	StorageID    uint               `json:"storageId"` // 0 表示配置文件中的存储
+	Storage      string             `json:"storage"`   // 存储类型
+	Name         string             `json:"name"`
+	Scanned      int                `json:"scanned"` // 扫描的文件数
+	OrphanCount  int                `json:"orphanCount"`
+	OrphanSize   int64              `json:"orphanSize"`
+	Orphans      []ReconcileOrphan  `json:"orphans"`
+	MissingCount int                `json:"missingCount"`
+	Missing      []ReconcileMissing `json:"missing"`
+	Recovered    int                `json:"recovered"` // 重新找到文件、恢复为正常状态的录像数
+	Error        string             `json:"error,omitempty"`
+}
+
+// ReconcileOrphan 没有录像记录的文件
+type ReconcileOrphan struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// ReconcileMissing 找不到文件的录像
+type ReconcileMissing struct {
+	VideoID    uint   `json:"videoId"`
+	WorkshopID uint   `json:"workshopId"`
+	FileName   string `json:"fileName"`
+	Key        string `json:"key"`
+	Status     int    `json:"status"` // 已标记为文件丢失时为 4
+}
+
+// ReconcileImportResult 导入单个孤立文件的结果
+type ReconcileImportResult struct {
+	Key     string `json:"key"`
+	VideoID uint   `json:"videoId,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// reconcileSource 要核对的存储
+type reconcileSource struct {
+	ID     uint
+	Type   string
+	Name   string
+	Driver StorageDriver
+}
+
+func NewReconcileService(cfg *config.Config, db *gorm.DB, storage *VideoStorage) *ReconcileService {
+	s := &ReconcileService{
+		db:          db,
+		storage:     storage,
+		ffprobePath: cfg.RTSP.FFprobePath,
+		report:      ReconcileReport{Storages: []ReconcileStorage{}},
+	}
+	if cfg.Storage.ReconcileInterval > 0 {
+		go s.loop(cfg.Storage.ReconcileInterval)
+	}
+	return s
+}
+
+// Report 返回最近一次核对的结果
+func (s *ReconcileService) Report() ReconcileReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.report
+}
+
+// Trigger 在后台立即核对一次，已在执行时返回 false
+func (s *ReconcileService) Trigger() bool {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		return false
+	}
+	go func() {
+		defer atomic.StoreInt32(&s.running, 0)
+		s.run(context.Background())
+	}()
+	return true
+}
+
+func (s *ReconcileService) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.Trigger()
+	}
+}
+
+func (s *ReconcileService) run(ctx context.Context) {
+	startedAt := time.Now()
+	s.mutex.Lock()
+	s.report.Running = true
+	s.report.StartedAt = &startedAt
+	s.mutex.Unlock()
+
+	storages := []ReconcileStorage{}
+	sources, err := s.sources()
+	if err != nil {
+		fmt.Printf("Failed to load storages for reconcile: %v\n", err)
+	}
+	for _, source := range sources {
+		result := s.scan(ctx, source)
+		if result.OrphanCount > 0 || result.MissingCount > 0 {
+			fmt.Printf("Reconcile storage %s: %d orphan files, %d missing videos\n", result.Name, result.OrphanCount, result.MissingCount)
+		}
+		storages = append(storages, result)
+	}
+
+	finishedAt := time.Now()
+	s.mutex.Lock()
+	s.report = ReconcileReport{
+		StartedAt:  &startedAt,
+		FinishedAt: &finishedAt,
+		Storages:   storages,
+	}
+	s.mutex.Unlock()
+}
+
+// sources 配置文件中的存储和所有存储目标
+func (s *ReconcileService) sources() ([]reconcileSource, error) {
+	sources := []reconcileSource{{
+		Type:   config.StorageTypeLocal,
+		Name:   config.StorageTypeLocal,
+		Driver: s.storage.drivers[config.StorageTypeLocal],
+	}}
+	if s.storage.defaultType != config.StorageTypeLocal {
+		sources = append(sources, reconcileSource{
+			Type:   s.storage.defaultType,
+			Name:   s.storage.defaultType,
+			Driver: s.storage.drivers[s.storage.defaultType],
+		})
+	}
+
+	var storages []models.Storage
+	if err := s.db.Order("id").Find(&storages).Error; err != nil {
+		return sources, fmt.Errorf("failed to get storages: %v", err)
+	}
+	for i := range storages {
+		source := reconcileSource{ID: storages[i].ID, Type: storages[i].Type, Name: storages[i].Name}
+		driver, err := s.storage.targetDriver(&storages[i])
+		if err != nil {
+			fmt.Printf("Failed to open storage %d for reconcile: %v\n", storages[i].ID, err)
+			continue
+		}
+		source.Driver = driver
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// source 根据存储目标ID和类型查找存储
+func (s *ReconcileService) source(storageID uint, storageType string) (*reconcileSource, error) {
+	if storageID == 0 {
+		if storageType == "" {
+			storageType = config.StorageTypeLocal
+		}
+		driver, ok := s.storage.drivers[storageType]
+		if !ok {
+			return nil, utils.ErrStorageNotFound
+		}
+		return &reconcileSource{Type: storageType, Name: storageType, Driver: driver}, nil
+	}
+
+	var storage models.Storage
+	if err := s.db.First(&storage, storageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrStorageNotFound
+		}
+		return nil, fmt.Errorf("failed to get storage: %v", err)
+	}
+	driver, err := s.storage.targetDriver(&storage)
+	if err != nil {
+		return nil, err
+	}
+	return &reconcileSource{ID: storage.ID, Type: storage.Type, Name: storage.Name, Driver: driver}, nil
+}
+
+// videos 查询保存在该存储中的录像
+func (s *reconcileSource) videos(db *gorm.DB) *gorm.DB {
+	if s.ID != 0 {
+		return db.Where("storage_id = ?", s.ID)
+	}
+	if s.Type == config.StorageTypeLocal {
+		return db.Where("storage_id = 0 AND (storage = ? OR storage = '' OR storage IS NULL)", s.Type)
+	}
+	return db.Where("storage_id = 0 AND storage = ?", s.Type)
+}
+
+// key 录像记录中的文件位置对应的对象键，旧录像的绝对路径不在存储目录内时返回 false
+func (s *reconcileSource) key(filePath string) (string, bool) {
+	if !filepath.IsAbs(filePath) {
+		return filepath.ToSlash(filePath), true
+	}
+	if local, ok := s.Driver.(*LocalStorage); ok {
+		return local.relKey(filePath)
+	}
+	return "", false
+}
+
+// scan 遍历存储，与录像记录比对
+func (s *ReconcileService) scan(ctx context.Context, source reconcileSource) ReconcileStorage {
+	result := ReconcileStorage{
+		StorageID: source.ID,
+		Storage:   source.Type,
+		Name:      source.Name,
+		Orphans:   []ReconcileOrphan{},
+		Missing:   []ReconcileMissing{},
+	}
+
+	var videos []models.Video
+	err := source.videos(s.db).
+		Select("id", "workshop_id", "file_name", "file_path", "trash_path", "thumbnail_path", "sprite_path", "status").
+		Where("status IN ?", []int{config.VideoStatusNormal, config.VideoStatusDeleted, config.VideoStatusPurging, config.VideoStatusMissing}).
+		Find(&videos).Error
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to get videos: %v", err)
+		return result
+	}
+
+	// 录像记录引用的对象键，回收站中的录像原位置和回收站中的文件、封面图和预览图都算作被引用
+	expected := make(map[string]bool, len(videos))
+	var outside []int
+	for i := range videos {
+		if key, ok := source.key(videos[i].FilePath); ok {
+			expected[key] = false
+		} else {
+			outside = append(outside, i)
+		}
+		for _, key := range []string{videos[i].TrashPath, videos[i].ThumbnailPath, videos[i].SpritePath} {
+			if key != "" {
+				expected[filepath.ToSlash(key)] = false
+			}
+		}
+	}
+
+	err = source.Driver.Walk(ctx, func(key string, info *StorageObject) error {
+		if ignoredStorageKey(key) {
+			return nil
+		}
+		result.Scanned++
+		if _, ok := expected[key]; ok {
+			expected[key] = true
+			return nil
+		}
+		result.OrphanCount++
+		result.OrphanSize += info.Size
+		if len(result.Orphans) < reconcileListLimit {
+			result.Orphans = append(result.Orphans, ReconcileOrphan{Key: key, Size: info.Size, ModTime: info.ModTime})
+		}
+		return nil
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// 不在存储目录内的旧录像单独检查
+	found := make(map[int]bool, len(outside))
+	for _, i := range outside {
+		if _, err := source.Driver.Stat(ctx, videos[i].FilePath); err == nil {
+			found[i] = true
+		}
+	}
+
+	for i := range videos {
+		video := &videos[i]
+		if video.Status == config.VideoStatusDeleted || video.Status == config.VideoStatusPurging {
+			continue
+		}
+		exists := found[i]
+		if key, ok := source.key(video.FilePath); ok {
+			exists = expected[key]
+		}
+
+		if exists {
+			if video.Status == config.VideoStatusMissing {
+				err := s.db.Model(&models.Video{}).
+					Where("id = ? AND status = ?", video.ID, config.VideoStatusMissing).
+					Update("status", config.VideoStatusNormal).Error
+				if err == nil {
+					result.Recovered++
+				}
+			}
+			continue
+		}
+
+		result.MissingCount++
+		if len(result.Missing) < reconcileListLimit {
+			result.Missing = append(result.Missing, ReconcileMissing{
+				VideoID:    video.ID,
+				WorkshopID: video.WorkshopID,
+				FileName:   video.FileName,
+				Key:        video.FilePath,
+				Status:     video.Status,
+			})
+		}
+	}
+	return result
+}
+
+// MarkMissing 把找不到文件的录像标记为文件丢失，返回标记的录像数，文件存在的录像不标记
+func (s *ReconcileService) MarkMissing(ctx context.Context, ids []uint) (int, error) {
+	var videos []models.Video
+	if err := s.db.Where("id IN ? AND status = ?", ids, config.VideoStatusNormal).Find(&videos).Error; err != nil {
+		return 0, err
+	}
+
+	marked := 0
+	for i := range videos {
+		driver, err := s.storage.VideoDriver(&videos[i])
+		if err != nil {
+			return marked, err
+		}
+		_, err = driver.Stat(ctx, videos[i].FilePath)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, utils.ErrFileNotFound) {
+			return marked, err
+		}
+
+		result := s.db.Model(&models.Video{}).
+			Where("id = ? AND status = ?", videos[i].ID, config.VideoStatusNormal).
+			Update("status", config.VideoStatusMissing)
+		if result.Error != nil {
+			return marked, result.Error
+		}
+		marked += int(result.RowsAffected)
+	}
+	return marked, nil
+}
+
+// Import 把孤立文件导入为录像记录。采集文件从路径中识别车间和开始时间，
+// 其他文件使用请求中的车间，开始时间按文件修改时间和时长推算
+func (s *ReconcileService) Import(ctx context.Context, req *models.ReconcileImportRequest) ([]ReconcileImportResult, error) {
+	source, err := s.source(req.StorageID, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ReconcileImportResult, 0, len(req.Keys))
+	for _, key := range req.Keys {
+		result := ReconcileImportResult{Key: key}
+		video, err := s.importFile(ctx, source, key, req.WorkshopID)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.VideoID = video.ID
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *ReconcileService) importFile(ctx context.Context, source *reconcileSource, key string, workshopID uint) (*models.Video, error) {
+	if !validStorageKey(key) || ignoredStorageKey(key) || strings.HasPrefix(key, trashDir+"/") {
+		return nil, utils.ErrInvalidParameter
+	}
+	info, err := source.Driver.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := source.videos(s.db.Model(&models.Video{})).Where("file_path = ?", key).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, fmt.Errorf("file is already referenced by a video")
+	}
+
+	// 从采集文件路径中识别车间和开始时间
+	var startTime time.Time
+	if match := captureKeyPattern.FindStringSubmatch(key); match != nil {
+		if id, err := strconv.ParseUint(match[1], 10, 32); err == nil {
+			workshopID = uint(id)
+		}
+		startTime, _ = time.ParseInLocation("20060102_150405", match[2], time.Local)
+	}
+	if workshopID == 0 {
+		return nil, fmt.Errorf("workshop is required")
+	}
+	var workshop models.Workshop
+	if err := s.db.Select("id").First(&workshop, workshopID).Error; err != nil {
+		return nil, fmt.Errorf("workshop not found with id: %d", workshopID)
+	}
+
+	// 只导入能读取到视频流的文件
+	input, err := source.Driver.Presign(ctx, key, storagePresignExpire)
+	if err != nil {
+		return nil, err
+	}
+	media, err := utils.NewFFprobe(s.ffprobePath).Probe(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe file: %v", err)
+	}
+	if media.Video() == nil {
+		return nil, fmt.Errorf("no video stream found in file")
+	}
+	if startTime.IsZero() {
+		// 文件在录制结束时写完
+		startTime = info.ModTime.Add(-time.Duration(media.Duration * float64(time.Second)))
+	}
+
+	video := &models.Video{
+		FileName:   path.Base(key),
+		FilePath:   key,
+		Storage:    source.Type,
+		StorageID:  source.ID,
+		FileSize:   info.Size,
+		WorkshopID: workshopID,
+		StartTime:  startTime,
+		EndTime:    startTime,
+		Status:     config.VideoStatusNormal,
+		Notes:      "存储核对导入",
+	}
+	applyMediaInfo(video, media)
+	if err := s.db.Create(video).Error; err != nil {
+		return nil, err
+	}
+	return video, nil
+}
+
+// validStorageKey 存储中的相对路径，不允许绝对路径和访问上级目录
+func validStorageKey(key string) bool {
+	if key == "" || path.IsAbs(key) || filepath.IsAbs(key) || strings.Contains(key, "\\") || path.Clean(key) != key {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// ignoredStorageKey 存储中不属于录像的文件：连接测试文件和未写完的临时文件
+func ignoredStorageKey(key string) bool {
+	return strings.HasPrefix(key, ".idip-test/") || strings.HasPrefix(path.Base(key), ".upload-")
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"videodb/be/config"
+	"videodb/be/utils"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// S3Storage S3 兼容的对象存储（AWS S3、MinIO 等）
+type S3Storage struct {
+	bucket       string
+	storageClass string
+	client       *s3.S3
+	uploader     *s3manager.Uploader
+}
+
+func NewS3Storage(cfg config.S3StorageConfig) (*S3Storage, error) {
+	if cfg.BucketName == "" {
+		return nil, fmt.Errorf("s3 bucket name is required")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	awsConfig := &aws.Config{
+		Region:           aws.String(region),
+		Credentials:      credentials.NewStaticCredentials(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		S3ForcePathStyle: aws.Bool(cfg.ForcePathStyle),
+	}
+	if cfg.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
+		awsConfig.DisableSSL = aws.Bool(strings.HasPrefix(cfg.Endpoint, "http://"))
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 session: %v", err)
+	}
+
+	return &S3Storage{
+		bucket:       cfg.BucketName,
+		storageClass: cfg.StorageClass,
+		client:       s3.New(sess),
+		uploader:     s3manager.NewUploader(sess),
+	}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	if err := s.PutStream(ctx, key, file); err != nil {
+		return err
+	}
+
+	file.Close()
+	os.Remove(localPath)
+	return nil
+}
+
+func (s *S3Storage) PutStream(ctx context.Context, key string, r io.Reader) error {
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        r,
+		ContentType: aws.String("video/mp4"),
+	}
+	if s.storageClass != "" {
+		input.StorageClass = aws.String(s.storageClass)
+	}
+
+	if _, err := s.uploader.UploadWithContext(ctx, input); err != nil {
+		return fmt.Errorf("failed to upload file: %v", err)
+	}
+	return nil
+}
+
+// Move 在桶内复制后删除原对象，单个对象最大 5GB
+func (s *S3Storage) Move(ctx context.Context, from, to string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(to),
+		CopySource: aws.String((&url.URL{Path: s.bucket + "/" + from}).EscapedPath()),
+	}
+	if s.storageClass != "" {
+		input.StorageClass = aws.String(s.storageClass)
+	}
+	if _, err := s.client.CopyObjectWithContext(ctx, input); err != nil {
+		if isS3NotFound(err) {
+			return utils.ErrFileNotFound
+		}
+		return fmt.Errorf("failed to copy object: %v", err)
+	}
+	return s.Delete(ctx, from)
+}
+
+func (s *S3Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if length >= 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	output, err := s.client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, utils.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to get object: %v", err)
+	}
+	return output.Body, nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, key string) (*StorageObject, error) {
+	output, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, utils.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to stat object: %v", err)
+	}
+	return &StorageObject{
+		Size:    aws.Int64Value(output.ContentLength),
+		ModTime: aws.TimeValue(output.LastModified),
+	}, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil && !isS3NotFound(err) {
+		return fmt.Errorf("failed to delete object: %v", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Walk(ctx context.Context, fn func(key string, info *StorageObject) error) error {
+	var walkErr error
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			walkErr = fn(aws.StringValue(object.Key), &StorageObject{
+				Size:    aws.Int64Value(object.Size),
+				ModTime: aws.TimeValue(object.LastModified),
+			})
+			if walkErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %v", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Presign(ctx context.Context, key string, expire time.Duration) (string, error) {
+	req, _ := s.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	req.SetContext(ctx)
+	url, err := req.Presign(expire)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object: %v", err)
+	}
+	return url, nil
+}
+
+func isS3NotFound(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound"
+	}
+	return false
+}
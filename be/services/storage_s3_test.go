@@ -0,0 +1,259 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"videodb/be/config"
+	"videodb/be/utils"
+)
+
+// 设置 S3_TEST_ENDPOINT 等环境变量时对真实的 MinIO 测试，否则使用内存中的 S3 桩服务。
+// 本地启动 MinIO 见 README 的录像存储一节，桶需预先创建
+func newTestS3Storage(t *testing.T) (*S3Storage, string) {
+	t.Helper()
+	prefix := "test-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "/"
+
+	cfg := config.S3StorageConfig{
+		Endpoint:        os.Getenv("S3_TEST_ENDPOINT"),
+		AccessKeyID:     os.Getenv("S3_TEST_ACCESS_KEY"),
+		SecretAccessKey: os.Getenv("S3_TEST_SECRET_KEY"),
+		BucketName:      os.Getenv("S3_TEST_BUCKET"),
+		ForcePathStyle:  true,
+	}
+	if cfg.Endpoint == "" {
+		server := httptest.NewServer(newS3Stub())
+		t.Cleanup(server.Close)
+		cfg = config.S3StorageConfig{
+			Endpoint:        server.URL,
+			AccessKeyID:     "test",
+			SecretAccessKey: "test",
+			BucketName:      "videos",
+			ForcePathStyle:  true,
+		}
+	} else if cfg.BucketName == "" {
+		t.Skip("S3_TEST_BUCKET is not set")
+	}
+
+	s, err := NewS3Storage(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Storage() error = %v", err)
+	}
+	return s, prefix
+}
+
+func TestS3Storage(t *testing.T) {
+	s, prefix := newTestS3Storage(t)
+	ctx := context.Background()
+	key := prefix + "captures/1/a.mp4"
+
+	local := filepath.Join(t.TempDir(), "a.mp4")
+	if err := os.WriteFile(local, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, key, local); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	t.Cleanup(func() { s.Delete(context.Background(), key) })
+	if _, err := os.Stat(local); !os.IsNotExist(err) {
+		t.Errorf("local file still exists after Put(): %v", err)
+	}
+
+	info, err := s.Stat(ctx, key)
+	if err != nil || info.Size != 10 {
+		t.Fatalf("Stat() = %+v, %v, want size 10", info, err)
+	}
+	if _, err := s.Stat(ctx, prefix+"missing.mp4"); !errors.Is(err, utils.ErrFileNotFound) {
+		t.Errorf("Stat() of missing object error = %v, want %v", err, utils.ErrFileNotFound)
+	}
+
+	ranges := []struct {
+		name           string
+		offset, length int64
+		want           string
+	}{
+		{name: "整个对象", offset: 0, length: -1, want: "0123456789"},
+		{name: "从中间读到末尾", offset: 6, length: -1, want: "6789"},
+		{name: "中间一段", offset: 3, length: 4, want: "3456"},
+		{name: "超出末尾的长度", offset: 8, length: 10, want: "89"},
+	}
+	for _, tt := range ranges {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := s.GetRange(ctx, key, tt.offset, tt.length)
+			if err != nil {
+				t.Fatalf("GetRange() error = %v", err)
+			}
+			defer body.Close()
+			data, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("read error = %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("GetRange(%d, %d) = %q, want %q", tt.offset, tt.length, data, tt.want)
+			}
+		})
+	}
+	if _, err := s.GetRange(ctx, prefix+"missing.mp4", 0, -1); !errors.Is(err, utils.ErrFileNotFound) {
+		t.Errorf("GetRange() of missing object error = %v, want %v", err, utils.ErrFileNotFound)
+	}
+
+	// 通过 storageReader 跳转读取
+	r := newStorageReader(ctx, s, key, info.Size)
+	defer r.Close()
+	if _, err := r.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	if data, err := io.ReadAll(r); err != nil || string(data) != "789" {
+		t.Errorf("storageReader read = %q, %v, want %q", data, err, "789")
+	}
+
+	moved := prefix + ".trash/1/a.mp4"
+	if err := s.Move(ctx, key, moved); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	t.Cleanup(func() { s.Delete(context.Background(), moved) })
+	if _, err := s.Stat(ctx, key); !errors.Is(err, utils.ErrFileNotFound) {
+		t.Errorf("Stat() of moved object error = %v, want %v", err, utils.ErrFileNotFound)
+	}
+	if err := s.Move(ctx, prefix+"missing.mp4", prefix+"b.mp4"); !errors.Is(err, utils.ErrFileNotFound) {
+		t.Errorf("Move() of missing object error = %v, want %v", err, utils.ErrFileNotFound)
+	}
+
+	if err := s.PutStream(ctx, prefix+"imports/b.mp4", strings.NewReader("abc")); err != nil {
+		t.Fatalf("PutStream() error = %v", err)
+	}
+	t.Cleanup(func() { s.Delete(context.Background(), prefix+"imports/b.mp4") })
+
+	got := map[string]int64{}
+	err = s.Walk(ctx, func(key string, info *StorageObject) error {
+		if strings.HasPrefix(key, prefix) {
+			got[strings.TrimPrefix(key, prefix)] = info.Size
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	want := map[string]int64{".trash/1/a.mp4": 10, "imports/b.mp4": 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk() = %v, want %v", got, want)
+	}
+
+	if err := s.Delete(ctx, moved); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := s.Delete(ctx, moved); err != nil {
+		t.Errorf("Delete() of missing object error = %v", err)
+	}
+}
+
+// s3Stub 内存中的 S3 桩服务，只实现存储驱动用到的接口，按路径风格访问，不校验签名
+type s3Stub struct {
+	mutex   sync.Mutex
+	objects map[string]s3StubObject // 键为 "<桶>/<对象键>"
+}
+
+type s3StubObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newS3Stub() *s3Stub {
+	return &s3Stub{objects: make(map[string]s3StubObject)}
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		s.list(w, bucket)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+		object, ok := s.objects[source]
+		if err != nil || !ok {
+			s.notFound(w, r)
+			return
+		}
+		object.modTime = time.Now().UTC().Truncate(time.Second)
+		s.objects[bucket+"/"+key] = object
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, `<CopyObjectResult><LastModified>`+object.modTime.Format(time.RFC3339)+`</LastModified><ETag>"stub"</ETag></CopyObjectResult>`)
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[bucket+"/"+key] = s3StubObject{data: data, modTime: time.Now().UTC().Truncate(time.Second)}
+		w.Header().Set("ETag", `"stub"`)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := s.objects[bucket+"/"+key]
+		if !ok {
+			s.notFound(w, r)
+			return
+		}
+		// Range 和 HEAD 由 http.ServeContent 处理
+		http.ServeContent(w, r, key, object.modTime, bytes.NewReader(object.data))
+	case r.Method == http.MethodDelete:
+		delete(s.objects, bucket+"/"+key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (s *s3Stub) list(w http.ResponseWriter, bucket string) {
+	type content struct {
+		Key          string
+		LastModified string
+		Size         int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Name: bucket}
+
+	for name, object := range s.objects {
+		if key, ok := strings.CutPrefix(name, bucket+"/"); ok {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				LastModified: object.modTime.Format(time.RFC3339),
+				Size:         len(object.data),
+			})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (s *s3Stub) notFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method != http.MethodHead {
+		io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+	}
+}
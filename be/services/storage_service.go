@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+
+	"gorm.io/gorm"
+)
+
+// 存储配置中的密钥字段，只写入不返回
+const storageSecretField = "secretAccessKey"
+
+// 访问凭证配置项，存储中已有录像时只允许修改这些配置
+var storageCredentialFields = []string{"accessKeyId", storageSecretField}
+
+// 连接测试的超时时间
+const storageTestTimeout = 30 * time.Second
+
+// StorageService 管理录像存储目标
+type StorageService struct {
+	db      *gorm.DB
+	storage *VideoStorage
+}
+
+func NewStorageService(db *gorm.DB, storage *VideoStorage) *StorageService {
+	return &StorageService{db: db, storage: storage}
+}
+
+// 获取存储目标列表
+func (s *StorageService) List() ([]models.Storage, error) {
+	var storages []models.Storage
+	if err := s.db.Order("id").Find(&storages).Error; err != nil {
+		return nil, err
+	}
+
+	// 不向前端返回密钥
+	for i := range storages {
+		storages[i].Config = maskStorageSecret(storages[i].Config)
+	}
+	return storages, nil
+}
+
+// GetByID 根据ID获取存储目标
+func (s *StorageService) GetByID(id uint) (*models.Storage, error) {
+	var storage models.Storage
+	if err := s.db.First(&storage, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("storage not found with id: %d", id)
+		}
+		return nil, fmt.Errorf("failed to get storage: %v", err)
+	}
+	return &storage, nil
+}
+
+// 创建存储目标，配置无效时不保存
+func (s *StorageService) Create(storage *models.Storage) error {
+	if storage.Status == 0 {
+		storage.Status = config.StorageStatusEnabled
+	}
+	if _, err := NewStorageDriver(storage); err != nil {
+		return err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if storage.IsDefault {
+			if err := clearDefaultStorage(tx); err != nil {
+				return err
+			}
+		}
+		return tx.Create(storage).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	storage.Config = maskStorageSecret(storage.Config)
+	return nil
+}
+
+// 更新存储目标，配置中未填写密钥时沿用原密钥
+func (s *StorageService) Update(id uint, storage *models.Storage) error {
+	old, err := s.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if storage.Type != old.Type {
+		// 已有录像的对象键依赖存储类型，不允许修改
+		return fmt.Errorf("storage type cannot be changed")
+	}
+
+	storage.Config = keepStorageSecret(storage.Config, old.Config)
+	if storageLocationChanged(storage.Config, old.Config) {
+		// 已有录像时只允许更换访问凭证，修改路径、桶等配置后已有录像将无法访问
+		var count int64
+		if err := s.db.Model(&models.Video{}).Where("storage_id = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("storage is still used by %d videos, only credentials can be changed", count)
+		}
+	}
+	if storage.Status == 0 {
+		storage.Status = old.Status
+	}
+	if _, err := NewStorageDriver(storage); err != nil {
+		return err
+	}
+
+	err = s.db.Model(&models.Storage{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"name":   storage.Name,
+		"config": storage.Config,
+		"status": storage.Status,
+	}).Error
+	if err != nil {
+		return err
+	}
+	s.storage.forget(id)
+
+	storage.ID = id
+	storage.IsDefault = old.IsDefault
+	storage.CreatedAt = old.CreatedAt
+	storage.Config = maskStorageSecret(storage.Config)
+	return nil
+}
+
+// 删除存储目标，仍有录像、车间或生命周期规则使用时不允许删除
+func (s *StorageService) Delete(id uint) error {
+	var count int64
+	if err := s.db.Model(&models.Video{}).Where("storage_id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("storage is still used by %d videos", count)
+	}
+	if err := s.db.Model(&models.Workshop{}).Where("storage_id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("storage is still assigned to %d workshops", count)
+	}
+	if err := s.db.Model(&models.StorageRule{}).Where("source_storage_id = ? OR target_storage_id = ?", id, id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("storage is still used by %d lifecycle rules", count)
+	}
+
+	if err := s.db.Delete(&models.Storage{}, id).Error; err != nil {
+		return err
+	}
+	s.storage.forget(id)
+	return nil
+}
+
+// SetDefault 设置默认存储目标，未指定存储的车间新录像写入默认存储
+func (s *StorageService) SetDefault(id uint) error {
+	storage, err := s.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if storage.Status != config.StorageStatusEnabled {
+		return fmt.Errorf("storage is disabled")
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := clearDefaultStorage(tx); err != nil {
+			return err
+		}
+		return tx.Model(&models.Storage{}).Where("id = ?", id).Update("is_default", true).Error
+	})
+}
+
+// Test 测试存储连接：写入、读取并删除一个测试文件。
+// 测试已保存的存储目标时，配置中未填写的密钥使用已保存的密钥
+func (s *StorageService) Test(ctx context.Context, id uint, storage *models.Storage) error {
+	if id != 0 {
+		old, err := s.GetByID(id)
+		if err != nil {
+			return err
+		}
+		if storage == nil {
+			storage = old
+		} else {
+			storage.Config = keepStorageSecret(storage.Config, old.Config)
+		}
+	}
+
+	driver, err := NewStorageDriver(storage)
+	if err != nil {
+		return err
+	}
+	return testStorageDriver(ctx, driver)
+}
+
+// AssignWorkshop 为车间指定录像存储目标，storageID 为 0 时使用默认存储
+func (s *StorageService) AssignWorkshop(workshopID uint, storageID uint) error {
+	if storageID != 0 {
+		storage, err := s.GetByID(storageID)
+		if err != nil {
+			return err
+		}
+		if storage.Status != config.StorageStatusEnabled {
+			return fmt.Errorf("storage is disabled")
+		}
+	}
+
+	result := s.db.Model(&models.Workshop{}).Where("id = ?", workshopID).Update("storage_id", storageID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		if err := s.db.Model(&models.Workshop{}).Where("id = ?", workshopID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("workshop not found with id: %d", workshopID)
+		}
+	}
+	return nil
+}
+
+func clearDefaultStorage(tx *gorm.DB) error {
+	return tx.Model(&models.Storage{}).Where("is_default = ?", true).Update("is_default", false).Error
+}
+
+func testStorageDriver(ctx context.Context, driver StorageDriver) error {
+	ctx, cancel := context.WithTimeout(ctx, storageTestTimeout)
+	defer cancel()
+
+	file, err := os.CreateTemp("", "idip-storage-test-*")
+	if err != nil {
+		return fmt.Errorf("failed to create test file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	content := []byte("IDIP storage connection test")
+	_, err = file.Write(content)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to create test file: %v", err)
+	}
+
+	key := path.Join(".idip-test", fmt.Sprintf("%d.txt", time.Now().UnixNano()))
+	if err := driver.Put(ctx, key, file.Name()); err != nil {
+		return err
+	}
+	defer driver.Delete(context.Background(), key)
+
+	info, err := driver.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if info.Size != int64(len(content)) {
+		return fmt.Errorf("unexpected test file size: %d", info.Size)
+	}
+
+	body, err := driver.GetRange(ctx, key, 0, 4)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to read test file: %v", err)
+	}
+	if string(data) != string(content[:4]) {
+		return fmt.Errorf("test file content mismatch")
+	}
+	return nil
+}
+
+// maskStorageSecret 去掉配置中的密钥
+func maskStorageSecret(cfg string) string {
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(cfg), &values); err != nil {
+		return cfg
+	}
+	if _, ok := values[storageSecretField]; !ok {
+		return cfg
+	}
+	values[storageSecretField] = ""
+	data, err := json.Marshal(values)
+	if err != nil {
+		return cfg
+	}
+	return string(data)
+}
+
+// keepStorageSecret 新配置未填写密钥时使用原配置中的密钥
+func keepStorageSecret(cfg string, oldCfg string) string {
+	var values, oldValues map[string]interface{}
+	if err := json.Unmarshal([]byte(cfg), &values); err != nil {
+		return cfg
+	}
+	if secret, _ := values[storageSecretField].(string); secret != "" {
+		return cfg
+	}
+	if err := json.Unmarshal([]byte(oldCfg), &oldValues); err != nil {
+		return cfg
+	}
+	secret, ok := oldValues[storageSecretField]
+	if !ok {
+		return cfg
+	}
+	values[storageSecretField] = secret
+	data, err := json.Marshal(values)
+	if err != nil {
+		return cfg
+	}
+	return string(data)
+}
+
+// storageLocationChanged 比较除访问凭证外的配置是否变化，值为空与未填写视为相同
+func storageLocationChanged(cfg string, oldCfg string) bool {
+	var values, oldValues map[string]interface{}
+	if json.Unmarshal([]byte(cfg), &values) != nil || json.Unmarshal([]byte(oldCfg), &oldValues) != nil {
+		return cfg != oldCfg
+	}
+	for _, m := range []map[string]interface{}{values, oldValues} {
+		for _, field := range storageCredentialFields {
+			delete(m, field)
+		}
+		for k, v := range m {
+			if v == nil || v == "" || v == false || v == float64(0) {
+				delete(m, k)
+			}
+		}
+	}
+	return !reflect.DeepEqual(values, oldValues)
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// countingDriver 记录 GetRange 调用次数，检查跳转后是否重新打开
+type countingDriver struct {
+	StorageDriver
+	opens int
+}
+
+func (d *countingDriver) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	d.opens++
+	return d.StorageDriver.GetRange(ctx, key, offset, length)
+}
+
+func TestStorageReaderSeek(t *testing.T) {
+	local := NewLocalStorage(t.TempDir())
+	writeStorageObject(t, local, "a.mp4", "0123456789")
+	driver := &countingDriver{StorageDriver: local}
+	r := newStorageReader(context.Background(), driver, "a.mp4", 10)
+	defer r.Close()
+
+	read := func(t *testing.T, n int) string {
+		t.Helper()
+		buf := make([]byte, n)
+		got, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.Fatalf("Read() error = %v", err)
+		}
+		return string(buf[:got])
+	}
+
+	if got := read(t, 3); got != "012" {
+		t.Errorf("Read() = %q, want %q", got, "012")
+	}
+	// 跳到当前位置不需要重新打开
+	if pos, err := r.Seek(0, io.SeekCurrent); err != nil || pos != 3 {
+		t.Fatalf("Seek(0, SeekCurrent) = %d, %v, want 3", pos, err)
+	}
+	if got := read(t, 2); got != "34" || driver.opens != 1 {
+		t.Errorf("Read() = %q after %d opens, want %q after 1", got, driver.opens, "34")
+	}
+
+	tests := []struct {
+		name    string
+		offset  int64
+		whence  int
+		wantPos int64
+		want    string
+	}{
+		{name: "从开头", offset: 7, whence: io.SeekStart, wantPos: 7, want: "789"},
+		{name: "相对当前位置", offset: -6, whence: io.SeekCurrent, wantPos: 4, want: "456"},
+		{name: "相对末尾", offset: -2, whence: io.SeekEnd, wantPos: 8, want: "89"},
+		{name: "末尾", offset: 0, whence: io.SeekEnd, wantPos: 10, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, err := r.Seek(tt.offset, tt.whence)
+			if err != nil || pos != tt.wantPos {
+				t.Fatalf("Seek(%d, %d) = %d, %v, want %d", tt.offset, tt.whence, pos, err, tt.wantPos)
+			}
+			if got := read(t, 3); got != tt.want {
+				t.Errorf("Read() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek() to negative position should fail")
+	}
+	if _, err := r.Seek(0, 3); err == nil {
+		t.Error("Seek() with invalid whence should fail")
+	}
+}
+
+func TestStorageReaderRange(t *testing.T) {
+	local := NewLocalStorage(t.TempDir())
+	writeStorageObject(t, local, "a.mp4", "0123456789")
+	modTime := time.Date(2024, 1, 5, 8, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		rangeValue string
+		wantStatus int
+		want       string
+	}{
+		{name: "整个文件", wantStatus: http.StatusOK, want: "0123456789"},
+		{name: "中间一段", rangeValue: "bytes=2-5", wantStatus: http.StatusPartialContent, want: "2345"},
+		{name: "从中间到末尾", rangeValue: "bytes=7-", wantStatus: http.StatusPartialContent, want: "789"},
+		{name: "最后几个字节", rangeValue: "bytes=-3", wantStatus: http.StatusPartialContent, want: "789"},
+		{name: "超出末尾", rangeValue: "bytes=20-30", wantStatus: http.StatusRequestedRangeNotSatisfiable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newStorageReader(context.Background(), local, "a.mp4", 10)
+			defer r.Close()
+
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/1/stream", nil)
+			if tt.rangeValue != "" {
+				req.Header.Set("Range", tt.rangeValue)
+			}
+			w := httptest.NewRecorder()
+			http.ServeContent(w, req, "a.mp4", modTime, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.want != "" && w.Body.String() != tt.want {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
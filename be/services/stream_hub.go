@@ -0,0 +1,453 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"videodb/be/config"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/url"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// 最后一个观看者离开后保留拉流的时间，避免刷新页面时反复连接摄像头
+const streamIdleTimeout = 10 * time.Second
+
+// 拉流中断后的重试次数和间隔，重试期间观看者保持最后一帧
+const (
+	streamRetryLimit = 3
+	streamRetryDelay = time.Second
+)
+
+// StreamHub 按摄像头共享拉流，同一路摄像头的所有观看者复用一个 RTSP 连接和转码进程
+type StreamHub struct {
+	config  *config.Config
+	mutex   sync.Mutex
+	sources map[string]*streamSource
+	pending map[string]*pendingStart // 正在连接的拉流
+}
+
+func NewStreamHub(config *config.Config) *StreamHub {
+	return &StreamHub{
+		config:  config,
+		sources: make(map[string]*streamSource),
+		pending: make(map[string]*pendingStart),
+	}
+}
+
+// pendingStart 正在启动的共享拉流（或转发路径、切片），启动期间不持有索引锁，
+// 同时请求同一路的调用方等待启动完成后复用
+type pendingStart struct {
+	ready chan struct{}
+	err   error
+}
+
+func newPendingStart() *pendingStart {
+	return &pendingStart{ready: make(chan struct{})}
+}
+
+// wait 等待启动完成，返回启动失败的原因
+func (p *pendingStart) wait() error {
+	<-p.ready
+	return p.err
+}
+
+// finish 在启动结果登记到索引后调用，唤醒所有等待者
+func (p *pendingStart) finish(err error) {
+	p.err = err
+	close(p.ready)
+}
+
+// StreamSubscription 观看者对某一路流的订阅
+type StreamSubscription struct {
+	id      uint64
+	source  *streamSource
+	onVideo func(*rtp.Packet)
+	onAudio func(*rtp.Packet)
+	done    chan struct{}
+}
+
+// VideoCodec 返回送往观看者的视频编码（转码后始终为 H264）
+func (sub *StreamSubscription) VideoCodec() webrtc.RTPCodecCapability {
+	return sub.source.videoCodec
+}
+
+// AudioCodec 返回送往观看者的音频编码，摄像头没有可用音频时返回 false
+func (sub *StreamSubscription) AudioCodec() (webrtc.RTPCodecCapability, bool) {
+	return sub.source.audioCodec, sub.source.hasAudio
+}
+
+// RTSPTracks 返回送往观看者的 RTP 包对应的 RTSP 轨道描述，用于 RTSP 转发，没有音频时 audio 为 nil
+func (sub *StreamSubscription) RTSPTracks() (video gortsplib.Track, audio gortsplib.Track) {
+	return sub.source.rtspVideo, sub.source.rtspAudio
+}
+
+// Done 在取消订阅或拉流异常结束时关闭
+func (sub *StreamSubscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Close 取消订阅
+func (sub *StreamSubscription) Close() {
+	sub.source.removeSubscriber(sub.id)
+}
+
+// Subscribe 订阅指定 key 的流，key 相同的订阅共享同一路拉流
+func (h *StreamHub) Subscribe(key string, rtspURL string, onVideo, onAudio func(*rtp.Packet)) (*StreamSubscription, error) {
+	for {
+		h.mutex.Lock()
+		if source, ok := h.sources[key]; ok && !source.isClosed() {
+			h.mutex.Unlock()
+			return source.addSubscriber(onVideo, onAudio), nil
+		}
+		if p, ok := h.pending[key]; ok {
+			h.mutex.Unlock()
+			// 其他观看者正在连接同一路摄像头，连接完成后复用
+			if err := p.wait(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		delete(h.sources, key)
+		p := newPendingStart()
+		h.pending[key] = p
+		h.mutex.Unlock()
+
+		// 连接摄像头可能需要数秒，期间不持有锁，避免阻塞其他摄像头的订阅
+		source, err := h.startSource(key, rtspURL)
+
+		h.mutex.Lock()
+		delete(h.pending, key)
+		if err == nil {
+			h.sources[key] = source
+		}
+		h.mutex.Unlock()
+		p.finish(err)
+
+		if err != nil {
+			return nil, err
+		}
+		return source.addSubscriber(onVideo, onAudio), nil
+	}
+}
+
+// FileSourceOptions 录像文件回放参数
+type FileSourceOptions struct {
+	Offset float64 // 起始位置(秒)
+	Rate   float64 // 播放速率，1 为正常速度
+	Audio  bool    // 是否输出音频
+}
+
+// SubscribeFile 以实时速率回放录像文件，回放由单个观看者独占控制，
+// 最后一个订阅取消后立即停止转码进程
+func (h *StreamHub) SubscribeFile(key string, filePath string, opts FileSourceOptions, onVideo, onAudio func(*rtp.Packet)) (*StreamSubscription, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if source, ok := h.sources[key]; ok {
+		source.close()
+		delete(h.sources, key)
+	}
+
+	source := &streamSource{
+		hub:         h,
+		key:         key,
+		videoCodec:  webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264},
+		rtspVideo:   transcodedVideoTrack(),
+		subscribers: make(map[uint64]*StreamSubscription),
+		done:        make(chan struct{}),
+	}
+
+	outputs := []rtpOutput{{
+		args:     videoTranscodeArgs(h.config.RTSP.Transcode, playbackVideoFilters(opts.Rate)...),
+		onPacket: source.broadcastVideo,
+	}}
+	if opts.Audio {
+		source.audioCodec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+		source.hasAudio = true
+		source.rtspAudio = transcodedAudioTrack()
+		outputs = append(outputs, rtpOutput{args: audioTranscodeArgs(), onPacket: source.broadcastAudio})
+	}
+
+	t, err := startRTPTranscoder(h.config.RTSP.FFmpegPath, playbackInputArgs(filePath, opts), outputs...)
+	if err != nil {
+		return nil, err
+	}
+	source.transcoders = append(source.transcoders, t)
+
+	go source.wait()
+	h.sources[key] = source
+
+	return source.addSubscriber(onVideo, onAudio), nil
+}
+
+// 源空闲后从索引中移除
+func (h *StreamHub) removeSource(source *streamSource) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.sources[source.key] == source {
+		delete(h.sources, source.key)
+	}
+}
+
+// streamSource 一路共享拉流
+type streamSource struct {
+	hub        *StreamHub
+	key        string
+	videoCodec webrtc.RTPCodecCapability
+	audioCodec webrtc.RTPCodecCapability
+	hasAudio   bool
+	rtspVideo  gortsplib.Track
+	rtspAudio  gortsplib.Track
+
+	client      *gortsplib.Client
+	transcoders []*rtpTranscoder
+	idleTimeout time.Duration // 为 0 时最后一个订阅取消后立即关闭
+
+	mutex       sync.RWMutex
+	nextID      uint64
+	subscribers map[uint64]*StreamSubscription
+	idleTimer   *time.Timer
+	closed      bool
+	done        chan struct{}
+}
+
+func (h *StreamHub) startSource(key string, rtspURL string) (*streamSource, error) {
+	u, err := url.Parse(rtspURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse RTSP URL: %v", err)
+	}
+
+	source := &streamSource{
+		hub:         h,
+		key:         key,
+		videoCodec:  webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264},
+		idleTimeout: streamIdleTimeout,
+		subscribers: make(map[uint64]*StreamSubscription),
+		done:        make(chan struct{}),
+	}
+
+	// 连接到 RTSP 服务器并获取摄像头提供的轨道
+	client := &gortsplib.Client{}
+	if err := client.Start(u.Scheme, u.Host); err != nil {
+		return nil, fmt.Errorf("failed to connect to RTSP: %v", err)
+	}
+
+	tracks, baseURL, _, err := client.Describe(u)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to describe RTSP stream: %v", err)
+	}
+
+	videoTrack, transcodeVideo := findVideoTrack(tracks)
+	if videoTrack == nil {
+		client.Close()
+		return nil, fmt.Errorf("no supported video track found in RTSP stream")
+	}
+
+	audioTrack := findAudioTrack(tracks)
+	var transcodeAudio bool
+	if audioTrack != nil {
+		source.audioCodec, transcodeAudio, _ = audioCapability(audioTrack)
+		source.hasAudio = true
+		source.rtspAudio = audioTrack
+		if transcodeAudio {
+			source.rtspAudio = transcodedAudioTrack()
+		}
+	}
+	source.rtspVideo = videoTrack
+	if transcodeVideo {
+		source.rtspVideo = transcodedVideoTrack()
+	}
+
+	// 可直接转发的轨道由 RTSP 客户端拉取，轨道ID按 Setup 顺序分配
+	var setupTracks gortsplib.Tracks
+	videoTrackID, audioTrackID := -1, -1
+	if !transcodeVideo {
+		videoTrackID = len(setupTracks)
+		setupTracks = append(setupTracks, videoTrack)
+	}
+	if audioTrack != nil && !transcodeAudio {
+		audioTrackID = len(setupTracks)
+		setupTracks = append(setupTracks, audioTrack)
+	}
+
+	if len(setupTracks) == 0 {
+		client.Close()
+	} else {
+		client.OnPacketRTP = func(ctx *gortsplib.ClientOnPacketRTPCtx) {
+			switch ctx.TrackID {
+			case videoTrackID:
+				source.broadcastVideo(ctx.Packet)
+			case audioTrackID:
+				source.broadcastAudio(ctx.Packet)
+			}
+		}
+		if err := client.SetupAndPlay(setupTracks, baseURL); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to play RTSP stream: %v", err)
+		}
+		source.client = client
+	}
+
+	// 浏览器无法解码的编码通过 FFmpeg 转码
+	ffmpegPath := h.config.RTSP.FFmpegPath
+	if transcodeVideo {
+		t, err := startRTPTranscoder(ffmpegPath, rtspInputArgs(rtspURL),
+			rtpOutput{args: videoTranscodeArgs(h.config.RTSP.Transcode), onPacket: source.broadcastVideo})
+		if err != nil {
+			source.close()
+			return nil, err
+		}
+		source.transcoders = append(source.transcoders, t)
+	}
+	if transcodeAudio {
+		t, err := startRTPTranscoder(ffmpegPath, rtspInputArgs(rtspURL),
+			rtpOutput{args: audioTranscodeArgs(), onPacket: source.broadcastAudio})
+		if err != nil {
+			source.close()
+			return nil, err
+		}
+		source.transcoders = append(source.transcoders, t)
+	}
+
+	go source.wait()
+
+	return source, nil
+}
+
+// 等待拉流或转码进程结束，任意一路结束都视为整路流失效
+func (s *streamSource) wait() {
+	ended := make(chan struct{}, len(s.transcoders)+1)
+	if s.client != nil {
+		go func() {
+			if err := s.client.Wait(); err != nil {
+				fmt.Printf("RTSP stream %s ended: %v\n", s.key, err)
+			}
+			ended <- struct{}{}
+		}()
+	}
+	for _, t := range s.transcoders {
+		t := t
+		go func() {
+			<-t.Done()
+			ended <- struct{}{}
+		}()
+	}
+
+	select {
+	case <-ended:
+	case <-s.done:
+	}
+	s.close()
+	s.hub.removeSource(s)
+}
+
+func (s *streamSource) addSubscriber(onVideo, onAudio func(*rtp.Packet)) *StreamSubscription {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+
+	s.nextID++
+	sub := &StreamSubscription{
+		id:      s.nextID,
+		source:  s,
+		onVideo: onVideo,
+		onAudio: onAudio,
+		done:    make(chan struct{}),
+	}
+	if s.closed {
+		close(sub.done)
+		return sub
+	}
+	s.subscribers[sub.id] = sub
+	return sub
+}
+
+func (s *streamSource) removeSubscriber(id uint64) {
+	s.mutex.Lock()
+	if sub, ok := s.subscribers[id]; ok {
+		close(sub.done)
+		delete(s.subscribers, id)
+	}
+	idle := len(s.subscribers) == 0 && !s.closed
+	if idle && s.idleTimeout > 0 && s.idleTimer == nil {
+		s.idleTimer = time.AfterFunc(s.idleTimeout, s.closeIfIdle)
+	}
+	s.mutex.Unlock()
+
+	if idle && s.idleTimeout == 0 {
+		s.close()
+	}
+}
+
+func (s *streamSource) closeIfIdle() {
+	s.mutex.RLock()
+	idle := len(s.subscribers) == 0
+	s.mutex.RUnlock()
+
+	if idle {
+		s.close()
+	}
+}
+
+func (s *streamSource) broadcastVideo(pkt *rtp.Packet) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, sub := range s.subscribers {
+		if sub.onVideo != nil {
+			sub.onVideo(pkt)
+		}
+	}
+}
+
+func (s *streamSource) broadcastAudio(pkt *rtp.Packet) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, sub := range s.subscribers {
+		if sub.onAudio != nil {
+			sub.onAudio(pkt)
+		}
+	}
+}
+
+func (s *streamSource) isClosed() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.closed
+}
+
+func (s *streamSource) close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	close(s.done)
+	for id, sub := range s.subscribers {
+		close(sub.done)
+		delete(s.subscribers, id)
+	}
+	s.mutex.Unlock()
+
+	if s.client != nil {
+		s.client.Close()
+	}
+	for _, t := range s.transcoders {
+		t.Close()
+	}
+}
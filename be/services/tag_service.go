@@ -0,0 +1,88 @@
+package services
+
+import (
+	"sync"
+	"time"
+	"videodb/be/models"
+
+	"gorm.io/gorm"
+)
+
+// TagSource 工业实时数据来源，实时预览会话通过它获取车间关联数据点的最新值。
+// 默认由 TagService 提供，也可以替换为直接对接 OPC UA 等采集系统的实现。
+type TagSource interface {
+	Latest(workshopID uint) ([]models.TagReading, error)
+}
+
+// TagService 管理车间与数据点的绑定，并在内存中保存采集端推送的最新读数
+type TagService struct {
+	db     *gorm.DB
+	mutex  sync.RWMutex
+	latest map[string]models.TagReading
+}
+
+func NewTagService(db *gorm.DB) *TagService {
+	return &TagService{
+		db:     db,
+		latest: make(map[string]models.TagReading),
+	}
+}
+
+// 获取车间绑定的数据点
+func (s *TagService) ListByWorkshop(workshopID uint) ([]models.WorkshopTag, error) {
+	var tags []models.WorkshopTag
+	err := s.db.Where("workshop_id = ?", workshopID).Order("id").Find(&tags).Error
+	return tags, err
+}
+
+// 为车间绑定数据点
+func (s *TagService) Bind(tag *models.WorkshopTag) error {
+	return s.db.Create(tag).Error
+}
+
+// 解除绑定
+func (s *TagService) Unbind(workshopID uint, id uint) error {
+	return s.db.Where("workshop_id = ?", workshopID).Delete(&models.WorkshopTag{}, id).Error
+}
+
+// Push 写入采集端上报的读数，只保留每个数据点的最新值
+func (s *TagService) Push(readings []models.TagReading) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for _, r := range readings {
+		if r.Timestamp.IsZero() {
+			r.Timestamp = now
+		}
+		if old, ok := s.latest[r.Tag]; ok && old.Timestamp.After(r.Timestamp) {
+			continue
+		}
+		s.latest[r.Tag] = r
+	}
+}
+
+// Latest 返回车间绑定数据点的最新读数，尚未上报的数据点不返回
+func (s *TagService) Latest(workshopID uint) ([]models.TagReading, error) {
+	tags, err := s.ListByWorkshop(workshopID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	readings := make([]models.TagReading, 0, len(tags))
+	for _, t := range tags {
+		r, ok := s.latest[t.Tag]
+		if !ok {
+			continue
+		}
+		r.Name = t.Name
+		if t.Unit != "" {
+			r.Unit = t.Unit
+		}
+		readings = append(readings, r)
+	}
+	return readings, nil
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"videodb/be/config"
+
+	"github.com/aler9/gortsplib"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// 浏览器可直接解码的音频编码，RTP 负载原样转发
+// AAC 等其他编码需要先转码为 Opus
+func audioCapability(track gortsplib.Track) (webrtc.RTPCodecCapability, bool, bool) {
+	switch t := track.(type) {
+	case *gortsplib.TrackOpus:
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, false, true
+	case *gortsplib.TrackG711:
+		if t.MULaw {
+			return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}, false, true
+		}
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000}, false, true
+	case *gortsplib.TrackMPEG4Audio:
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, true, true
+	}
+	return webrtc.RTPCodecCapability{}, false, false
+}
+
+// 查找第一个可以送往浏览器的音频轨道
+func findAudioTrack(tracks gortsplib.Tracks) gortsplib.Track {
+	for _, t := range tracks {
+		if _, _, ok := audioCapability(t); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// 查找视频轨道，H264 可直接转发，其余编码（H265、MJPEG 等）需要转码
+func findVideoTrack(tracks gortsplib.Tracks) (gortsplib.Track, bool) {
+	var fallback gortsplib.Track
+	for _, t := range tracks {
+		switch t.(type) {
+		case *gortsplib.TrackH264:
+			return t, false
+		case *gortsplib.TrackH265, *gortsplib.TrackJPEG, *gortsplib.TrackMPEG2Video, *gortsplib.TrackVP8, *gortsplib.TrackVP9:
+			if fallback == nil {
+				fallback = t
+			}
+		}
+	}
+	return fallback, fallback != nil
+}
+
+// FFmpeg 输出的 RTP 负载类型
+const (
+	transcodedVideoPayloadType = 96
+	transcodedAudioPayloadType = 97
+)
+
+// 转码输出的 H264 视频轨道描述，SPS/PPS 随关键帧带内发送
+func transcodedVideoTrack() gortsplib.Track {
+	return &gortsplib.TrackH264{PayloadType: transcodedVideoPayloadType, PacketizationMode: 1}
+}
+
+// 转码输出的 Opus 音频轨道描述
+func transcodedAudioTrack() gortsplib.Track {
+	return &gortsplib.TrackOpus{PayloadType: transcodedAudioPayloadType, SampleRate: 48000, ChannelCount: 2}
+}
+
+// 判断浏览器的 offer 中是否请求了音频
+func offerWantsAudio(offerSDP string) bool {
+	desc := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}
+	parsed, err := desc.Unmarshal()
+	if err != nil {
+		return strings.Contains(offerSDP, "m=audio")
+	}
+	for _, m := range parsed.MediaDescriptions {
+		if m.MediaName.Media == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+// 拉取摄像头 RTSP 流作为转码输入
+func rtspInputArgs(rtspURL string) []string {
+	return []string{
+		"-rtsp_transport", "tcp",
+		"-i", rtspURL,
+	}
+}
+
+// 音频转码参数：摄像头 AAC 音频转为 Opus
+func audioTranscodeArgs() []string {
+	return []string{
+		"-map", "0:a:0",
+		"-c:a", "libopus",
+		"-ar", "48000",
+		"-ac", "2",
+		"-b:a", "64k",
+		"-payload_type", strconv.Itoa(transcodedAudioPayloadType),
+	}
+}
+
+// 视频转码参数：不支持的编码转为浏览器可解码的 H264 Baseline，
+// 分辨率和码率按配置限制，关键帧携带 SPS/PPS 方便中途加入的观看者解码
+func videoTranscodeArgs(cfg config.TranscodeConfig, filters ...string) []string {
+	preset := cfg.Preset
+	if preset == "" {
+		preset = "veryfast"
+	}
+
+	args := []string{
+		"-map", "0:v:0",
+		"-c:v", "libx264",
+		"-preset", preset,
+		"-tune", "zerolatency",
+		"-profile:v", "baseline",
+		"-pix_fmt", "yuv420p",
+		"-bf", "0",
+		"-g", "50",
+	}
+	if cfg.MaxWidth > 0 && cfg.MaxHeight > 0 {
+		filters = append(filters, fmt.Sprintf(
+			"scale=w='min(%d,iw)':h='min(%d,ih)':force_original_aspect_ratio=decrease:force_divisible_by=2",
+			cfg.MaxWidth, cfg.MaxHeight))
+	}
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	if cfg.MaxBitrate > 0 {
+		args = append(args,
+			"-maxrate", fmt.Sprintf("%dk", cfg.MaxBitrate),
+			"-bufsize", fmt.Sprintf("%dk", cfg.MaxBitrate*2))
+	}
+	return append(args,
+		"-bsf:v", "dump_extra=freq=keyframe",
+		"-payload_type", strconv.Itoa(transcodedVideoPayloadType))
+}
+
+// 录像回放输入参数：从指定位置开始按播放速率读取文件，
+// 非正常速度依赖 FFmpeg 5.0 的 -readrate
+func playbackInputArgs(filePath string, opts FileSourceOptions) []string {
+	args := []string{"-ss", strconv.FormatFloat(opts.Offset, 'f', 3, 64)}
+	if opts.Rate > 0 && opts.Rate != 1 {
+		args = append(args, "-readrate", strconv.FormatFloat(opts.Rate, 'f', 2, 64))
+	} else {
+		args = append(args, "-re")
+	}
+	return append(args, "-i", filePath)
+}
+
+// 倍速回放时按速率压缩视频时间戳
+func playbackVideoFilters(rate float64) []string {
+	if rate <= 0 || rate == 1 {
+		return nil
+	}
+	return []string{fmt.Sprintf("setpts=PTS/%s", strconv.FormatFloat(rate, 'f', 2, 64))}
+}
+
+// rtpOutput FFmpeg 的一路 RTP 输出，args 为该输出的编码参数
+type rtpOutput struct {
+	args     []string
+	onPacket func(*rtp.Packet)
+}
+
+// rtpTranscoder 运行一个 FFmpeg 进程，将其输出的 RTP 包交给回调处理。
+// 一个进程可以有多路输出（如录像回放的音视频），各路输出共享同一时间轴。
+type rtpTranscoder struct {
+	cmd   *exec.Cmd
+	conns []*net.UDPConn
+	done  chan struct{}
+}
+
+func startRTPTranscoder(ffmpegPath string, inputArgs []string, outputs ...rtpOutput) (*rtpTranscoder, error) {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	args := append([]string{"-loglevel", "error"}, inputArgs...)
+
+	t := &rtpTranscoder{done: make(chan struct{})}
+	closeConns := func() {
+		for _, conn := range t.conns {
+			conn.Close()
+		}
+	}
+
+	for _, output := range outputs {
+		// 在本地随机端口接收 FFmpeg 输出的 RTP 包
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		if err != nil {
+			closeConns()
+			return nil, fmt.Errorf("failed to listen for transcoded stream: %v", err)
+		}
+		t.conns = append(t.conns, conn)
+
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		args = append(args, output.args...)
+		args = append(args, "-f", "rtp", fmt.Sprintf("rtp://127.0.0.1:%d?pkt_size=1200", port))
+	}
+
+	t.cmd = exec.Command(ffmpegPath, args...)
+	if err := t.cmd.Start(); err != nil {
+		closeConns()
+		return nil, fmt.Errorf("failed to start transcoder: %v", err)
+	}
+
+	go func() {
+		t.cmd.Wait()
+		close(t.done)
+	}()
+
+	for i, output := range outputs {
+		go readRTP(t.conns[i], output.onPacket)
+	}
+
+	return t, nil
+}
+
+func readRTP(conn *net.UDPConn, onPacket func(*rtp.Packet)) {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		// 复制数据，回调方可能在下一次读取后仍持有负载
+		var pkt rtp.Packet
+		if err := pkt.Unmarshal(append([]byte(nil), buf[:n]...)); err != nil {
+			continue
+		}
+		onPacket(&pkt)
+	}
+}
+
+// Done 在 FFmpeg 进程退出后关闭
+func (t *rtpTranscoder) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *rtpTranscoder) Close() {
+	if t.cmd.Process != nil {
+		t.cmd.Process.Kill()
+	}
+	<-t.done
+	for _, conn := range t.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,401 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+
+	"gorm.io/gorm"
+)
+
+// 截取起点之前该时间（秒）内有关键帧时直接复制视频流，片段从该关键帧开始；
+// 查找关键帧的超时时间；清除过期导出文件的检查间隔
+const (
+	clipKeyFrameTolerance = 2.0
+	clipKeyFrameTimeout   = 30 * time.Second
+	clipCleanInterval     = time.Hour
+)
+
+// 可以直接复制到 MP4 中的视频编码
+var clipCopyCodecs = map[string]bool{"h264": true, "hevc": true}
+
+// ClipService 导出车间录像片段：找出与时间段重叠的录像，逐个截取后拼接为一个文件。
+// 各录像编码相同且截取起点附近有关键帧时直接复制视频流，否则重新编码。
+// 导出任务依次执行，导出的文件保存在临时目录中，到期后删除
+type ClipService struct {
+	db          *gorm.DB
+	storage     *VideoStorage
+	ffmpegPath  string
+	ffprobePath string
+	clipPath    string
+	maxDuration time.Duration
+	expire      time.Duration
+
+	notify chan struct{}
+}
+
+// clipPart 导出片段中的一段录像
+type clipPart struct {
+	video  *models.Video
+	input  string
+	start  float64 // 在录像中的起点（秒）
+	length float64
+}
+
+func NewClipService(cfg *config.Config, db *gorm.DB, storage *VideoStorage) *ClipService {
+	s := &ClipService{
+		db:          db,
+		storage:     storage,
+		ffmpegPath:  cfg.RTSP.FFmpegPath,
+		ffprobePath: cfg.RTSP.FFprobePath,
+		clipPath:    filepath.Join(cfg.Storage.TempPath, "clips"),
+		maxDuration: cfg.Storage.Clip.MaxDuration,
+		expire:      cfg.Storage.Clip.Expire,
+		notify:      make(chan struct{}, 1),
+	}
+	if s.expire <= 0 {
+		s.expire = 24 * time.Hour
+	}
+	// 上次退出时正在导出的任务重新导出
+	if err := db.Model(&models.Clip{}).Where("status = ?", config.ClipStatusRunning).
+		Updates(map[string]interface{}{"status": config.ClipStatusWaiting, "progress": 0}).Error; err != nil {
+		fmt.Printf("Failed to reset interrupted clips: %v\n", err)
+	}
+	go s.loop()
+	return s
+}
+
+// Create 创建导出任务，时间段内没有录像时返回错误
+func (s *ClipService) Create(req *models.ClipRequest, userID uint) (*models.Clip, error) {
+	if s.maxDuration > 0 && req.EndTime.Sub(req.StartTime) > s.maxDuration {
+		return nil, fmt.Errorf("time range exceeds %v", s.maxDuration)
+	}
+	videos, err := s.videos(req.WorkshopID, req.StartTime, req.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	if len(videos) == 0 {
+		return nil, fmt.Errorf("no videos found in the time range")
+	}
+
+	clip := &models.Clip{
+		WorkshopID: req.WorkshopID,
+		StartTime:  req.StartTime,
+		EndTime:    req.EndTime,
+		Status:     config.ClipStatusWaiting,
+		Segments:   len(videos),
+		FileName: fmt.Sprintf("clip_%d_%s_%s.mp4", req.WorkshopID,
+			req.StartTime.Format("20060102_150405"), req.EndTime.Format("20060102_150405")),
+		UserID: userID,
+	}
+	if err := s.db.Create(clip).Error; err != nil {
+		return nil, fmt.Errorf("failed to create clip: %v", err)
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return clip, nil
+}
+
+// List 获取导出任务，userID 为 0 时获取所有用户的任务
+func (s *ClipService) List(userID uint) ([]models.Clip, error) {
+	query := s.db.Preload("Workshop").Order("id DESC")
+	if userID > 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	var clips []models.Clip
+	if err := query.Find(&clips).Error; err != nil {
+		return nil, fmt.Errorf("failed to list clips: %v", err)
+	}
+	for i := range clips {
+		setClipURL(&clips[i])
+	}
+	return clips, nil
+}
+
+// Get 获取导出任务和进度
+func (s *ClipService) Get(id uint) (*models.Clip, error) {
+	var clip models.Clip
+	if err := s.db.Preload("Workshop").First(&clip, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("clip not found with id: %d", id)
+		}
+		return nil, fmt.Errorf("failed to get clip: %v", err)
+	}
+	setClipURL(&clip)
+	return &clip, nil
+}
+
+// Delete 删除导出任务和导出的文件，正在导出的任务不能删除
+func (s *ClipService) Delete(clip *models.Clip) error {
+	result := s.db.Where("id = ? AND status <> ?", clip.ID, config.ClipStatusRunning).Delete(&models.Clip{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete clip: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return utils.ErrInvalidStatus
+	}
+	if err := os.Remove(s.File(clip)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete clip file: %v", err)
+	}
+	return nil
+}
+
+// File 导出文件的本地路径
+func (s *ClipService) File(clip *models.Clip) string {
+	return filepath.Join(s.clipPath, fmt.Sprintf("%d.mp4", clip.ID))
+}
+
+func setClipURL(clip *models.Clip) {
+	if clip.Status == config.ClipStatusCompleted {
+		clip.DownloadURL = fmt.Sprintf("/api/clips/%d/download", clip.ID)
+	}
+}
+
+// videos 与时间段有重叠的车间录像，按开始时间排序
+func (s *ClipService) videos(workshopID uint, start, end time.Time) ([]models.Video, error) {
+	var videos []models.Video
+	err := s.db.Where("workshop_id = ? AND status = ? AND start_time < ? AND end_time > ?",
+		workshopID, config.VideoStatusNormal, end, start).
+		Order("start_time").Find(&videos).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get videos: %v", err)
+	}
+	return videos, nil
+}
+
+// loop 依次执行等待中的导出任务，定期清除过期的导出文件
+func (s *ClipService) loop() {
+	ticker := time.NewTicker(clipCleanInterval)
+	defer ticker.Stop()
+
+	for {
+		s.runPending()
+		select {
+		case <-s.notify:
+		case <-ticker.C:
+			s.cleanup()
+		}
+	}
+}
+
+func (s *ClipService) runPending() {
+	for {
+		var clip models.Clip
+		if err := s.db.Where("status = ?", config.ClipStatusWaiting).Order("id").First(&clip).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				fmt.Printf("Failed to get waiting clips: %v\n", err)
+			}
+			return
+		}
+
+		if err := s.db.Model(&clip).Updates(map[string]interface{}{"status": config.ClipStatusRunning, "progress": 0}).Error; err != nil {
+			fmt.Printf("Failed to start clip %d: %v\n", clip.ID, err)
+			return
+		}
+		if err := s.export(context.Background(), &clip); err != nil {
+			fmt.Printf("Failed to export clip %d: %v\n", clip.ID, err)
+			os.Remove(s.File(&clip))
+			// 失败的任务同样到期后清除
+			expiresAt := time.Now().Add(s.expire)
+			s.db.Model(&clip).Updates(map[string]interface{}{
+				"status":        config.ClipStatusFailed,
+				"error_message": err.Error(),
+				"expires_at":    expiresAt,
+			})
+		}
+	}
+}
+
+// export 截取时间段内的录像并拼接为导出文件
+func (s *ClipService) export(ctx context.Context, clip *models.Clip) error {
+	videos, err := s.videos(clip.WorkshopID, clip.StartTime, clip.EndTime)
+	if err != nil {
+		return err
+	}
+
+	parts := make([]clipPart, 0, len(videos))
+	audio := true
+	for i := range videos {
+		video := &videos[i]
+		from, to := video.StartTime, video.EndTime
+		if clip.StartTime.After(from) {
+			from = clip.StartTime
+		}
+		if clip.EndTime.Before(to) {
+			to = clip.EndTime
+		}
+		if !to.After(from) {
+			continue
+		}
+
+		driver, err := s.storage.VideoDriver(video)
+		if err != nil {
+			return err
+		}
+		input, err := driver.Presign(ctx, video.FilePath, storagePresignExpire)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, clipPart{
+			video:  video,
+			input:  input,
+			start:  from.Sub(video.StartTime).Seconds(),
+			length: to.Sub(from).Seconds(),
+		})
+		// 部分录像没有音频时拼接后音视频不同步，全部去掉音频
+		if video.AudioCodec == "" {
+			audio = false
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("no videos found in the time range")
+	}
+
+	reencode := !s.canCopy(ctx, parts)
+	mode := "copy"
+	if reencode {
+		mode = "reencode"
+	}
+	s.db.Model(clip).Updates(map[string]interface{}{"mode": mode, "segments": len(parts)})
+
+	var total float64
+	for _, part := range parts {
+		total += part.length
+	}
+
+	dir := filepath.Join(s.clipPath, fmt.Sprintf("%d.parts", clip.ID))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create clip directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ffmpeg := utils.NewFFmpeg(s.ffmpegPath)
+	files := make([]string, 0, len(parts))
+	var done float64
+	progress := -1
+	for i, part := range parts {
+		file := filepath.Join(dir, fmt.Sprintf("%03d.mp4", i))
+		err := ffmpeg.CutVideo(ctx, part.input, file, part.start, part.length, utils.CutOptions{
+			Reencode: reencode,
+			Audio:    audio,
+			Progress: func(seconds float64) {
+				// 拼接完成前最多显示 99%
+				percent := int(math.Min((done+math.Min(seconds, part.length))/total*100, 99))
+				if percent != progress {
+					progress = percent
+					s.db.Model(clip).Update("progress", percent)
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to cut video %d: %v", part.video.ID, err)
+		}
+		files = append(files, file)
+		done += part.length
+	}
+
+	output := s.File(clip)
+	if len(files) == 1 {
+		err = os.Rename(files[0], output)
+	} else {
+		err = ffmpeg.ConcatVideos(ctx, files, output)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to concat videos: %v", err)
+	}
+
+	info, err := os.Stat(output)
+	if err != nil {
+		return fmt.Errorf("failed to stat clip file: %v", err)
+	}
+	duration := total
+	if media, err := utils.NewFFprobe(s.ffprobePath).Probe(ctx, output); err == nil && media.Duration > 0 {
+		duration = media.Duration
+	}
+
+	expiresAt := time.Now().Add(s.expire)
+	result := s.db.Model(&models.Clip{}).Where("id = ?", clip.ID).Updates(map[string]interface{}{
+		"status":     config.ClipStatusCompleted,
+		"progress":   100,
+		"file_size":  info.Size(),
+		"duration":   duration,
+		"expires_at": expiresAt,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update clip: %v", result.Error)
+	}
+	// 导出期间任务被删除
+	if result.RowsAffected == 0 {
+		os.Remove(output)
+	}
+	return nil
+}
+
+// canCopy 判断能否直接复制视频流：各录像编码和分辨率相同，且截取起点之前不远处有关键帧。
+// 可以时把各段的起点移到关键帧上
+func (s *ClipService) canCopy(ctx context.Context, parts []clipPart) bool {
+	first := parts[0].video
+	for _, part := range parts {
+		video := part.video
+		if !clipCopyCodecs[video.VideoCodec] || video.VideoCodec != first.VideoCodec ||
+			video.Width != first.Width || video.Height != first.Height {
+			return false
+		}
+	}
+
+	ffprobe := utils.NewFFprobe(s.ffprobePath)
+	starts := make([]float64, len(parts))
+	for i, part := range parts {
+		// 从录像开头截取时起点就是关键帧
+		if part.start == 0 {
+			continue
+		}
+		probeCtx, cancel := context.WithTimeout(ctx, clipKeyFrameTimeout)
+		frames, err := ffprobe.KeyFrames(probeCtx, part.input, part.start, clipKeyFrameTolerance)
+		cancel()
+		if err != nil {
+			fmt.Printf("Failed to probe key frames of video %d: %v\n", part.video.ID, err)
+			return false
+		}
+		key := -1.0
+		for _, frame := range frames {
+			if frame <= part.start && part.start-frame <= clipKeyFrameTolerance && frame > key {
+				key = frame
+			}
+		}
+		if key < 0 {
+			return false
+		}
+		starts[i] = key
+	}
+
+	for i := range parts {
+		parts[i].length += parts[i].start - starts[i]
+		parts[i].start = starts[i]
+	}
+	return true
+}
+
+// cleanup 删除过期的导出任务和文件
+func (s *ClipService) cleanup() {
+	var clips []models.Clip
+	if err := s.db.Where("expires_at < ?", time.Now()).Find(&clips).Error; err != nil {
+		fmt.Printf("Failed to get expired clips: %v\n", err)
+		return
+	}
+	for i := range clips {
+		if err := s.Delete(&clips[i]); err != nil {
+			fmt.Printf("Failed to remove expired clip %d: %v\n", clips[i].ID, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"fmt"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+
+	"gorm.io/gorm"
+)
+
+// 保全变更类型
+const (
+	VideoHoldActionHold    = "hold"
+	VideoHoldActionRelease = "release"
+)
+
+// IsVideoHeld 录像当前是否处于保全期
+func IsVideoHeld(video *models.Video, now time.Time) bool {
+	return video.Protected && (video.HoldUntil == nil || video.HoldUntil.After(now))
+}
+
+// unheldVideos 只查询未保全或保全已到期的录像
+func unheldVideos(now time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("protected = ? OR (hold_until IS NOT NULL AND hold_until <= ?)", false, now)
+	}
+}
+
+// SetHold 保全或解除保全单个录像，并记录变更
+func (s *VideoService) SetHold(id uint, req *models.VideoHoldRequest, operator string) error {
+	if err := validateHold(req); err != nil {
+		return err
+	}
+	if _, err := s.GetByID(id); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return applyHold(tx, []uint{id}, req, operator)
+	})
+}
+
+// HoldRange 按时间段批量保全或解除保全车间录像，返回变更的录像数。
+// overwrite 为 false 时跳过已在保全期的录像，不修改其保全原因和到期时间
+func (s *VideoService) HoldRange(req *models.VideoHoldRangeRequest, operator string, overwrite bool) (int, error) {
+	if err := validateHold(&req.VideoHoldRequest); err != nil {
+		return 0, err
+	}
+
+	query := s.db.Model(&models.Video{}).
+		Where("workshop_id = ? AND status = ? AND start_time < ? AND end_time > ?", req.WorkshopID, config.VideoStatusNormal, req.EndTime, req.StartTime)
+	if !overwrite {
+		query = query.Scopes(unheldVideos(time.Now()))
+	}
+	var ids []uint
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return 0, fmt.Errorf("failed to get videos: %v", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		return applyHold(tx, ids, &req.VideoHoldRequest, operator)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// HoldLogs 获取录像的保全变更记录
+func (s *VideoService) HoldLogs(id uint) ([]models.VideoHoldLog, error) {
+	var logs []models.VideoHoldLog
+	err := s.db.Where("video_id = ?", id).Order("id DESC").Find(&logs).Error
+	return logs, err
+}
+
+func validateHold(req *models.VideoHoldRequest) error {
+	if req.Protected && req.Until != nil && !req.Until.After(time.Now()) {
+		return fmt.Errorf("hold expiry must be in the future")
+	}
+	return nil
+}
+
+// applyHold 更新录像的保全状态并为每个录像写入变更记录
+func applyHold(tx *gorm.DB, ids []uint, req *models.VideoHoldRequest, operator string) error {
+	log := models.VideoHoldLog{
+		Action:   VideoHoldActionRelease,
+		Reason:   req.Reason,
+		Operator: operator,
+	}
+	updates := map[string]interface{}{
+		"protected":   false,
+		"hold_reason": "",
+		"hold_owner":  "",
+		"hold_until":  nil,
+	}
+	if req.Protected {
+		owner := req.Owner
+		if owner == "" {
+			owner = operator
+		}
+		log.Action = VideoHoldActionHold
+		log.Owner = owner
+		log.HoldUntil = req.Until
+		updates = map[string]interface{}{
+			"protected":   true,
+			"hold_reason": req.Reason,
+			"hold_owner":  owner,
+			"hold_until":  req.Until,
+		}
+	}
+
+	if err := tx.Model(&models.Video{}).Where("id IN ?", ids).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update video hold: %v", err)
+	}
+
+	logs := make([]models.VideoHoldLog, len(ids))
+	for i, id := range ids {
+		logs[i] = log
+		logs[i].VideoID = id
+	}
+	if err := tx.Create(&logs).Error; err != nil {
+		return fmt.Errorf("failed to save hold log: %v", err)
+	}
+
+	fmt.Printf("Video hold %s by %s: videos=%v reason=%q\n", log.Action, operator, ids, req.Reason)
+	return nil
+}
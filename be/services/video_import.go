@@ -0,0 +1,383 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+
+	"gorm.io/gorm"
+)
+
+// 导入结果中最多保留的失败文件数
+const importFailureLimit = 100
+
+// 导入时识别的录像文件扩展名
+var importExtensions = map[string]bool{
+	".mp4": true,
+	".mkv": true,
+	".mov": true,
+	".avi": true,
+	".ts":  true,
+	".flv": true,
+}
+
+// 文件名中的开始时间，如 20240105_083000、20240105083000、2024-01-05 08.30.00，
+// 包含开始和结束时间的文件名取第一个时间
+var importTimePattern = regexp.MustCompile(`(20\d{2})[-_.]?(\d{2})[-_.]?(\d{2})[ _T-]?(\d{2})[-_.:]?(\d{2})[-_.:]?(\d{2})`)
+
+// VideoImportService 把目录中已有的录像文件（如旧 NVR 的录像）导入为录像记录，
+// 可以直接引用原文件，也可以复制到车间的存储。命令行和接口共用
+type VideoImportService struct {
+	db          *gorm.DB
+	storage     *VideoStorage
+	ffprobePath string
+	importPaths []string
+	running     int32
+
+	mutex sync.Mutex
+	job   VideoImportJob
+}
+
+// VideoImportJob 最近一次通过接口导入的进度和结果
+type VideoImportJob struct {
+	Running    bool                `json:"running"`
+	Dir        string              `json:"dir"`
+	StartedAt  *time.Time          `json:"startedAt"`
+	FinishedAt *time.Time          `json:"finishedAt"`
+	Imported   int                 `json:"imported"`
+	Skipped    int                 `json:"skipped"` // 之前已导入的文件
+	Failed     int                 `json:"failed"`
+	Failures   []VideoImportResult `json:"failures"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// VideoImportResult 导入单个文件的结果
+type VideoImportResult struct {
+	Path    string `json:"path"`
+	VideoID uint   `json:"videoId,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// videoImport 一次导入任务，目录和车间在开始前校验
+type videoImport struct {
+	root       string
+	req        *models.VideoImportRequest
+	workshops  map[uint]bool
+	workshopID map[string]uint // 一级子目录名到车间ID
+	targets    map[uint]*StorageTarget
+}
+
+func NewVideoImportService(cfg *config.Config, db *gorm.DB, storage *VideoStorage) *VideoImportService {
+	return &VideoImportService{
+		db:          db,
+		storage:     storage,
+		ffprobePath: cfg.RTSP.FFprobePath,
+		importPaths: cfg.Storage.ImportPaths,
+		job:         VideoImportJob{Failures: []VideoImportResult{}},
+	}
+}
+
+// Job 返回最近一次通过接口导入的进度和结果
+func (s *VideoImportService) Job() VideoImportJob {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job := s.job
+	job.Failures = append([]VideoImportResult(nil), s.job.Failures...)
+	return job
+}
+
+// Start 在后台导入目录，目录必须在 storage.import_paths 之内，已在导入时返回错误
+func (s *VideoImportService) Start(req *models.VideoImportRequest) error {
+	imp, err := s.prepare(req)
+	if err != nil {
+		return err
+	}
+	if !s.allowed(imp.root) {
+		return fmt.Errorf("import directory is not allowed: %s", req.Dir)
+	}
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		return fmt.Errorf("import is already running")
+	}
+
+	now := time.Now()
+	s.mutex.Lock()
+	s.job = VideoImportJob{Running: true, Dir: imp.root, StartedAt: &now, Failures: []VideoImportResult{}}
+	s.mutex.Unlock()
+
+	go func() {
+		defer atomic.StoreInt32(&s.running, 0)
+
+		err := s.run(context.Background(), imp, s.record)
+
+		finished := time.Now()
+		s.mutex.Lock()
+		s.job.Running = false
+		s.job.FinishedAt = &finished
+		if err != nil {
+			s.job.Error = err.Error()
+		}
+		job := s.job
+		s.mutex.Unlock()
+
+		fmt.Printf("Video import of %s finished: imported=%d skipped=%d failed=%d\n", job.Dir, job.Imported, job.Skipped, job.Failed)
+	}()
+	return nil
+}
+
+// Run 导入目录中的录像文件，每个文件的结果通过 fn 返回，单个文件失败不影响其他文件
+func (s *VideoImportService) Run(ctx context.Context, req *models.VideoImportRequest, fn func(result VideoImportResult)) error {
+	imp, err := s.prepare(req)
+	if err != nil {
+		return err
+	}
+	return s.run(ctx, imp, fn)
+}
+
+func (s *VideoImportService) record(result VideoImportResult) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case result.Error != "":
+		s.job.Failed++
+		if len(s.job.Failures) < importFailureLimit {
+			s.job.Failures = append(s.job.Failures, result)
+		}
+	case result.Skipped:
+		s.job.Skipped++
+	default:
+		s.job.Imported++
+	}
+}
+
+// allowed 目录是否在允许通过接口导入的目录之内
+func (s *VideoImportService) allowed(root string) bool {
+	for _, base := range s.importPaths {
+		base, err := filepath.Abs(base)
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(base); err == nil {
+			base = resolved
+		}
+		rel, err := filepath.Rel(base, root)
+		if err != nil {
+			continue
+		}
+		if rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepare 校验导入目录和车间
+func (s *VideoImportService) prepare(req *models.VideoImportRequest) (*videoImport, error) {
+	root, err := filepath.Abs(req.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid import directory: %v", err)
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, fmt.Errorf("failed to open import directory: %v", err)
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("import directory is not a directory: %s", req.Dir)
+	}
+
+	var workshops []models.Workshop
+	if err := s.db.Select("id", "name").Find(&workshops).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workshops: %v", err)
+	}
+
+	imp := &videoImport{
+		root:       root,
+		req:        req,
+		workshops:  make(map[uint]bool, len(workshops)),
+		workshopID: make(map[string]uint, len(workshops)+len(req.Mapping)),
+		targets:    make(map[uint]*StorageTarget),
+	}
+	// 子目录默认按车间名称匹配，映射优先
+	for _, workshop := range workshops {
+		imp.workshops[workshop.ID] = true
+		imp.workshopID[workshop.Name] = workshop.ID
+	}
+	for dir, id := range req.Mapping {
+		if !imp.workshops[id] {
+			return nil, fmt.Errorf("workshop not found with id: %d", id)
+		}
+		imp.workshopID[dir] = id
+	}
+	if req.WorkshopID != 0 && !imp.workshops[req.WorkshopID] {
+		return nil, fmt.Errorf("workshop not found with id: %d", req.WorkshopID)
+	}
+	return imp, nil
+}
+
+func (s *VideoImportService) run(ctx context.Context, imp *videoImport, fn func(result VideoImportResult)) error {
+	return filepath.WalkDir(imp.root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if file == imp.root {
+				return err
+			}
+			// 无法读取的子目录跳过
+			fn(VideoImportResult{Path: file, Error: err.Error()})
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// 跳过隐藏文件和目录，以及符号链接等非普通文件
+		if file != imp.root && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || !importExtensions[strings.ToLower(filepath.Ext(file))] {
+			return nil
+		}
+
+		result := VideoImportResult{Path: file}
+		video, err := s.importFile(ctx, imp, file, entry)
+		switch {
+		case err != nil:
+			result.Error = err.Error()
+		case video == nil:
+			result.Skipped = true
+		default:
+			result.VideoID = video.ID
+		}
+		fn(result)
+		return nil
+	})
+}
+
+// importFile 导入单个文件，文件之前已导入时返回 nil
+func (s *VideoImportService) importFile(ctx context.Context, imp *videoImport, file string, entry fs.DirEntry) (*models.Video, error) {
+	stat, err := entry.Info()
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(imp.root, file)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按一级子目录识别车间
+	workshopID := imp.req.WorkshopID
+	if dir, _, ok := strings.Cut(filepath.ToSlash(rel), "/"); ok {
+		if id, ok := imp.workshopID[dir]; ok {
+			workshopID = id
+		}
+	}
+	if workshopID == 0 {
+		return nil, fmt.Errorf("workshop is required")
+	}
+
+	// 直接引用时记录原文件的绝对路径，复制时保存到车间存储的 imports/<车间ID>/ 下
+	source := &reconcileSource{Type: config.StorageTypeLocal}
+	key := file
+	if imp.req.Copy {
+		target, ok := imp.targets[workshopID]
+		if !ok {
+			if target, err = s.storage.Target(workshopID); err != nil {
+				return nil, err
+			}
+			imp.targets[workshopID] = target
+		}
+		source = &reconcileSource{ID: target.ID, Type: target.Type, Driver: target.Driver}
+		key = path.Join("imports", strconv.FormatUint(uint64(workshopID), 10), filepath.ToSlash(rel))
+	}
+
+	var count int64
+	if err := source.videos(s.db.Model(&models.Video{})).Where("file_path = ?", key).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, nil
+	}
+
+	info, err := utils.NewFFprobe(s.ffprobePath).Probe(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	if info.Video() == nil {
+		return nil, utils.ErrInvalidFileType
+	}
+	duration := time.Duration(info.Duration * float64(time.Second))
+
+	// 开始时间优先取文件名中的时间（NVR 按录制时间命名），其次是元数据中的录制时间，
+	// 都没有时按文件修改时间和时长推算
+	startTime, ok := importFileTime(filepath.Base(file))
+	if !ok {
+		startTime = info.CreationTime
+	}
+	if startTime.IsZero() {
+		startTime = stat.ModTime().Add(-duration)
+	}
+
+	if imp.req.Copy {
+		if err := copyToStorage(ctx, source.Driver, key, file); err != nil {
+			return nil, err
+		}
+	}
+
+	video := &models.Video{
+		FileName:   filepath.Base(file),
+		FilePath:   key,
+		Storage:    source.Type,
+		StorageID:  source.ID,
+		FileSize:   stat.Size(),
+		WorkshopID: workshopID,
+		StartTime:  startTime,
+		Status:     config.VideoStatusNormal,
+		External:   !imp.req.Copy,
+		Notes:      "导入: " + file,
+	}
+	applyMediaInfo(video, info)
+	if err := s.db.Create(video).Error; err != nil {
+		if imp.req.Copy {
+			source.Driver.Delete(ctx, key)
+		}
+		return nil, fmt.Errorf("failed to save video: %v", err)
+	}
+	return video, nil
+}
+
+// copyToStorage 复制本地文件到存储，保留原文件
+func copyToStorage(ctx context.Context, driver StorageDriver, key string, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return driver.PutStream(ctx, key, f)
+}
+
+// importFileTime 从文件名中识别开始时间
+func importFileTime(name string) (time.Time, bool) {
+	match := importTimePattern.FindStringSubmatch(name)
+	if match == nil {
+		return time.Time{}, false
+	}
+	value := strings.Join(match[1:], "")
+	t, err := time.ParseInLocation("20060102150405", value, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+)
+
+// 启动后补全媒体信息的等待时间，以及每批读取的录像数
+const (
+	mediaBackfillDelay = 30 * time.Second
+	mediaBackfillBatch = 100
+)
+
+// applyMediaInfo 把 ffprobe 读取的媒体信息写入录像，时长有效时按实际时长修正结束时间
+func applyMediaInfo(video *models.Video, info *utils.MediaInfo) {
+	now := time.Now()
+	video.Format = info.Format
+	video.BitRate = info.BitRate
+	if info.Duration > 0 {
+		video.Duration = info.Duration
+		if !video.StartTime.IsZero() {
+			video.EndTime = video.StartTime.Add(time.Duration(info.Duration * float64(time.Second)))
+		}
+	}
+	if stream := info.Video(); stream != nil {
+		video.VideoCodec = stream.Codec
+		video.Width = stream.Width
+		video.Height = stream.Height
+		video.FrameRate = stream.FrameRate
+	}
+	if stream := info.Audio(); stream != nil {
+		video.AudioCodec = stream.Codec
+	}
+	video.ProbedAt = &now
+}
+
+// probeMedia 读取录像的媒体信息并保存
+func (s *VideoService) probeMedia(ctx context.Context, video *models.Video) error {
+	input, err := s.Source(ctx, video)
+	if err != nil {
+		return err
+	}
+	info, err := utils.NewFFprobe(s.ffprobePath).Probe(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	applyMediaInfo(video, info)
+	return s.db.Model(video).
+		Select("format", "bit_rate", "video_codec", "width", "height", "frame_rate", "audio_codec", "duration", "end_time", "probed_at").
+		Updates(video).Error
+}
+
+// backfillMedia 补全之前没有读取媒体信息的录像，读取失败的录像下次启动时重试
+func (s *VideoService) backfillMedia() {
+	time.Sleep(mediaBackfillDelay)
+
+	ctx := context.Background()
+	var lastID uint
+	probed, failed := 0, 0
+	for {
+		var videos []models.Video
+		err := s.db.Where("id > ? AND status = ? AND probed_at IS NULL", lastID, config.VideoStatusNormal).
+			Order("id").Limit(mediaBackfillBatch).Find(&videos).Error
+		if err != nil {
+			fmt.Printf("Failed to load videos for media backfill: %v\n", err)
+			return
+		}
+		if len(videos) == 0 {
+			break
+		}
+
+		for i := range videos {
+			lastID = videos[i].ID
+			if err := s.probeMedia(ctx, &videos[i]); err != nil {
+				fmt.Printf("Failed to probe video %d: %v\n", videos[i].ID, err)
+				failed++
+				continue
+			}
+			probed++
+		}
+	}
+	if probed > 0 || failed > 0 {
+		fmt.Printf("Media backfill finished: probed=%d failed=%d\n", probed, failed)
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+
+	"gorm.io/gorm"
+)
+
+// 录像在读取后被修改（已删除、已保全等），本次不处理
+var errVideoChanged = errors.New("video has been changed")
+
+// 启动时继续删除的等待时间，避免与启动时的其他数据库操作争用
+const purgeResumeDelay = 10 * time.Second
+
+// VideoDeleteResult 批量删除时单个录像的结果
+type VideoDeleteResult struct {
+	ID      uint   `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// deleteResults 按 ids 的顺序对每个录像执行 del，不存在的录像记为失败
+func deleteResults(ids []uint, videos []models.Video, del func(video *models.Video) error) []VideoDeleteResult {
+	found := make(map[uint]*models.Video, len(videos))
+	for i := range videos {
+		found[videos[i].ID] = &videos[i]
+	}
+
+	results := make([]VideoDeleteResult, 0, len(ids))
+	for _, id := range ids {
+		result := VideoDeleteResult{ID: id}
+		video, ok := found[id]
+		if !ok {
+			result.Error = fmt.Sprintf("video not found with id: %d", id)
+		} else if err := del(video); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Success = true
+		}
+		delete(found, id)
+		results = append(results, result)
+	}
+	return results
+}
+
+// purgeVideo 分两阶段永久删除录像：先把记录标记为删除中，再删除文件，最后删除记录。
+// scopes 为标记时的附加条件，录像已不符合条件时返回 errVideoChanged；
+// 文件删除失败时恢复原状态，进程中途退出时由 resumePurge 继续删除
+func purgeVideo(ctx context.Context, db *gorm.DB, storage *VideoStorage, video *models.Video, scopes ...func(*gorm.DB) *gorm.DB) error {
+	result := db.Model(&models.Video{}).Scopes(scopes...).
+		Where("id = ? AND status = ?", video.ID, video.Status).
+		Update("status", config.VideoStatusPurging)
+	if result.Error != nil {
+		return fmt.Errorf("failed to mark video: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errVideoChanged
+	}
+
+	return finishPurge(ctx, db, storage, video, video.Status)
+}
+
+// finishPurge 删除已标记录像的文件和记录，文件删除失败时恢复为 status
+func finishPurge(ctx context.Context, db *gorm.DB, storage *VideoStorage, video *models.Video, status int) error {
+	if err := deleteVideoFiles(ctx, storage, video); err != nil {
+		db.Model(&models.Video{}).Where("id = ? AND status = ?", video.ID, config.VideoStatusPurging).Update("status", status)
+		return err
+	}
+	return db.Where("status = ?", config.VideoStatusPurging).Delete(&models.Video{}, video.ID).Error
+}
+
+// deleteVideoFiles 删除录像文件及封面图、预览图。回收站中的录像删除回收站中的文件，
+// 同时删除原位置，移入回收站中途退出时文件可能仍在原位置
+func deleteVideoFiles(ctx context.Context, storage *VideoStorage, video *models.Video) error {
+	driver, err := storage.VideoDriver(video)
+	if err != nil {
+		return err
+	}
+	// 先删除封面图，录像文件删除失败时记录保留，封面图可以重新生成
+	if err := deleteThumbnails(ctx, driver, video); err != nil {
+		return err
+	}
+	// 外部文件只读，只删除记录
+	if video.External {
+		return nil
+	}
+	if video.TrashedAt != nil && video.TrashPath != "" {
+		if err := driver.Delete(ctx, video.TrashPath); err != nil {
+			return err
+		}
+	}
+	if video.FilePath == "" {
+		return nil
+	}
+	return driver.Delete(ctx, video.FilePath)
+}
+
+// resumePurge 继续删除上次退出时标记为删除中的录像
+func (s *VideoService) resumePurge() {
+	time.Sleep(purgeResumeDelay)
+
+	var videos []models.Video
+	if err := s.db.Where("status = ?", config.VideoStatusPurging).Find(&videos).Error; err != nil {
+		fmt.Printf("Failed to load purging videos: %v\n", err)
+		return
+	}
+
+	for i := range videos {
+		// 删除失败时按是否在回收站恢复原状态
+		status := config.VideoStatusNormal
+		if videos[i].TrashedAt != nil {
+			status = config.VideoStatusDeleted
+		}
+		if err := finishPurge(context.Background(), s.db, s.storage, &videos[i], status); err != nil {
+			fmt.Printf("Failed to resume purging video %d: %v\n", videos[i].ID, err)
+		}
+	}
+	if len(videos) > 0 {
+		fmt.Printf("Resumed purging %d videos\n", len(videos))
+	}
+}
@@ -1,19 +1,27 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
 	"time"
 	"videodb/be/config"
 	"videodb/be/models"
+	"videodb/be/utils"
 
 	"gorm.io/gorm"
 )
 
+// 录像列表中显示的状态，文件丢失的录像也显示，以便查看和删除
+var listedVideoStatuses = []int{config.VideoStatusNormal, config.VideoStatusMissing}
+
 type VideoService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	storage     *VideoStorage
+	trashDays   int
+	ffmpegPath  string
+	ffprobePath string
 }
 
 type VideoQuery struct {
@@ -23,10 +31,26 @@ type VideoQuery struct {
 	Page       int
 	PageSize   int
 	Preload    []string
+	Deleted    bool   // 查询回收站中的录像
+	Workshops  []uint // 不为 nil 时只查询这些车间的录像，用于限制普通用户的查询范围
 }
 
-func NewVideoService(db *gorm.DB) *VideoService {
-	return &VideoService{db: db}
+// VideoAccessCheck 检查当前用户能否操作录像，返回错误时不操作该录像
+type VideoAccessCheck func(video *models.Video) error
+
+func NewVideoService(cfg *config.Config, db *gorm.DB, storage *VideoStorage) *VideoService {
+	s := &VideoService{
+		db:          db,
+		storage:     storage,
+		trashDays:   cfg.Storage.TrashDays,
+		ffmpegPath:  cfg.RTSP.FFmpegPath,
+		ffprobePath: cfg.RTSP.FFprobePath,
+	}
+	// 继续上次退出时未完成的删除
+	go s.resumePurge()
+	// 补全旧录像的媒体信息
+	go s.backfillMedia()
+	return s
 }
 
 // 获取视频列表
@@ -39,9 +63,19 @@ func (s *VideoService) List(query VideoQuery) ([]models.Video, int64, error) {
 	}
 
 	// 添加查询条件
+	order := "created_at DESC"
+	if query.Deleted {
+		db = db.Where("status = ?", config.VideoStatusDeleted)
+		order = "trashed_at DESC"
+	} else {
+		db = db.Where("status IN ?", listedVideoStatuses)
+	}
 	if query.WorkshopID > 0 {
 		db = db.Where("workshop_id = ?", query.WorkshopID)
 	}
+	if query.Workshops != nil {
+		db = db.Where("workshop_id IN ?", query.Workshops)
+	}
 	if !query.StartTime.IsZero() {
 		db = db.Where("start_time >= ?", query.StartTime)
 	}
@@ -59,7 +93,7 @@ func (s *VideoService) List(query VideoQuery) ([]models.Video, int64, error) {
 	var videos []models.Video
 	err := db.Offset((query.Page - 1) * query.PageSize).
 		Limit(query.PageSize).
-		Order("created_at DESC").
+		Order(order).
 		Find(&videos).Error
 
 	return videos, total, err
@@ -78,47 +112,24 @@ func (s *VideoService) Update(id uint, video *models.Video) error {
 	return s.db.Model(&models.Video{}).Where("id = ?", id).Updates(video).Error
 }
 
-// 删除视频记录
-func (s *VideoService) Delete(id uint) error {
+// 删除视频记录，启用回收站时移入回收站
+func (s *VideoService) Delete(id uint, check VideoAccessCheck) error {
 	// 先获取视频信息
 	var video models.Video
-	if err := s.db.First(&video, id).Error; err != nil {
+	if err := s.db.Where("status IN ?", listedVideoStatuses).First(&video, id).Error; err != nil {
 		return err
 	}
-
-	// 删除物理文件
-	if err := os.Remove(video.FilePath); err != nil && !os.IsNotExist(err) {
+	if err := check(&video); err != nil {
 		return err
 	}
-
-	// 删除数据库记录
-	return s.db.Delete(&video).Error
-}
-
-// 清理过期视频
-func (s *VideoService) CleanExpiredVideos() error {
-	// 设置过期时间，例如30天
-	expireTime := time.Now().AddDate(0, 0, -30)
-
-	var videos []models.Video
-	if err := s.db.Where("create_time < ?", expireTime).Find(&videos).Error; err != nil {
-		return err
+	if IsVideoHeld(&video, time.Now()) {
+		return utils.ErrVideoHeld
 	}
-
-	for _, video := range videos {
-		if err := s.Delete(video.ID); err != nil {
-			return err
-		}
+	// 文件已丢失的录像直接删除记录
+	if s.trashDays > 0 && video.Status == config.VideoStatusNormal {
+		return s.trash(&video)
 	}
-
-	return nil
-}
-
-// 生成视频存储路径
-func (s *VideoService) GenerateVideoPath(workshopID uint) string {
-	now := time.Now()
-	fileName := fmt.Sprintf("%d_%s.mp4", workshopID, now.Format("20060102150405"))
-	return filepath.Join(config.GlobalConfig.Storage.VideoPath, fileName)
+	return purgeVideo(context.Background(), s.db, s.storage, &video, unheldVideos(time.Now()))
 }
 
 // GetByID 根据ID获取视频信息
@@ -126,7 +137,7 @@ func (s *VideoService) GetByID(id uint) (*models.Video, error) {
 	var video models.Video
 
 	// 使用GORM查询数据库，同时预加载关联的Workshop信息
-	err := s.db.Preload("Workshop").First(&video, id).Error
+	err := s.db.Preload("Workshop").Where("status IN ?", listedVideoStatuses).First(&video, id).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, fmt.Errorf("video not found with id: %d", id)
@@ -137,30 +148,50 @@ func (s *VideoService) GetByID(id uint) (*models.Video, error) {
 	return &video, nil
 }
 
-// BatchDelete 批量删除视频
-func (s *VideoService) BatchDelete(ids []uint) error {
+// BatchDelete 批量删除视频，启用回收站时移入回收站。
+// 每个录像单独删除，返回每个录像的删除结果，部分失败不影响其他录像
+func (s *VideoService) BatchDelete(ids []uint, check VideoAccessCheck) ([]VideoDeleteResult, error) {
 	// 先获取所有要删除的视频信息
 	var videos []models.Video
-	if err := s.db.Where("id IN ?", ids).Find(&videos).Error; err != nil {
-		return err
+	if err := s.db.Where("id IN ? AND status IN ?", ids, listedVideoStatuses).Find(&videos).Error; err != nil {
+		return nil, err
 	}
 
-	// 开启事务
-	return s.db.Transaction(func(tx *gorm.DB) error {
-		// 删除数据库记录
-		if err := tx.Delete(&models.Video{}, "id IN ?", ids).Error; err != nil {
+	ctx := context.Background()
+	now := time.Now()
+	return deleteResults(ids, videos, func(video *models.Video) error {
+		if err := check(video); err != nil {
 			return err
 		}
-
-		// 删除物理文件
-		for _, video := range videos {
-			if video.FilePath != "" {
-				if err := os.Remove(video.FilePath); err != nil && !os.IsNotExist(err) {
-					return fmt.Errorf("删除文件失败: %v", err)
-				}
-			}
+		// 保全期内的录像不删除
+		if IsVideoHeld(video, now) {
+			return utils.ErrVideoHeld
 		}
+		if s.trashDays > 0 && video.Status == config.VideoStatusNormal {
+			return s.trash(video)
+		}
+		return purgeVideo(ctx, s.db, s.storage, video, unheldVideos(now))
+	}), nil
+}
 
-		return nil
-	})
+// Source 返回 FFmpeg 可直接读取的录像地址（本地路径或预签名 URL）
+func (s *VideoService) Source(ctx context.Context, video *models.Video) (string, error) {
+	driver, err := s.storage.VideoDriver(video)
+	if err != nil {
+		return "", err
+	}
+	return driver.Presign(ctx, video.FilePath, storagePresignExpire)
+}
+
+// Open 打开录像文件，返回支持跳转的读取器及文件信息，用于下载和在线播放
+func (s *VideoService) Open(ctx context.Context, video *models.Video) (io.ReadSeekCloser, *StorageObject, error) {
+	driver, err := s.storage.VideoDriver(video)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := driver.Stat(ctx, video.FilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newStorageReader(ctx, driver, video.FilePath, info.Size), info, nil
 }
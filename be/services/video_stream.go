@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"videodb/be/models"
+	"videodb/be/utils"
+)
+
+// 查找 moov 时最多读取的顶层 box 数
+const mp4MaxTopBoxes = 32
+
+// Progressive 判断录像能否直接边下载边播放，即 MP4 的 moov 在 mdat 之前。
+// 非 MP4 容器返回 false
+func (s *VideoService) Progressive(ctx context.Context, video *models.Video) (bool, error) {
+	switch strings.ToLower(path.Ext(video.FilePath)) {
+	case ".mp4", ".m4v", ".mov":
+	default:
+		return false, nil
+	}
+
+	driver, err := s.storage.VideoDriver(video)
+	if err != nil {
+		return false, err
+	}
+	info, err := driver.Stat(ctx, video.FilePath)
+	if err != nil {
+		return false, err
+	}
+	return mp4MoovFirst(ctx, driver, video.FilePath, info.Size)
+}
+
+// StreamFragmented 把录像从 start（秒）处实时转封装为分片 MP4 写入 w
+func (s *VideoService) StreamFragmented(ctx context.Context, video *models.Video, start float64, w io.Writer) error {
+	input, err := s.Source(ctx, video)
+	if err != nil {
+		return err
+	}
+	return utils.NewFFmpeg(s.ffmpegPath).RemuxFragmented(ctx, input, start, w)
+}
+
+// mp4MoovFirst 依次读取顶层 box 的头部，moov 在 mdat 之前时返回 true
+func mp4MoovFirst(ctx context.Context, driver StorageDriver, key string, size int64) (bool, error) {
+	var offset int64
+	for i := 0; i < mp4MaxTopBoxes && offset+8 <= size; i++ {
+		header, err := readRange(ctx, driver, key, offset, 16)
+		if err != nil {
+			return false, err
+		}
+		if len(header) < 8 {
+			return false, nil
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		switch string(header[4:8]) {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+
+		// size 为 1 时使用 64 位长度，为 0 时延伸到文件末尾
+		switch boxSize {
+		case 0:
+			return false, nil
+		case 1:
+			if len(header) < 16 {
+				return false, nil
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if boxSize < 8 {
+			return false, fmt.Errorf("invalid mp4 box size at %d", offset)
+		}
+		offset += boxSize
+	}
+	return false, nil
+}
+
+// readRange 读取 offset 开始的最多 length 字节
+func readRange(ctx context.Context, driver StorageDriver, key string, offset, length int64) ([]byte, error) {
+	body, err := driver.GetRange(ctx, key, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	buf := make([]byte, length)
+	n, err := io.ReadFull(body, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
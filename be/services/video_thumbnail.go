@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+
+	"gorm.io/gorm"
+)
+
+// 封面图宽度，预览图尺寸和拼图列数，每个录像最多生成的预览图数
+const (
+	thumbnailWidth   = 320
+	spriteWidth      = 160
+	spriteHeight     = 90
+	spriteColumns    = 10
+	spriteMaxCount   = 100
+	thumbnailTimeout = 10 * time.Minute
+)
+
+// 检查需要生成封面图的录像的间隔，以及每批读取的录像数
+const (
+	thumbnailScanInterval = time.Minute
+	thumbnailBatch        = 20
+)
+
+// ThumbnailService 为录像生成封面图和拖动预览图（拼成一张图片，配合 WebVTT 轨道使用），
+// 与录像保存在同一存储中。定期检查还没有封面图的录像，新录像和旧录像都会生成
+type ThumbnailService struct {
+	db             *gorm.DB
+	storage        *VideoStorage
+	ffmpegPath     string
+	tempPath       string
+	spriteInterval float64
+
+	failed map[uint]bool // 生成失败的录像，重启前不再重试
+}
+
+func NewThumbnailService(cfg *config.Config, db *gorm.DB, storage *VideoStorage) *ThumbnailService {
+	s := &ThumbnailService{
+		db:             db,
+		storage:        storage,
+		ffmpegPath:     cfg.RTSP.FFmpegPath,
+		tempPath:       cfg.Storage.TempPath,
+		spriteInterval: cfg.Storage.Thumbnail.SpriteInterval,
+		failed:         make(map[uint]bool),
+	}
+	if s.spriteInterval <= 0 {
+		s.spriteInterval = 10
+	}
+	if cfg.Storage.Thumbnail.Enabled {
+		go s.loop()
+	}
+	return s
+}
+
+// Open 打开录像的封面图或预览图
+func (s *ThumbnailService) Open(ctx context.Context, video *models.Video, key string) (io.ReadSeekCloser, *StorageObject, error) {
+	if key == "" {
+		return nil, nil, utils.ErrFileNotFound
+	}
+	driver, err := s.storage.VideoDriver(video)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := driver.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newStorageReader(ctx, driver, key, info.Size), info, nil
+}
+
+// SpriteVTT 生成拖动预览图的 WebVTT 轨道，spriteURL 为拼图地址
+func SpriteVTT(video *models.Video, spriteURL string) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for i := 0; i < video.SpriteCount; i++ {
+		start := float64(i) * video.SpriteInterval
+		end := math.Min(start+video.SpriteInterval, video.Duration)
+		x := (i % video.SpriteColumns) * spriteWidth
+		y := (i / video.SpriteColumns) * spriteHeight
+		fmt.Fprintf(&b, "%s --> %s\n%s#xywh=%d,%d,%d,%d\n\n", vttTime(start), vttTime(end), spriteURL, x, y, spriteWidth, spriteHeight)
+	}
+	return b.String()
+}
+
+func vttTime(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, d.Milliseconds()%1000)
+}
+
+func (s *ThumbnailService) loop() {
+	ticker := time.NewTicker(thumbnailScanInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.run(context.Background())
+	}
+}
+
+// run 为还没有封面图的录像生成封面图和预览图，新录像优先
+func (s *ThumbnailService) run(ctx context.Context) {
+	var lastID uint
+	for {
+		query := s.db.Where("status = ? AND COALESCE(thumbnail_path, '') = '' AND duration > 0", config.VideoStatusNormal)
+		if lastID > 0 {
+			query = query.Where("id < ?", lastID)
+		}
+		var videos []models.Video
+		if err := query.Order("id DESC").Limit(thumbnailBatch).Find(&videos).Error; err != nil {
+			fmt.Printf("Failed to load videos for thumbnails: %v\n", err)
+			return
+		}
+		if len(videos) == 0 {
+			return
+		}
+
+		for i := range videos {
+			lastID = videos[i].ID
+			if s.failed[videos[i].ID] {
+				continue
+			}
+			if err := s.generate(ctx, &videos[i]); err != nil {
+				fmt.Printf("Failed to generate thumbnails for video %d: %v\n", videos[i].ID, err)
+				s.failed[videos[i].ID] = true
+			}
+		}
+	}
+}
+
+// generate 生成录像的封面图和预览图并保存到录像所在的存储
+func (s *ThumbnailService) generate(ctx context.Context, video *models.Video) error {
+	ctx, cancel := context.WithTimeout(ctx, thumbnailTimeout)
+	defer cancel()
+
+	driver, err := s.storage.VideoDriver(video)
+	if err != nil {
+		return err
+	}
+	input, err := driver.Presign(ctx, video.FilePath, storagePresignExpire)
+	if err != nil {
+		return err
+	}
+
+	if err := utils.EnsureDir(s.tempPath); err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	dir, err := os.MkdirTemp(s.tempPath, "thumbnail-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 封面图取开头附近的画面，跳过片头可能的黑屏
+	ffmpeg := utils.NewFFmpeg(s.ffmpegPath)
+	poster := filepath.Join(dir, "thumb.jpg")
+	if err := ffmpeg.GenerateThumbnail(ctx, input, poster, math.Min(video.Duration/10, 5), thumbnailWidth); err != nil {
+		return fmt.Errorf("failed to generate thumbnail: %v", err)
+	}
+
+	// 录像较长时加大间隔，预览图数量不超过 spriteMaxCount
+	interval := math.Max(s.spriteInterval, video.Duration/spriteMaxCount)
+	count := int(math.Ceil(video.Duration / interval))
+	columns := spriteColumns
+	if count < columns {
+		columns = count
+	}
+	rows := (count + columns - 1) / columns
+	sprite := filepath.Join(dir, "sprite.jpg")
+	if err := ffmpeg.GenerateSprite(ctx, input, sprite, interval, spriteWidth, spriteHeight, columns, rows); err != nil {
+		return fmt.Errorf("failed to generate sprite: %v", err)
+	}
+
+	thumbKey, spriteKey := thumbnailKeys(video.FilePath, video.ID)
+	if err := driver.Put(ctx, thumbKey, poster); err != nil {
+		return err
+	}
+	if err := driver.Put(ctx, spriteKey, sprite); err != nil {
+		driver.Delete(context.Background(), thumbKey)
+		return err
+	}
+
+	// 生成期间录像被删除或迁移到其他存储时丢弃
+	result := s.db.Model(&models.Video{}).
+		Where("id = ? AND storage_id = ? AND file_path = ? AND status = ?", video.ID, video.StorageID, video.FilePath, config.VideoStatusNormal).
+		Updates(map[string]interface{}{
+			"thumbnail_path":  thumbKey,
+			"sprite_path":     spriteKey,
+			"sprite_interval": interval,
+			"sprite_columns":  columns,
+			"sprite_count":    count,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		driver.Delete(context.Background(), thumbKey)
+		driver.Delete(context.Background(), spriteKey)
+		return result.Error
+	}
+	return nil
+}
+
+// thumbnailKeys 封面图和预览图的对象键，与录像文件放在一起。
+// 旧录像和直接引用的导入录像为绝对路径，可能不在存储目录内，放到存储的 thumbnails 目录下
+func thumbnailKeys(filePath string, id uint) (string, string) {
+	base := strings.TrimSuffix(filePath, path.Ext(filePath))
+	if filepath.IsAbs(filePath) {
+		base = path.Join("thumbnails", strconv.FormatUint(uint64(id), 10), "video")
+	}
+	return base + ".thumb.jpg", base + ".sprite.jpg"
+}
+
+// copyThumbnails 迁移录像时把封面图和预览图复制到目标存储中新位置旁边，返回要更新的字段，
+// 复制失败时清空，之后重新生成
+func copyThumbnails(ctx context.Context, video *models.Video, src, dst StorageDriver, key string) map[string]interface{} {
+	updates := map[string]interface{}{"thumbnail_path": "", "sprite_path": ""}
+	if video.ThumbnailPath == "" || video.SpritePath == "" {
+		return updates
+	}
+
+	thumbKey, spriteKey := thumbnailKeys(key, video.ID)
+	if err := copyStoredObject(ctx, src, video.ThumbnailPath, dst, thumbKey); err != nil {
+		return updates
+	}
+	if err := copyStoredObject(ctx, src, video.SpritePath, dst, spriteKey); err != nil {
+		dst.Delete(context.Background(), thumbKey)
+		return updates
+	}
+	updates["thumbnail_path"] = thumbKey
+	updates["sprite_path"] = spriteKey
+	return updates
+}
+
+// deleteThumbnails 删除录像的封面图和预览图
+func deleteThumbnails(ctx context.Context, driver StorageDriver, video *models.Video) error {
+	for _, key := range []string{video.ThumbnailPath, video.SpritePath} {
+		if key == "" {
+			continue
+		}
+		if err := driver.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyStoredObject(ctx context.Context, src StorageDriver, from string, dst StorageDriver, to string) error {
+	body, err := src.GetRange(ctx, from, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return dst.PutStream(ctx, to, body)
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+
+	"gorm.io/gorm"
+)
+
+// 相邻录像间隔不超过该值时视为连续；连续回放的最长时间段
+const (
+	timelineGapTolerance = time.Second
+	playbackMaxRange     = 24 * time.Hour
+)
+
+// 连续回放切片的目标时长（秒），切片按固定时长划分，请求切片时在分界点之前最近的关键帧处分割；
+// 查找分界点关键帧的超时时间，以及录像末尾视为到达文件结尾的误差（秒）
+const (
+	playbackSegmentTarget = 6.0
+	playbackProbeTimeout  = 30 * time.Second
+	playbackEndTolerance  = 0.5
+)
+
+// TimelineService 车间录像时间轴和连续回放。连续回放把时间段内的录像组成一个 HLS 点播播放列表，
+// 各录像按固定时长分为若干切片，请求切片时只查找分界点附近的关键帧，从录像中直接复制流封装为 MPEG-TS，
+// 不需要读取整个录像、转码和临时文件
+type TimelineService struct {
+	db          *gorm.DB
+	storage     *VideoStorage
+	ffmpegPath  string
+	ffprobePath string
+}
+
+// playbackPart 连续回放中的一段录像，重叠的录像从前一个录像结束处开始
+type playbackPart struct {
+	video *models.Video
+	start float64 // 在录像中的起止位置（秒）
+	end   float64
+}
+
+func NewTimelineService(cfg *config.Config, db *gorm.DB, storage *VideoStorage) *TimelineService {
+	return &TimelineService{
+		db:          db,
+		storage:     storage,
+		ffmpegPath:  cfg.RTSP.FFmpegPath,
+		ffprobePath: cfg.RTSP.FFprobePath,
+	}
+}
+
+// Timeline 返回车间在时间段内有录像和没有录像的时间段，以及各录像的起止时间
+func (s *TimelineService) Timeline(workshopID uint, from, to time.Time) (*models.Timeline, error) {
+	videos, err := s.videos(workshopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	timeline := &models.Timeline{
+		WorkshopID: workshopID,
+		From:       from,
+		To:         to,
+		Covered:    []models.TimeRange{},
+		Gaps:       []models.TimeRange{},
+		Segments:   make([]models.TimelineSegment, 0, len(videos)),
+	}
+	for _, video := range videos {
+		timeline.Segments = append(timeline.Segments, models.TimelineSegment{
+			VideoID:   video.ID,
+			FileName:  video.FileName,
+			StartTime: video.StartTime,
+			EndTime:   video.EndTime,
+			Duration:  video.Duration,
+		})
+
+		start, end := video.StartTime, video.EndTime
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if n := len(timeline.Covered); n > 0 && !start.After(timeline.Covered[n-1].End.Add(timelineGapTolerance)) {
+			if end.After(timeline.Covered[n-1].End) {
+				timeline.Covered[n-1].End = end
+			}
+			continue
+		}
+		timeline.Covered = append(timeline.Covered, models.TimeRange{Start: start, End: end})
+	}
+
+	cursor := from
+	var covered time.Duration
+	for _, r := range timeline.Covered {
+		if r.Start.After(cursor) {
+			timeline.Gaps = append(timeline.Gaps, models.TimeRange{Start: cursor, End: r.Start})
+		}
+		covered += r.End.Sub(r.Start)
+		cursor = r.End
+	}
+	if to.After(cursor) {
+		timeline.Gaps = append(timeline.Gaps, models.TimeRange{Start: cursor, End: to})
+	}
+	timeline.Coverage = math.Min(covered.Seconds()/to.Sub(from).Seconds(), 1)
+	return timeline, nil
+}
+
+// Playlist 生成时间段内录像的 HLS 点播播放列表，没有录像的时间段被跳过，
+// 每个录像开始处标记不连续和录制时间。token 会附加到切片地址上，供无法设置请求头的播放器继续鉴权
+func (s *TimelineService) Playlist(ctx context.Context, workshopID uint, from, to time.Time, token string) ([]byte, error) {
+	if to.Sub(from) > playbackMaxRange {
+		return nil, fmt.Errorf("time range exceeds %v", playbackMaxRange)
+	}
+	videos, err := s.videos(workshopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]playbackPart, 0, len(videos))
+	cursor := from
+	for i := range videos {
+		video := &videos[i]
+		start, end := video.StartTime, video.EndTime
+		if start.Before(cursor) {
+			start = cursor
+		}
+		if end.After(to) {
+			end = to
+		}
+		// 与前一个录像重叠后剩余很短时跳过
+		if end.Sub(start) < 100*time.Millisecond {
+			continue
+		}
+		parts = append(parts, playbackPart{
+			video: video,
+			start: start.Sub(video.StartTime).Seconds(),
+			end:   end.Sub(video.StartTime).Seconds(),
+		})
+		cursor = end
+	}
+
+	query := ""
+	if token != "" {
+		query = "?token=" + url.QueryEscape(token)
+	}
+	var body strings.Builder
+	targetDuration := 1.0
+	for i, part := range parts {
+		if i > 0 {
+			body.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		date := part.video.StartTime.Add(time.Duration(part.start * float64(time.Second)))
+		fmt.Fprintf(&body, "#EXT-X-PROGRAM-DATE-TIME:%s\n", date.Format("2006-01-02T15:04:05.000Z07:00"))
+
+		for _, seg := range splitPlayback(part.start, part.end) {
+			duration := float64(seg[1]-seg[0]) / 1000
+			targetDuration = math.Max(targetDuration, duration)
+			fmt.Fprintf(&body, "#EXTINF:%.3f,\nplayback/%d/%d_%d.ts%s\n", duration, part.video.ID, seg[0], seg[1]-seg[0], query)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no videos found in the time range")
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(targetDuration)))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString(body.String())
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return []byte(b.String()), nil
+}
+
+// Segment 把连续回放切片写入 w，name 为播放列表中的 "<起点毫秒>_<时长毫秒>.ts"
+func (s *TimelineService) Segment(ctx context.Context, workshopID, videoID uint, name string, w io.Writer) error {
+	startMs, durationMs, ok := strings.Cut(strings.TrimSuffix(name, ".ts"), "_")
+	if !ok || !strings.HasSuffix(name, ".ts") {
+		return utils.ErrInvalidParameter
+	}
+	start, err1 := strconv.ParseInt(startMs, 10, 64)
+	duration, err2 := strconv.ParseInt(durationMs, 10, 64)
+	if err1 != nil || err2 != nil || start < 0 || duration <= 0 {
+		return utils.ErrInvalidParameter
+	}
+
+	var video models.Video
+	err := s.db.Where("id = ? AND workshop_id = ? AND status = ?", videoID, workshopID, config.VideoStatusNormal).First(&video).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.ErrFileNotFound
+		}
+		return fmt.Errorf("failed to get video: %v", err)
+	}
+	if float64(start+duration) > (video.Duration+1)*1000 {
+		return utils.ErrInvalidParameter
+	}
+	driver, err := s.storage.VideoDriver(&video)
+	if err != nil {
+		return err
+	}
+	input, err := driver.Presign(ctx, video.FilePath, storagePresignExpire)
+	if err != nil {
+		return err
+	}
+
+	// 起点和终点都移到之前最近的关键帧，相邻切片在同一个关键帧处分割，不重叠也不遗漏；
+	// 终点到达录像末尾时一直复制到文件结尾
+	from, to := float64(start)/1000, float64(start+duration)/1000
+	if from, err = s.keyFrameBefore(ctx, input, from); err != nil {
+		return err
+	}
+	if to >= video.Duration-playbackEndTolerance {
+		to = video.Duration + 1
+	} else if to, err = s.keyFrameBefore(ctx, input, to); err != nil {
+		return err
+	}
+	// 起点向上取整，FFmpeg 跳转到起点之前最近的关键帧，即切片开始的关键帧；
+	// 终点向下取整，不包含下一个切片开始的关键帧
+	from = math.Ceil(from*1000) / 1000
+	length := math.Floor((to-from)*1000) / 1000
+	if length <= 0 {
+		// 关键帧间隔大于切片时长时本切片没有独立的关键帧，内容包含在前一个切片中
+		return nil
+	}
+	return utils.NewFFmpeg(s.ffmpegPath).StreamSegment(ctx, input, from, length, w)
+}
+
+// splitPlayback 把录像中 start 到 end 的部分按固定时长分为切片，返回各切片的起止位置（毫秒）。
+// 分界点对齐到录像中切片时长的整数倍，不同时间段的播放列表可以共用切片；首尾不足半个切片时并入相邻切片
+func splitPlayback(start, end float64) [][2]int64 {
+	target := int64(playbackSegmentTarget * 1000)
+	from := int64(math.Round(start * 1000))
+	to := int64(math.Round(end * 1000))
+
+	var segments [][2]int64
+	for b := (from/target + 1) * target; b <= to-target/2; b += target {
+		if b-from < target/2 {
+			continue
+		}
+		segments = append(segments, [2]int64{from, b})
+		from = b
+	}
+	return append(segments, [2]int64{from, to})
+}
+
+// keyFrameBefore 查找录像中 t 之前最近的关键帧，只读取该关键帧到 t 之间的数据；
+// 没有找到时返回 t，由 FFmpeg 跳转到之前最近的关键帧
+func (s *TimelineService) keyFrameBefore(ctx context.Context, input string, t float64) (float64, error) {
+	const epsilon = 0.001
+	if t <= epsilon {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, playbackProbeTimeout)
+	defer cancel()
+	frames, err := utils.NewFFprobe(s.ffprobePath).KeyFrames(ctx, input, t, epsilon)
+	if err != nil {
+		return 0, err
+	}
+	frame := -1.0
+	for _, f := range frames {
+		if f <= t+epsilon && f > frame {
+			frame = f
+		}
+	}
+	if frame < 0 {
+		return t, nil
+	}
+	return frame, nil
+}
+
+// videos 与时间段有重叠的车间录像，按开始时间排序
+func (s *TimelineService) videos(workshopID uint, from, to time.Time) ([]models.Video, error) {
+	var videos []models.Video
+	err := s.db.Where("workshop_id = ? AND status = ? AND start_time < ? AND end_time > ?",
+		workshopID, config.VideoStatusNormal, to, from).
+		Order("start_time").Find(&videos).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get videos: %v", err)
+	}
+	return videos, nil
+}
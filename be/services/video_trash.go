@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+)
+
+// 回收站在存储内的目录
+const trashDir = ".trash"
+
+// trashKey 录像在回收站中的对象键，按录像ID区分避免重名
+func trashKey(video *models.Video) string {
+	return path.Join(trashDir, fmt.Sprintf("%d", video.ID), path.Base(filepath.ToSlash(video.FilePath)))
+}
+
+// trash 把录像标记为已删除，再把文件移入所在存储的回收站。
+// 先更新记录再移动文件，中途退出时文件仍在原位置，恢复和彻底删除时都会处理
+func (s *VideoService) trash(video *models.Video) error {
+	driver, err := s.storage.VideoDriver(video)
+	if err != nil {
+		return err
+	}
+
+	// 外部文件只读，只标记删除，文件保留在原位置
+	key := trashKey(video)
+	if video.External {
+		key = ""
+	}
+	result := s.db.Model(&models.Video{}).Scopes(unheldVideos(time.Now())).
+		Where("id = ? AND status = ?", video.ID, config.VideoStatusNormal).
+		Updates(map[string]interface{}{
+			"status":     config.VideoStatusDeleted,
+			"trash_path": key,
+			"trashed_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errVideoChanged
+	}
+	if video.External {
+		return nil
+	}
+
+	err = moveObject(context.Background(), driver, video.FilePath, key)
+	if errors.Is(err, utils.ErrFileNotFound) {
+		// 文件已不存在时只标记删除
+		return s.db.Model(video).Update("trash_path", "").Error
+	}
+	if err != nil {
+		s.db.Model(video).Updates(map[string]interface{}{
+			"status":     config.VideoStatusNormal,
+			"trash_path": "",
+			"trashed_at": nil,
+		})
+		return fmt.Errorf("failed to move video to trash: %v", err)
+	}
+	return nil
+}
+
+// Restore 从回收站恢复录像，文件移回原位置，有录像无权操作时不恢复任何录像
+func (s *VideoService) Restore(ids []uint, check VideoAccessCheck) error {
+	var videos []models.Video
+	if err := s.db.Where("id IN ? AND status = ?", ids, config.VideoStatusDeleted).Find(&videos).Error; err != nil {
+		return err
+	}
+	for i := range videos {
+		if err := check(&videos[i]); err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+	for i := range videos {
+		video := &videos[i]
+		// 外部文件删除时未移动，只恢复记录
+		if !video.External {
+			if err := s.restoreFile(ctx, video); err != nil {
+				return err
+			}
+		}
+
+		err := s.db.Model(video).Updates(map[string]interface{}{
+			"status":     config.VideoStatusNormal,
+			"trash_path": "",
+			"trashed_at": nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFile 把回收站中的文件移回原位置
+func (s *VideoService) restoreFile(ctx context.Context, video *models.Video) error {
+	if video.TrashPath == "" {
+		return fmt.Errorf("video %d has no file to restore", video.ID)
+	}
+	driver, err := s.storage.VideoDriver(video)
+	if err != nil {
+		return err
+	}
+
+	if _, err := driver.Stat(ctx, video.FilePath); err == nil {
+		// 原位置已有文件：回收站中也有时不覆盖，否则是移入回收站时中途退出，文件仍在原位置
+		if _, err := driver.Stat(ctx, video.TrashPath); err == nil {
+			return fmt.Errorf("video %d file already exists", video.ID)
+		}
+	} else if err := moveObject(ctx, driver, video.TrashPath, video.FilePath); err != nil {
+		return fmt.Errorf("failed to restore video %d: %v", video.ID, err)
+	}
+	return nil
+}
+
+// Purge 永久删除回收站中的录像，返回每个录像的删除结果
+func (s *VideoService) Purge(ids []uint) ([]VideoDeleteResult, error) {
+	var videos []models.Video
+	if err := s.db.Where("id IN ? AND status = ?", ids, config.VideoStatusDeleted).Find(&videos).Error; err != nil {
+		return nil, err
+	}
+
+	return deleteResults(ids, videos, func(video *models.Video) error {
+		return purgeVideo(context.Background(), s.db, s.storage, video)
+	}), nil
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
+
+	"gorm.io/gorm"
+)
+
+// 清除过期上传的检查间隔
+const uploadCleanInterval = time.Hour
+
+// VideoUploadService 上传录像文件。大文件按分片断点续传（与 tus 协议类似，
+// 每个分片带上起始位置），先写入临时目录，上传完成后用 ffprobe 校验并保存到车间的存储
+type VideoUploadService struct {
+	db          *gorm.DB
+	storage     *VideoStorage
+	ffprobePath string
+	uploadPath  string
+	maxSize     int64
+	expire      time.Duration
+
+	mutex  sync.Mutex
+	active map[string]bool // 正在写入的上传，同一上传不能同时写入
+}
+
+func NewVideoUploadService(cfg *config.Config, db *gorm.DB, storage *VideoStorage) *VideoUploadService {
+	s := &VideoUploadService{
+		db:          db,
+		storage:     storage,
+		ffprobePath: cfg.RTSP.FFprobePath,
+		uploadPath:  filepath.Join(cfg.Storage.TempPath, "uploads"),
+		maxSize:     int64(cfg.Storage.Upload.MaxSizeGB * (1 << 30)),
+		expire:      cfg.Storage.Upload.Expire,
+		active:      make(map[string]bool),
+	}
+	if s.expire <= 0 {
+		s.expire = 24 * time.Hour
+	}
+	go s.loop()
+	return s
+}
+
+// Create 创建分片上传，返回上传ID
+func (s *VideoUploadService) Create(req *models.VideoUploadRequest, userID uint) (*models.VideoUpload, error) {
+	if req.FileName == "" || req.Size <= 0 {
+		return nil, utils.ErrInvalidParameter
+	}
+	if !utils.ValidateVideoExt(req.FileName) {
+		return nil, utils.ErrInvalidFileType
+	}
+	if req.MimeType != "" && !strings.HasPrefix(req.MimeType, "video/") && req.MimeType != "application/octet-stream" {
+		return nil, utils.ErrInvalidFileType
+	}
+	if s.maxSize > 0 && req.Size > s.maxSize {
+		return nil, utils.ErrFileTooLarge
+	}
+	var workshop models.Workshop
+	if err := s.db.Select("id").First(&workshop, req.WorkshopID).Error; err != nil {
+		return nil, fmt.Errorf("workshop not found with id: %d", req.WorkshopID)
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate upload id: %v", err)
+	}
+	upload := &models.VideoUpload{
+		ID:         hex.EncodeToString(buf),
+		WorkshopID: req.WorkshopID,
+		FileName:   filepath.Base(req.FileName),
+		Size:       req.Size,
+		StartTime:  req.StartTime,
+		Notes:      req.Notes,
+		UserID:     userID,
+		ExpiresAt:  time.Now().Add(s.expire),
+	}
+
+	if err := utils.EnsureDir(s.uploadPath); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %v", err)
+	}
+	f, err := os.Create(s.tempFile(upload.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %v", err)
+	}
+	f.Close()
+
+	if err := s.db.Create(upload).Error; err != nil {
+		os.Remove(s.tempFile(upload.ID))
+		return nil, fmt.Errorf("failed to save upload: %v", err)
+	}
+	return upload, nil
+}
+
+// Get 获取上传进度
+func (s *VideoUploadService) Get(id string) (*models.VideoUpload, error) {
+	var upload models.VideoUpload
+	if err := s.db.Where("id = ?", id).First(&upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("upload not found with id: %s", id)
+		}
+		return nil, fmt.Errorf("failed to get upload: %v", err)
+	}
+
+	// 临时文件丢失时从头上传
+	if info, err := os.Stat(s.tempFile(id)); err == nil {
+		upload.Offset = info.Size()
+	}
+	return &upload, nil
+}
+
+// Write 从 offset 处追加分片，返回上传进度。全部上传后校验文件并登记为录像，
+// 此时返回创建的录像；保存到存储失败时可以再次提交空分片重试
+func (s *VideoUploadService) Write(ctx context.Context, id string, offset int64, r io.Reader) (*models.VideoUpload, *models.Video, error) {
+	if !s.acquire(id) {
+		return nil, nil, fmt.Errorf("upload is in progress")
+	}
+	defer s.release(id)
+
+	upload, err := s.Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset != upload.Offset {
+		return upload, nil, utils.ErrUploadOffset
+	}
+
+	if upload.Offset < upload.Size {
+		err := s.append(upload, r)
+		s.db.Model(upload).Update("expires_at", time.Now().Add(s.expire))
+		if err != nil {
+			return upload, nil, err
+		}
+		if upload.Offset < upload.Size {
+			return upload, nil, nil
+		}
+	}
+
+	video, err := s.complete(ctx, upload)
+	if err != nil {
+		return upload, nil, err
+	}
+	return upload, video, nil
+}
+
+// Upload 一次上传整个文件，用于较小的文件
+func (s *VideoUploadService) Upload(ctx context.Context, file *multipart.FileHeader, req *models.VideoUploadRequest, userID uint) (*models.Video, error) {
+	req.FileName = file.Filename
+	req.Size = file.Size
+	req.MimeType = file.Header.Get("Content-Type")
+
+	upload, err := s.Create(req, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.acquire(upload.ID) {
+		return nil, fmt.Errorf("upload is in progress")
+	}
+	defer s.release(upload.ID)
+
+	if err := utils.SaveUploadedFile(file, s.tempFile(upload.ID)); err != nil {
+		s.remove(upload.ID)
+		return nil, fmt.Errorf("failed to save uploaded file: %v", err)
+	}
+	upload.Offset = upload.Size
+
+	video, err := s.complete(ctx, upload)
+	if err != nil {
+		s.remove(upload.ID)
+		return nil, err
+	}
+	return video, nil
+}
+
+// Delete 取消上传并删除已上传的数据
+func (s *VideoUploadService) Delete(id string) error {
+	if !s.acquire(id) {
+		return fmt.Errorf("upload is in progress")
+	}
+	defer s.release(id)
+
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	return s.remove(id)
+}
+
+// append 把分片追加到临时文件，超过声明的大小时丢弃本次分片
+func (s *VideoUploadService) append(upload *models.VideoUpload, r io.Reader) error {
+	f, err := os.OpenFile(s.tempFile(upload.ID), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open upload file: %v", err)
+	}
+	defer f.Close()
+
+	remaining := upload.Size - upload.Offset
+	n, err := io.Copy(f, io.LimitReader(r, remaining+1))
+	if n > remaining {
+		f.Truncate(upload.Offset)
+		return utils.ErrFileTooLarge
+	}
+	// 连接中断时已写入的数据保留，客户端从新的位置继续上传
+	upload.Offset += n
+	if err != nil {
+		return fmt.Errorf("failed to write upload file: %v", err)
+	}
+	return nil
+}
+
+// complete 校验上传完成的文件，保存到车间的存储并登记为录像。
+// 文件不是有效录像时删除上传
+func (s *VideoUploadService) complete(ctx context.Context, upload *models.VideoUpload) (*models.Video, error) {
+	file := s.tempFile(upload.ID)
+	if err := sniffVideo(file); err != nil {
+		s.remove(upload.ID)
+		return nil, err
+	}
+	// ffprobe 执行失败时保留上传，可以重试；没有视频流的文件直接删除
+	info, err := utils.NewFFprobe(s.ffprobePath).Probe(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	if info.Video() == nil {
+		s.remove(upload.ID)
+		return nil, utils.ErrInvalidFileType
+	}
+
+	// 开始时间依次取上传时填写的时间、元数据中的录制时间、文件名中的时间
+	duration := time.Duration(info.Duration * float64(time.Second))
+	startTime := info.CreationTime
+	if upload.StartTime != nil {
+		startTime = *upload.StartTime
+	} else if startTime.IsZero() {
+		var ok bool
+		if startTime, ok = importFileTime(upload.FileName); !ok {
+			startTime = time.Now().Add(-duration)
+		}
+	}
+
+	target, err := s.storage.Target(upload.WorkshopID)
+	if err != nil {
+		return nil, err
+	}
+	key := path.Join("uploads", strconv.FormatUint(uint64(upload.WorkshopID), 10), utils.GenerateUniqueFileName(upload.FileName))
+	if err := target.Driver.Put(ctx, key, file); err != nil {
+		return nil, err
+	}
+
+	video := &models.Video{
+		FileName:   upload.FileName,
+		FilePath:   key,
+		Storage:    target.Type,
+		StorageID:  target.ID,
+		FileSize:   upload.Size,
+		WorkshopID: upload.WorkshopID,
+		StartTime:  startTime,
+		Status:     config.VideoStatusNormal,
+		Notes:      upload.Notes,
+	}
+	applyMediaInfo(video, info)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(video).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", upload.ID).Delete(&models.VideoUpload{}).Error
+	})
+	if err != nil {
+		target.Driver.Delete(ctx, key)
+		s.remove(upload.ID)
+		return nil, fmt.Errorf("failed to save video: %v", err)
+	}
+	return video, nil
+}
+
+// sniffVideo 按文件头判断文件类型，拒绝图片、文本、压缩包等明显不是录像的文件
+func sniffVideo(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open upload file: %v", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return utils.ErrInvalidFileType
+	}
+	contentType := http.DetectContentType(buf[:n])
+	if !strings.HasPrefix(contentType, "video/") && contentType != "application/octet-stream" {
+		return utils.ErrInvalidFileType
+	}
+	return nil
+}
+
+func (s *VideoUploadService) tempFile(id string) string {
+	return filepath.Join(s.uploadPath, id)
+}
+
+func (s *VideoUploadService) acquire(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.active[id] {
+		return false
+	}
+	s.active[id] = true
+	return true
+}
+
+func (s *VideoUploadService) release(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.active, id)
+}
+
+// remove 删除上传记录和临时文件
+func (s *VideoUploadService) remove(id string) error {
+	if err := s.db.Where("id = ?", id).Delete(&models.VideoUpload{}).Error; err != nil {
+		return fmt.Errorf("failed to delete upload: %v", err)
+	}
+	if err := os.Remove(s.tempFile(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete upload file: %v", err)
+	}
+	return nil
+}
+
+// loop 定期清除过期的上传
+func (s *VideoUploadService) loop() {
+	ticker := time.NewTicker(uploadCleanInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var ids []string
+		if err := s.db.Model(&models.VideoUpload{}).Where("expires_at < ?", time.Now()).Pluck("id", &ids).Error; err != nil {
+			fmt.Printf("Failed to get expired uploads: %v\n", err)
+			continue
+		}
+		for _, id := range ids {
+			if !s.acquire(id) {
+				continue
+			}
+			if err := s.remove(id); err != nil {
+				fmt.Printf("Failed to remove expired upload %s: %v\n", id, err)
+			}
+			s.release(id)
+		}
+	}
+}
@@ -1,32 +1,62 @@
 package services
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"sync"
 	"time"
 	"videodb/be/config"
+	"videodb/be/models"
+	"videodb/be/utils"
 
-	"github.com/aler9/gortsplib"
-	"github.com/aler9/gortsplib/pkg/url"
 	"github.com/pion/webrtc/v3"
 )
 
+// mediaSession 与一个 PeerConnection 绑定的媒体会话（实时预览或录像回放）
+type mediaSession interface {
+	close()
+}
+
 type WebRTCService struct {
-	config    *config.Config
-	connMutex sync.RWMutex
-	connMap   map[string]*webrtc.PeerConnection
-	rtspMap   map[string]*gortsplib.Client
+	config     *config.Config
+	hub        *StreamHub
+	tagSource  TagSource
+	connMutex  sync.RWMutex
+	connMap    map[string]*webrtc.PeerConnection
+	sessionMap map[string]mediaSession
 }
 
-func NewWebRTCService(config *config.Config) *WebRTCService {
+func NewWebRTCService(config *config.Config, hub *StreamHub, tagSource TagSource) *WebRTCService {
 	return &WebRTCService{
-		config:  config,
-		connMap: make(map[string]*webrtc.PeerConnection),
-		rtspMap: make(map[string]*gortsplib.Client),
+		config:     config,
+		hub:        hub,
+		tagSource:  tagSource,
+		connMap:    make(map[string]*webrtc.PeerConnection),
+		sessionMap: make(map[string]mediaSession),
 	}
 }
 
-func (s *WebRTCService) HandleRTSP(rtspURL string, offerSDP string) (*webrtc.SessionDescription, error) {
+// HandleRTSP 为指定车间建立 WebRTC 会话，码流地址由调用方从数据库中解析得到。
+// quality 为 main/sub 时固定使用对应码流，auto 时根据浏览器的接收报告自动切换。
+func (s *WebRTCService) HandleRTSP(workshopID uint, urls LiveStreamURLs, quality string, offerSDP string) (*webrtc.SessionDescription, error) {
+	// 连接ID中不包含RTSP地址，避免认证信息出现在日志和内存索引中
+	connID := fmt.Sprintf("%d-%d", workshopID, time.Now().UnixNano())
+	session := newLiveSession(s.hub, s.config.WebRTC, workshopID, urls, quality)
+	return s.handleLive(connID, session, offerSDP)
+}
+
+// HandleRTSPURL 直接预览任意 RTSP 地址（如添加车间前测试摄像头），同一地址的观看者共用一路拉流
+func (s *WebRTCService) HandleRTSPURL(rtspURL string, offerSDP string) (*webrtc.SessionDescription, error) {
+	hash := sha1.Sum([]byte(rtspURL))
+	connID := fmt.Sprintf("url-%d", time.Now().UnixNano())
+	session := newLiveSession(s.hub, s.config.WebRTC, 0, LiveStreamURLs{Main: rtspURL}, config.StreamQualityMain)
+	session.keyPrefix = "url/" + hex.EncodeToString(hash[:8])
+	return s.handleLive(connID, session, offerSDP)
+}
+
+func (s *WebRTCService) handleLive(connID string, session *liveSession, offerSDP string) (*webrtc.SessionDescription, error) {
 	// 创建 WebRTC 连接配置
 	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{
 		ICEServers: []webrtc.ICEServer{
@@ -49,47 +79,63 @@ func (s *WebRTCService) HandleRTSP(rtspURL string, offerSDP string) (*webrtc.Ses
 		return nil, fmt.Errorf("failed to set remote description: %v", err)
 	}
 
-	// 解析 RTSP URL
-	u, err := url.Parse(rtspURL)
-	if err != nil {
-		peerConnection.Close()
-		return nil, fmt.Errorf("failed to parse RTSP URL: %v", err)
+	// 从共享拉流中订阅画面，同一摄像头的多个观看者共用一路 RTSP 连接和转码
+	session.onEnded = func() {
+		// 拉流异常结束时关闭连接
+		s.CloseConnection(connID)
 	}
-
-	// 创建 RTSP 客户端
-	rtspClient := gortsplib.Client{}
-
-	// 连接到 RTSP 服务器
-	err = rtspClient.Start(u.Scheme, u.Host)
+	sub, err := session.start()
 	if err != nil {
 		peerConnection.Close()
-		return nil, fmt.Errorf("failed to connect to RTSP: %v", err)
+		return nil, err
 	}
 
-	// 创建视频轨道
-	videoTrack, err := webrtc.NewTrackLocalStaticRTP(
-		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264},
-		"video",
-		"pion",
-	)
+	// 创建视频轨道，转码后始终为 H264
+	videoTrack, err := webrtc.NewTrackLocalStaticRTP(sub.VideoCodec(), "video", "pion")
 	if err != nil {
-		rtspClient.Close()
+		session.close()
 		peerConnection.Close()
 		return nil, fmt.Errorf("failed to create video track: %v", err)
 	}
 
 	// 添加轨道到连接
-	_, err = peerConnection.AddTrack(videoTrack)
+	videoSender, err := peerConnection.AddTrack(videoTrack)
 	if err != nil {
-		rtspClient.Close()
+		session.close()
 		peerConnection.Close()
 		return nil, fmt.Errorf("failed to add track: %v", err)
 	}
 
+	// 浏览器请求了音频且摄像头带有可用的音频轨道时协商音频
+	var audioTrack *webrtc.TrackLocalStaticRTP
+	if capability, ok := sub.AudioCodec(); ok && offerWantsAudio(offerSDP) {
+		audioTrack, err = webrtc.NewTrackLocalStaticRTP(capability, "audio", "pion")
+		if err != nil {
+			session.close()
+			peerConnection.Close()
+			return nil, fmt.Errorf("failed to create audio track: %v", err)
+		}
+
+		_, err = peerConnection.AddTrack(audioTrack)
+		if err != nil {
+			session.close()
+			peerConnection.Close()
+			return nil, fmt.Errorf("failed to add audio track: %v", err)
+		}
+	}
+	session.setTracks(videoTrack, audioTrack)
+
+	// 前端创建名为 tags 的数据通道后，推送车间关联的工业实时数据
+	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() == "tags" && s.tagSource != nil && session.workshopID != 0 {
+			session.serveTags(dc, s.tagSource, s.config.WebRTC.TagInterval)
+		}
+	})
+
 	// 创建应答
 	answer, err := peerConnection.CreateAnswer(nil)
 	if err != nil {
-		rtspClient.Close()
+		session.close()
 		peerConnection.Close()
 		return nil, fmt.Errorf("failed to create answer: %v", err)
 	}
@@ -97,66 +143,139 @@ func (s *WebRTCService) HandleRTSP(rtspURL string, offerSDP string) (*webrtc.Ses
 	// 设置本地描述
 	err = peerConnection.SetLocalDescription(answer)
 	if err != nil {
-		rtspClient.Close()
+		session.close()
 		peerConnection.Close()
 		return nil, fmt.Errorf("failed to set local description: %v", err)
 	}
 
 	// 保存连接信息
-	connID := fmt.Sprintf("%s-%d", rtspURL, time.Now().UnixNano())
 	s.connMutex.Lock()
 	s.connMap[connID] = peerConnection
-	s.rtspMap[connID] = &rtspClient
+	s.sessionMap[connID] = session
 	s.connMutex.Unlock()
 
-	// 启动流转发
-	go s.streamRTSPToWebRTC(connID, &rtspClient, videoTrack, peerConnection)
+	// 浏览器断开后释放拉流资源
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			s.CloseConnection(connID)
+		}
+	})
+
+	// 读取浏览器的接收报告用于自适应码流切换
+	go session.readRTCP(videoSender)
 
 	return &answer, nil
 }
 
-func (s *WebRTCService) streamRTSPToWebRTC(
-	connID string,
-	rtspClient *gortsplib.Client,
-	videoTrack *webrtc.TrackLocalStaticRTP,
-	peerConnection *webrtc.PeerConnection,
-) {
-	defer func() {
-		s.connMutex.Lock()
-		delete(s.connMap, connID)
-		delete(s.rtspMap, connID)
-		s.connMutex.Unlock()
+// HandlePlayback 为录像建立 WebRTC 回放会话，从 offset 秒开始播放，source 为录像存储给出的读取地址。
+// 前端通过名为 control 的数据通道发送跳转、暂停和变速指令。
+func (s *WebRTCService) HandlePlayback(video *models.Video, source string, offset float64, offerSDP string) (*webrtc.SessionDescription, error) {
+	// 已读取媒体信息的录像按记录判断是否有音频，尚未读取的录像用 ffprobe 读取
+	hasAudio := video.AudioCodec != ""
+	if video.ProbedAt == nil {
+		media, err := utils.NewFFprobe(s.config.RTSP.FFprobePath).Probe(context.Background(), source)
+		if err != nil {
+			return nil, err
+		}
+		hasAudio = media.Audio() != nil
+	}
 
-		rtspClient.Close()
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{
+			{
+				URLs: []string{"stun:stun.l.google.com:19302"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create peer connection: %v", err)
+	}
+
+	err = peerConnection.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offerSDP,
+	})
+	if err != nil {
 		peerConnection.Close()
-	}()
+		return nil, fmt.Errorf("failed to set remote description: %v", err)
+	}
 
-	// 设置分段长度（从配置中获取）
-	segmentLength := s.config.RTSP.SegmentLength
+	connID := fmt.Sprintf("playback-%d-%d", video.ID, time.Now().UnixNano())
+	session := newPlaybackSession(s.hub, video, source, hasAudio && offerWantsAudio(offerSDP))
 
-	// 创建定时器用于分段处理
-	ticker := time.NewTicker(time.Duration(segmentLength) * time.Second)
-	defer ticker.Stop()
+	// 回放统一转码为 H264，音频转为 Opus
+	videoTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "pion")
+	if err != nil {
+		peerConnection.Close()
+		return nil, fmt.Errorf("failed to create video track: %v", err)
+	}
+	videoSender, err := peerConnection.AddTrack(videoTrack)
+	if err != nil {
+		peerConnection.Close()
+		return nil, fmt.Errorf("failed to add track: %v", err)
+	}
 
-	// 设置 RTP 数据的回调
-	rtspClient.OnPacketRTP = func(ctx *gortsplib.ClientOnPacketRTPCtx) {
-		// 使用 Marshal() 替代 Raw
-		data, err := ctx.Packet.Marshal()
+	var audioTrack *webrtc.TrackLocalStaticRTP
+	if session.hasAudio {
+		audioTrack, err = webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, "audio", "pion")
 		if err != nil {
-			fmt.Printf("Error marshaling RTP packet: %v\n", err)
-			return
+			peerConnection.Close()
+			return nil, fmt.Errorf("failed to create audio track: %v", err)
 		}
-		_, err = videoTrack.Write(data)
-		if err != nil {
-			fmt.Printf("Error writing RTP: %v\n", err)
+		if _, err = peerConnection.AddTrack(audioTrack); err != nil {
+			peerConnection.Close()
+			return nil, fmt.Errorf("failed to add audio track: %v", err)
 		}
 	}
+	session.setTracks(videoTrack, audioTrack)
+
+	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() == "control" {
+			session.serveControl(dc)
+		}
+	})
 
-	// 分段处理逻辑
-	for range ticker.C {
-		// 在这里可以添加分段处理逻辑，例如日志记录或数据切片
-		fmt.Println("Segment processed")
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		peerConnection.Close()
+		return nil, fmt.Errorf("failed to create answer: %v", err)
 	}
+
+	err = peerConnection.SetLocalDescription(answer)
+	if err != nil {
+		peerConnection.Close()
+		return nil, fmt.Errorf("failed to set local description: %v", err)
+	}
+
+	if err := session.play(offset); err != nil {
+		peerConnection.Close()
+		return nil, err
+	}
+
+	s.connMutex.Lock()
+	s.connMap[connID] = peerConnection
+	s.sessionMap[connID] = session
+	s.connMutex.Unlock()
+
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			s.CloseConnection(connID)
+		}
+	})
+
+	// 读取 RTCP 以便 pion 处理 NACK 等反馈
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := videoSender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	return &answer, nil
 }
 
 func (s *WebRTCService) CloseConnection(connID string) {
@@ -164,12 +283,12 @@ func (s *WebRTCService) CloseConnection(connID string) {
 	defer s.connMutex.Unlock()
 
 	if pc, ok := s.connMap[connID]; ok {
-		pc.Close()
 		delete(s.connMap, connID)
+		go pc.Close()
 	}
 
-	if client, ok := s.rtspMap[connID]; ok {
-		client.Close()
-		delete(s.rtspMap, connID)
+	if session, ok := s.sessionMap[connID]; ok {
+		session.close()
+		delete(s.sessionMap, connID)
 	}
 }
@@ -3,7 +3,10 @@ package services
 import (
 	"errors"
 	"fmt"
+	"net/url"
+	"videodb/be/config"
 	"videodb/be/models"
+	"videodb/be/utils"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -21,6 +24,12 @@ func NewWorkshopService(db *gorm.DB) *WorkshopService {
 func (s *WorkshopService) List() ([]models.Workshop, error) {
 	var workshops []models.Workshop
 	err := s.db.Find(&workshops).Error
+
+	// 不向前端返回RTSP密码
+	for i := range workshops {
+		workshops[i].RTSPPass = ""
+		workshops[i].RestreamPass = ""
+	}
 	return workshops, err
 }
 
@@ -31,9 +40,42 @@ func (s *WorkshopService) Create(workshop *models.Workshop) error {
 	}).Create(workshop).Error
 }
 
-// 更新车间信息
-func (s *WorkshopService) Update(id uint, workshop *models.Workshop) error {
-	return s.db.Model(&models.Workshop{}).Where("id = ?", id).Updates(workshop).Error
+// 更新车间信息，只更新请求中填写的字段
+func (s *WorkshopService) Update(id uint, req *models.WorkshopUpdateRequest) (*models.Workshop, error) {
+	// 字符串字段为空时不修改，存储目标通过单独的接口分配
+	workshop := models.Workshop{
+		Name:         req.Name,
+		RTSPUrl:      req.RTSPUrl,
+		SubRTSPUrl:   req.SubRTSPUrl,
+		RTSPUser:     req.RTSPUser,
+		RTSPPass:     req.RTSPPass,
+		RestreamUser: req.RestreamUser,
+		RestreamPass: req.RestreamPass,
+		Status:       req.Status,
+		Description:  req.Description,
+	}
+	if err := s.db.Model(&models.Workshop{}).Where("id = ?", id).Omit("storage_id").Updates(&workshop).Error; err != nil {
+		return nil, err
+	}
+
+	// 布尔开关和可以设为 0 的保留策略只在请求中填写时更新
+	updates := map[string]interface{}{}
+	if req.RecordAudio != nil {
+		updates["record_audio"] = *req.RecordAudio
+	}
+	if req.RetentionDays != nil {
+		updates["retention_days"] = *req.RetentionDays
+	}
+	if req.RetentionMaxGB != nil {
+		updates["retention_max_gb"] = *req.RetentionMaxGB
+	}
+	if len(updates) > 0 {
+		if err := s.db.Model(&models.Workshop{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return s.GetByID(id)
 }
 
 // 删除车间
@@ -61,3 +103,110 @@ func (s *WorkshopService) GetByID(id uint) (*models.Workshop, error) {
 
 	return &workshop, nil
 }
+
+// StreamURL 根据车间配置生成带认证信息的RTSP地址，仅供服务端内部使用
+func (s *WorkshopService) StreamURL(workshop *models.Workshop) (string, error) {
+	return workshopStreamURL(workshop)
+}
+
+// LiveStreamURLs 生成车间主/子码流地址，未配置子码流时 Sub 为空
+func (s *WorkshopService) LiveStreamURLs(workshop *models.Workshop) (LiveStreamURLs, error) {
+	var urls LiveStreamURLs
+	var err error
+	if urls.Main, err = workshopStreamURL(workshop); err != nil {
+		return urls, err
+	}
+	if workshop.SubRTSPUrl != "" {
+		if urls.Sub, err = buildStreamURL(workshop, workshop.SubRTSPUrl); err != nil {
+			return urls, err
+		}
+	}
+	return urls, nil
+}
+
+func workshopStreamURL(workshop *models.Workshop) (string, error) {
+	return buildStreamURL(workshop, workshop.RTSPUrl)
+}
+
+func buildStreamURL(workshop *models.Workshop, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid rtsp url for workshop %d: %v", workshop.ID, err)
+	}
+	if u.Scheme != "rtsp" && u.Scheme != "rtsps" {
+		return "", fmt.Errorf("unsupported stream scheme for workshop %d: %s", workshop.ID, u.Scheme)
+	}
+
+	if workshop.RTSPUser != "" {
+		u.User = url.UserPassword(workshop.RTSPUser, workshop.RTSPPass)
+	}
+	return u.String(), nil
+}
+
+// ListPermissions 获取可以查看车间画面的用户
+func (s *WorkshopService) ListPermissions(workshopID uint) ([]models.WorkshopPermission, error) {
+	var permissions []models.WorkshopPermission
+	if err := s.db.Where("workshop_id = ?", workshopID).Order("user_id").Find(&permissions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workshop permissions: %v", err)
+	}
+	return permissions, nil
+}
+
+// Grant 授予用户查看车间画面的权限，已有权限时不做修改
+func (s *WorkshopService) Grant(workshopID, userID uint) (*models.WorkshopPermission, error) {
+	if _, err := s.GetByID(workshopID); err != nil {
+		return nil, err
+	}
+
+	permission := models.WorkshopPermission{UserID: userID, WorkshopID: workshopID}
+	err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&permission).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to grant workshop permission: %v", err)
+	}
+	if err := s.db.Where("user_id = ? AND workshop_id = ?", userID, workshopID).First(&permission).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workshop permission: %v", err)
+	}
+	return &permission, nil
+}
+
+// Revoke 收回用户查看车间画面的权限
+func (s *WorkshopService) Revoke(workshopID, userID uint) error {
+	result := s.db.Where("user_id = ? AND workshop_id = ?", userID, workshopID).Delete(&models.WorkshopPermission{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke workshop permission: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
+
+// PermittedWorkshops 普通用户有权限的车间，没有权限时返回空列表
+func (s *WorkshopService) PermittedWorkshops(userID uint) ([]uint, error) {
+	ids := []uint{}
+	err := s.db.Model(&models.WorkshopPermission{}).Where("user_id = ?", userID).Pluck("workshop_id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workshop permissions: %v", err)
+	}
+	return ids, nil
+}
+
+// CheckAccess 检查用户是否有权限查看车间画面，管理员拥有所有车间的权限
+func (s *WorkshopService) CheckAccess(userID uint, role string, workshopID uint) error {
+	if role == config.RoleAdmin {
+		return nil
+	}
+
+	var count int64
+	err := s.db.Model(&models.WorkshopPermission{}).
+		Where("user_id = ? AND workshop_id = ?", userID, workshopID).
+		Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("failed to check workshop permission: %v", err)
+	}
+	if count == 0 {
+		return utils.ErrForbidden
+	}
+
+	return nil
+}
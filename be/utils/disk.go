@@ -0,0 +1,23 @@
+//go:build !windows
+
+package utils
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// DiskUsage 获取 path 所在文件系统的总容量和已用容量（字节）
+func DiskUsage(path string) (total, used uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, fmt.Errorf("failed to get disk usage: %v", err)
+	}
+
+	blockSize := uint64(stat.Bsize)
+	total = stat.Blocks * blockSize
+	// 与 df 一致，已用容量不包含保留给 root 的空间
+	used = total - stat.Bfree*blockSize
+	total = used + stat.Bavail*blockSize
+	return total, used, nil
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// DiskUsage 获取 path 所在磁盘的总容量和已用容量（字节）
+func DiskUsage(path string) (total, used uint64, err error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var free, totalBytes, totalFree uint64
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+	ret, _, callErr := proc.Call(
+		uintptr(unsafe.Pointer(name)),
+		uintptr(unsafe.Pointer(&free)),
+		uintptr(unsafe.Pointer(&totalBytes)),
+		uintptr(unsafe.Pointer(&totalFree)),
+	)
+	if ret == 0 {
+		return 0, 0, fmt.Errorf("failed to get disk usage: %v", callErr)
+	}
+	return totalBytes, totalBytes - totalFree, nil
+}
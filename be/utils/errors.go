@@ -10,7 +10,11 @@ var (
 	ErrInvalidStatus    = errors.New("invalid status")
 	ErrOperationFailed  = errors.New("operation failed")
 	ErrUnauthorized     = errors.New("unauthorized")
+	ErrForbidden        = errors.New("forbidden")
 	ErrFileNotFound     = errors.New("file not found")
 	ErrInvalidFileType  = errors.New("invalid file type")
 	ErrFileTooLarge     = errors.New("file too large")
+	ErrStreamNotReady   = errors.New("stream not ready")
+	ErrVideoHeld        = errors.New("video is under hold")
+	ErrUploadOffset     = errors.New("upload offset mismatch") // 分片起始位置与已上传的字节数不一致
 )
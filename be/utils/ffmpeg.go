@@ -1,8 +1,15 @@
 package utils
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -25,27 +32,6 @@ func (f *FFmpeg) CheckAvailable() error {
 	return cmd.Run()
 }
 
-// 获取视频信息
-func (f *FFmpeg) GetVideoInfo(filepath string) (map[string]string, error) {
-	cmd := exec.Command(f.BinPath,
-		"-i", filepath,
-		"-show_format",
-		"-show_streams",
-		"-v", "quiet",
-		"-print_format", "json",
-	)
-
-	_, err := cmd.Output()
-	if err != nil {
-		return nil, err
-	}
-
-	// 解析输出信息
-	info := make(map[string]string)
-	// 这里需要根据实际输出格式解析JSON
-	return info, nil
-}
-
 // 转换视频格式
 func (f *FFmpeg) ConvertVideo(ctx context.Context, input, output string, options map[string]string) error {
 	args := []string{"-i", input}
@@ -61,30 +47,200 @@ func (f *FFmpeg) ConvertVideo(ctx context.Context, input, output string, options
 	return cmd.Run()
 }
 
-// 生成视频缩略图
-func (f *FFmpeg) GenerateThumbnail(input, output string, timestamp string) error {
-	cmd := exec.Command(f.BinPath,
+// 生成视频缩略图，从 timestamp（秒）处截取一帧并缩放到 width 宽
+func (f *FFmpeg) GenerateThumbnail(ctx context.Context, input, output string, timestamp float64, width int) error {
+	cmd := exec.CommandContext(ctx, f.BinPath,
+		"-y", "-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", timestamp),
 		"-i", input,
-		"-ss", timestamp,
 		"-vframes", "1",
-		"-vf", "scale=320:-1",
+		"-vf", fmt.Sprintf("scale=%d:-2", width),
 		output,
 	)
 
-	return cmd.Run()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// 生成拖动预览图拼图：每隔 interval 秒截取一帧，缩放为 width x height（保持比例，不足部分填充黑边），
+// 按 columns 列拼成一张图片。只解码关键帧，长录像也能较快生成
+func (f *FFmpeg) GenerateSprite(ctx context.Context, input, output string, interval float64, width, height, columns, rows int) error {
+	filter := fmt.Sprintf("fps=1/%g,scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,tile=%dx%d",
+		interval, width, height, width, height, columns, rows)
+	cmd := exec.CommandContext(ctx, f.BinPath,
+		"-y", "-loglevel", "error",
+		"-skip_frame", "nokey",
+		"-i", input,
+		"-an",
+		"-vf", filter,
+		"-frames:v", "1",
+		"-q:v", "5",
+		output,
+	)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// 截取一帧 JPEG 图像，输入可以是视频文件或 RTSP 地址，width 大于 0 时等比缩放
+func (f *FFmpeg) CaptureFrame(ctx context.Context, input string, width int) ([]byte, error) {
+	args := []string{"-loglevel", "error"}
+	if strings.HasPrefix(input, "rtsp://") || strings.HasPrefix(input, "rtsps://") {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	args = append(args, "-i", input, "-vframes", "1")
+	if width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=w='min(%d,iw)':h=-2", width))
+	}
+	args = append(args, "-f", "image2", "-c:v", "mjpeg", "pipe:1")
+
+	cmd := exec.CommandContext(ctx, f.BinPath, args...)
+	return cmd.Output()
 }
 
-// 截取视频片段
-func (f *FFmpeg) CutVideo(input, output string, start, duration string) error {
-	cmd := exec.Command(f.BinPath,
+// CutOptions 截取视频片段的选项
+type CutOptions struct {
+	Reencode bool                  // 重新编码视频，否则直接复制视频流（起点须在关键帧上）
+	Audio    bool                  // 保留第一个音频流
+	Progress func(seconds float64) // 不为空时报告已处理的时长
+}
+
+// 截取视频片段，从 start（秒）处截取 duration 秒
+func (f *FFmpeg) CutVideo(ctx context.Context, input, output string, start, duration float64, opts CutOptions) error {
+	args := []string{
+		"-y", "-loglevel", "error", "-nostats", "-progress", "pipe:1",
+		"-ss", fmt.Sprintf("%.3f", start),
 		"-i", input,
-		"-ss", start,
-		"-t", duration,
+		"-t", fmt.Sprintf("%.3f", duration),
+		"-map", "0:v:0",
+	}
+	if opts.Audio {
+		args = append(args, "-map", "0:a:0?")
+	} else {
+		args = append(args, "-an")
+	}
+	if opts.Reencode {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p")
+	} else {
+		args = append(args, "-c:v", "copy", "-avoid_negative_ts", "make_zero")
+	}
+	// 摄像头的 G.711 等音频不能放入 MP4，音频统一转为 AAC
+	args = append(args, "-c:a", "aac", "-movflags", "+faststart", output)
+
+	return runWithProgress(exec.CommandContext(ctx, f.BinPath, args...), opts.Progress)
+}
+
+// 拼接编码参数相同的视频文件（直接复制流）
+func (f *FFmpeg) ConcatVideos(ctx context.Context, inputs []string, output string) error {
+	// concat 列表中的路径用单引号括起，路径中的单引号需转义
+	var list strings.Builder
+	for _, input := range inputs {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(input, "'", `'\''`))
+	}
+	listFile := output + ".txt"
+	if err := os.WriteFile(listFile, []byte(list.String()), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(listFile)
+
+	cmd := exec.CommandContext(ctx, f.BinPath,
+		"-y", "-loglevel", "error",
+		"-f", "concat", "-safe", "0",
+		"-i", listFile,
 		"-c", "copy",
+		"-movflags", "+faststart",
 		output,
 	)
 
-	return cmd.Run()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// 把视频从 start（秒）处开始的 duration 秒封装为 MPEG-TS 写入 w，用作 HLS 点播切片。
+// start 须在关键帧上；保留原始时间戳，同一视频的相邻切片可以连续播放
+func (f *FFmpeg) StreamSegment(ctx context.Context, input string, start, duration float64, w io.Writer) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, f.BinPath,
+		"-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", start),
+		"-t", fmt.Sprintf("%.3f", duration),
+		"-i", input,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-c:v", "copy", "-c:a", "aac",
+		"-copyts", "-muxdelay", "0",
+		"-f", "mpegts",
+		"pipe:1",
+	)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// 把视频从 start（秒）处开始实时转封装为分片 MP4 写入 w，不需要知道文件长度，
+// 用于 moov 在文件末尾或非 MP4 容器的录像边下载边播放
+func (f *FFmpeg) RemuxFragmented(ctx context.Context, input string, start float64, w io.Writer) error {
+	args := []string{"-loglevel", "error"}
+	if start > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%.3f", start))
+	}
+	args = append(args,
+		"-i", input,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-c:v", "copy", "-c:a", "aac",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4",
+		"pipe:1",
+	)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, f.BinPath, args...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// runWithProgress 执行带 -progress pipe:1 参数的命令，按输出的 out_time_us 报告进度
+func runWithProgress(cmd *exec.Cmd, progress func(float64)) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || key != "out_time_us" || progress == nil {
+			continue
+		}
+		// 开始输出前为 N/A
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+			progress(float64(us) / 1e6)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
 }
 
 // 检查RTSP流是否可用
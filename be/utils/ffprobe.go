@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 读取媒体信息的超时时间，对象存储中的文件需要通过网络读取
+const probeTimeout = 30 * time.Second
+
+// FFprobe工具结构体
+type FFprobe struct {
+	BinPath string
+}
+
+// MediaInfo 媒体文件信息
+type MediaInfo struct {
+	Format       string        `json:"format"`   // 容器格式，如 mov,mp4,m4a,3gp,3g2,mj2
+	Duration     float64       `json:"duration"` // 时长（秒）
+	BitRate      int64         `json:"bitRate"`  // 总码率（bit/s）
+	Size         int64         `json:"size"`
+	CreationTime time.Time     `json:"creationTime"` // 元数据中的录制时间，没有时为零值
+	Streams      []MediaStream `json:"streams"`
+}
+
+// MediaStream 媒体流信息
+type MediaStream struct {
+	Index      int     `json:"index"`
+	Type       string  `json:"type"` // video, audio, subtitle, data
+	Codec      string  `json:"codec"`
+	Profile    string  `json:"profile"`
+	BitRate    int64   `json:"bitRate"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	FrameRate  float64 `json:"frameRate"`
+	SampleRate int     `json:"sampleRate"`
+	Channels   int     `json:"channels"`
+}
+
+// 创建新的FFprobe实例
+func NewFFprobe(binPath string) *FFprobe {
+	if binPath == "" {
+		binPath = "ffprobe"
+	}
+	return &FFprobe{BinPath: binPath}
+}
+
+// Probe 读取媒体文件的格式和流信息，输入可以是本地路径或 URL
+func (f *FFprobe) Probe(ctx context.Context, input string) (*MediaInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, f.BinPath,
+		"-v", "error",
+		"-show_format",
+		"-show_streams",
+		"-of", "json",
+		input).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe media: %v", err)
+	}
+
+	var result struct {
+		Format struct {
+			FormatName string            `json:"format_name"`
+			Duration   string            `json:"duration"`
+			BitRate    string            `json:"bit_rate"`
+			Size       string            `json:"size"`
+			Tags       map[string]string `json:"tags"`
+		} `json:"format"`
+		Streams []struct {
+			Index        int    `json:"index"`
+			CodecType    string `json:"codec_type"`
+			CodecName    string `json:"codec_name"`
+			Profile      string `json:"profile"`
+			BitRate      string `json:"bit_rate"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+			RFrameRate   string `json:"r_frame_rate"`
+			SampleRate   string `json:"sample_rate"`
+			Channels     int    `json:"channels"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse probe result: %v", err)
+	}
+
+	info := &MediaInfo{
+		Format:  result.Format.FormatName,
+		Streams: make([]MediaStream, 0, len(result.Streams)),
+	}
+	// 部分字段在直播流、损坏的文件中可能没有，解析失败时为零值
+	info.Duration, _ = strconv.ParseFloat(result.Format.Duration, 64)
+	info.BitRate, _ = strconv.ParseInt(result.Format.BitRate, 10, 64)
+	info.Size, _ = strconv.ParseInt(result.Format.Size, 10, 64)
+	// 未设置录制时间的文件可能写入 1970 或 1904 年，视为没有
+	if t, err := time.Parse(time.RFC3339Nano, result.Format.Tags["creation_time"]); err == nil && t.Year() >= 2000 {
+		info.CreationTime = t.Local()
+	}
+
+	for _, s := range result.Streams {
+		stream := MediaStream{
+			Index:    s.Index,
+			Type:     s.CodecType,
+			Codec:    s.CodecName,
+			Profile:  s.Profile,
+			Width:    s.Width,
+			Height:   s.Height,
+			Channels: s.Channels,
+		}
+		stream.BitRate, _ = strconv.ParseInt(s.BitRate, 10, 64)
+		stream.SampleRate, _ = strconv.Atoi(s.SampleRate)
+		if stream.FrameRate = parseFrameRate(s.AvgFrameRate); stream.FrameRate == 0 {
+			stream.FrameRate = parseFrameRate(s.RFrameRate)
+		}
+		info.Streams = append(info.Streams, stream)
+	}
+	return info, nil
+}
+
+// KeyFrames 返回视频流在 start 秒之后 window 秒内的关键帧时间（秒），
+// 读取时会从 start 之前最近的关键帧开始，结果通常包含该关键帧。
+// 需要读取整段数据，超时时间由调用方根据 window 设置
+func (f *FFprobe) KeyFrames(ctx context.Context, input string, start, window float64) ([]float64, error) {
+	output, err := exec.CommandContext(ctx, f.BinPath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-read_intervals", fmt.Sprintf("%.3f%%+%.3f", start, window),
+		"-show_entries", "packet=pts_time,flags",
+		"-of", "csv=p=0",
+		input).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe key frames: %v", err)
+	}
+
+	var frames []float64
+	for _, line := range strings.Split(string(output), "\n") {
+		pts, flags, ok := strings.Cut(strings.TrimSpace(line), ",")
+		if !ok || !strings.Contains(flags, "K") {
+			continue
+		}
+		if t, err := strconv.ParseFloat(pts, 64); err == nil {
+			frames = append(frames, t)
+		}
+	}
+	return frames, nil
+}
+
+// Video 返回第一个视频流，没有时返回 nil
+func (m *MediaInfo) Video() *MediaStream {
+	return m.stream("video")
+}
+
+// Audio 返回第一个音频流，没有时返回 nil
+func (m *MediaInfo) Audio() *MediaStream {
+	return m.stream("audio")
+}
+
+func (m *MediaInfo) stream(streamType string) *MediaStream {
+	for i := range m.Streams {
+		if m.Streams[i].Type == streamType {
+			return &m.Streams[i]
+		}
+	}
+	return nil
+}
+
+// parseFrameRate 解析 ffprobe 输出的帧率，如 25/1、30000/1001
+func parseFrameRate(value string) float64 {
+	num, den, ok := strings.Cut(value, "/")
+	if !ok {
+		rate, _ := strconv.ParseFloat(value, 64)
+		return rate
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
@@ -102,6 +102,32 @@ export function batchDeleteVideos(ids) {
   })
 }
 
+// 导出录像片段
+export function createClip(data) {
+  return request({
+    url: '/api/clips',
+    method: 'post',
+    data
+  })
+}
+
+// 获取片段导出进度
+export function getClip(id) {
+  return request({
+    url: `/api/clips/${id}`,
+    method: 'get'
+  })
+}
+
+// 下载导出的片段
+export function downloadClip(id) {
+  return request({
+    url: `/api/clips/${id}/download`,
+    method: 'get',
+    responseType: 'blob'
+  })
+}
+
 // 获取视频统计信息
 export function getVideoStats() {
   return request({
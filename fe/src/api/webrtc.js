@@ -19,4 +19,39 @@ export function startWebRTC(data) {
         //console.log("Answer SDP:", response.data.sdp);
         return response
     })
-}
\ No newline at end of file
+}
+
+// 录像回放，data: { videoId, sdp, offset }
+// 建立连接后通过名为 control 的数据通道发送 seek/pause/play/rate 指令
+export function startPlayback(data) {
+    return request({
+        url: '/api/webrtc/playback',
+        method: 'post',
+        data,
+        headers: {
+            'Content-Type': 'application/json'
+        }
+    }).then(response => {
+        if (!response.data || !response.data.success || !response.data.sdp) {
+            throw new Error('Invalid response format')
+        }
+        return response
+    })
+}
+
+// 直接预览 RTSP 地址（仅管理员），data: { rtspUrl, sdp }
+export function startUrlPreview(data) {
+    return request({
+        url: '/api/webrtc/url',
+        method: 'post',
+        data,
+        headers: {
+            'Content-Type': 'application/json'
+        }
+    }).then(response => {
+        if (!response.data || !response.data.success || !response.data.sdp) {
+            throw new Error('Invalid response format')
+        }
+        return response
+    })
+}
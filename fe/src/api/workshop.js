@@ -1,4 +1,4 @@
-import request from './request'
+import request from '@/utils/request'
 
 // 获取车间列表
 // 获取车间列表
@@ -87,11 +87,13 @@ const actions = {
         }
     },
 
-    // 批量删除视频
+    // 批量删除视频，返回每个视频的删除结果
     async batchDeleteVideos({ commit }, ids) {
         try {
-            await batchDeleteVideos(ids)
-            commit('REMOVE_VIDEOS', ids)
+            const { data } = await batchDeleteVideos(ids)
+            const results = data || []
+            commit('REMOVE_VIDEOS', results.filter(item => item.success).map(item => item.id))
+            return results
         } catch (error) {
             console.error('删除视频失败:', error)
             // 或者转换错误信息
@@ -10,11 +10,11 @@ const service = axios.create({
 // 请求拦截器
 service.interceptors.request.use(
     config => {
-        // 可以在这里添加 token
-        // const token = localStorage.getItem('token')
-        // if (token) {
-        //   config.headers['Authorization'] = `Bearer ${token}`
-        // }
+        // 携带登录 token，实时预览等接口需要鉴权
+        const token = localStorage.getItem('token')
+        if (token) {
+            config.headers['Authorization'] = `Bearer ${token}`
+        }
         return config
     },
     error => {
@@ -8,13 +8,6 @@ module.exports = {
         pathRewrite: {
           '^/api': ''
         }
-      },
-      '/rtsp-api': {
-        target: 'http://localhost:5000',
-        changeOrigin: true,
-        pathRewrite: {
-          '^/rtsp-api': '/api'
-        }
       }
     }
   }